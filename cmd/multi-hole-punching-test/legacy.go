@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+)
+
+// legacyCmd punches a hole to a remote machine running the same command,
+// the public addresses are exchanged by hand.
+func legacyCmd(args []string) {
+	fs := flag.NewFlagSet("legacy", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: multi-hole-punching-test legacy <options> REMOTE_IP[:PORT]\n")
+		fs.PrintDefaults()
+	}
+
+	var natType string
+	var verbose bool
+	fs.StringVar(&natType, "src-nat-type", "", "easy|hard (type of NAT on the client side)")
+	fs.BoolVar(&verbose, "v", false, "log every probe")
+	fs.Parse(args)
+
+	observer := nat.WithObserver(newObserver(verbose))
+
+	if fs.NArg() != 1 {
+		fmt.Fprintf(os.Stderr, "error: missing remote IP")
+		os.Exit(1)
+	}
+	remoteAddr := fs.Arg(0)
+
+	var err error
+	if natType == "easy" {
+		_, err = nat.GuessRemotePort(remoteAddr, nat.Interactive(true), observer)
+	} else if natType == "hard" {
+		_, err = nat.GuessLocalPort(remoteAddr, observer)
+	} else {
+		err = nat.SimpleTest(remoteAddr, observer)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+	}
+}
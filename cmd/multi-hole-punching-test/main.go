@@ -1,40 +1,59 @@
 package main
 
 import (
-	"flag"
 	"fmt"
+	"log/slog"
 	"os"
 
 	"github.com/nohajc/wg-nat-traversal/common/nat"
 )
 
-func main() {
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage: multi-hole-punching-test <options> REMOTE_IP[:PORT]\n")
-		flag.PrintDefaults()
-	}
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: multi-hole-punching-test [COMMAND] <options>\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  run                        run the scenario matrix and report the results (default)\n")
+	fmt.Fprintf(os.Stderr, "  legacy <options> REMOTE_IP[:PORT]\n")
+	fmt.Fprintf(os.Stderr, "                             interactive test against a remote machine\n\n")
+	fmt.Fprintf(os.Stderr, "Run \"multi-hole-punching-test COMMAND -h\" for the options of the command.\n")
+}
 
-	var natType string
-	flag.StringVar(&natType, "src-nat-type", "", "easy|hard (type of NAT on the client side)")
-	flag.Parse()
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
 
-	if flag.NArg() != 1 {
-		fmt.Fprintf(os.Stderr, "error: missing remote IP")
-		os.Exit(1)
+func newObserver(verbose bool) nat.Observer {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
 	}
-	remoteAddr := flag.Arg(0)
+	return nat.SlogObserver(
+		slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})),
+	)
+}
 
-	var err error
-	if natType == "easy" {
-		_, err = nat.GuessRemotePort(remoteAddr, nat.Interactive(true))
-	} else if natType == "hard" {
-		_, err = nat.GuessLocalPort(remoteAddr)
-	} else {
-		// fmt.Fprintln(os.Stderr, "error: invalid NAT type; specify easy or hard")
-		// os.Exit(1)
-		err = nat.SimpleTest(remoteAddr)
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		usage()
+		return
+	}
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		cmd, args = args[0], args[1:]
 	}
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+
+	switch cmd {
+	case "run":
+		runCmd(args)
+	case "legacy":
+		legacyCmd(args)
+	// used by the netns mode of run
+	case "side":
+		sideCmd(args)
+	case "stun":
+		stunCmd(args)
+	default:
+		usage()
+		os.Exit(1)
 	}
 }
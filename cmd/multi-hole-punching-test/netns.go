@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/pion/transport/v2/stdnet"
+)
+
+// In the netns mode, the sides and the STUN servers run as child processes
+// (the side and stun commands) in their namespaces. A side talks to the parent
+// through JSON lines: it writes its public address (null if unknown),
+// reads the one of the peer and writes its result.
+
+// stunCmd serves STUN on the addresses until killed.
+func stunCmd(args []string) {
+	if len(args) == 0 {
+		fail(fmt.Errorf("usage: multi-hole-punching-test stun ADDR..."))
+	}
+	for _, addr := range args {
+		conn, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			fail(err)
+		}
+		go func() {
+			fail(nat.ServeSTUN(conn))
+		}()
+	}
+	fmt.Println("ready")
+	select {}
+}
+
+// sideCmd runs one side of a scenario on the host network.
+func sideCmd(args []string) {
+	fs := flag.NewFlagSet("side", flag.ExitOnError)
+	var stunList string
+	var verbose bool
+	sd := &side{}
+	fs.StringVar(&stunList, "stun", "", "comma-separated STUN servers")
+	fs.DurationVar(&sd.timeout, "timeout", 30*time.Second, "time limit")
+	fs.IntVar(&sd.sockets, "sockets", 384, "number of sockets opened behind hard NAT")
+	fs.BoolVar(&verbose, "v", false, "log every probe")
+	fs.Parse(args)
+
+	var err error
+	if sd.net, err = stdnet.NewNet(); err != nil {
+		fail(err)
+	}
+	for _, s := range strings.Split(stunList, ",") {
+		srv, err := nat.ParseSTUNServer(s)
+		if err != nil {
+			fail(err)
+		}
+		sd.stun = append(sd.stun, srv)
+	}
+	if verbose {
+		sd.observer = newObserver(true)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	in := bufio.NewScanner(os.Stdin)
+	sd.exchange = func(info *nat.STUNInfo) (*nat.STUNInfo, error) {
+		if err := enc.Encode(info); err != nil {
+			return nil, err
+		}
+		if !in.Scan() {
+			return nil, fmt.Errorf("error reading the peer address: %w", in.Err())
+		}
+		var peer *nat.STUNInfo
+		if err := json.Unmarshal(in.Bytes(), &peer); err != nil {
+			return nil, err
+		}
+		if peer == nil {
+			return nil, errPeerFailed
+		}
+		return peer, nil
+	}
+
+	if err := enc.Encode(sd.run()); err != nil {
+		fail(err)
+	}
+}
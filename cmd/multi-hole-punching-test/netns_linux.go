@@ -0,0 +1,252 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Topology of the netns mode (namespaces and their addresses):
+//
+//	host-a 192.168.1.2 - 192.168.1.1 nat-a 100.64.1.2 - 100.64.1.1 wan
+//	host-b 192.168.1.2 - 192.168.1.1 nat-b 100.64.2.2 - 100.64.2.1 wan
+//
+// The wan namespace routes between the NATs and runs the STUN servers.
+var netnsSTUNIPs = []string{"203.0.113.1", "203.0.113.2"}
+
+// Like a home router, the NAT drops unsolicited packets for itself. Accepting them
+// would create conntrack entries clashing with the mappings of punched holes.
+const nftRuleset = `table ip nat {
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		oifname "wan" %s
+	}
+	chain input {
+		type filter hook input priority filter; policy accept;
+		iifname "wan" drop
+	}
+}
+`
+
+// time for the child processes to finish after the scenario timeout
+const netnsGrace = 10 * time.Second
+
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+type topology struct {
+	prefix string
+	// namespaces to delete
+	created []string
+}
+
+func (t *topology) ns(role string) string {
+	return t.prefix + role
+}
+
+func (t *topology) command(ctx context.Context, role string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "ip", append([]string{"netns", "exec", t.ns(role)}, args...)...)
+}
+
+func (t *topology) run(role string, stdin string, args ...string) error {
+	cmd := t.command(context.Background(), role, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s in %s: %w: %s", strings.Join(args, " "), role, err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func (t *topology) close() {
+	for _, ns := range t.created {
+		ip("netns", "del", ns)
+	}
+}
+
+func newTopology(s scenario) (_ *topology, err error) {
+	t := &topology{prefix: fmt.Sprintf("mhp%d-", os.Getpid())}
+	defer func() {
+		if err != nil {
+			t.close()
+		}
+	}()
+
+	for _, role := range []string{"wan", "nat-a", "host-a", "nat-b", "host-b"} {
+		if err := ip("netns", "add", t.ns(role)); err != nil {
+			return nil, err
+		}
+		t.created = append(t.created, t.ns(role))
+	}
+
+	wan := t.ns("wan")
+	cmds := [][]string{{"-n", wan, "link", "set", "dev", "lo", "up"}}
+	for _, addr := range netnsSTUNIPs {
+		cmds = append(cmds, []string{"-n", wan, "addr", "add", addr + "/32", "dev", "lo"})
+	}
+	for i, l := range []string{"a", "b"} {
+		natNS, host := t.ns("nat-"+l), t.ns("host-"+l)
+		wanIP := fmt.Sprintf("100.64.%d.1", i+1)
+		cmds = append(cmds,
+			[]string{"link", "add", "wan", "netns", natNS, "type", "veth", "peer", "name", l, "netns", wan},
+			[]string{"link", "add", "lan", "netns", natNS, "type", "veth", "peer", "name", "eth0", "netns", host},
+			[]string{"-n", wan, "addr", "add", wanIP + "/24", "dev", l},
+			[]string{"-n", wan, "link", "set", "dev", l, "up"},
+			[]string{"-n", natNS, "addr", "add", fmt.Sprintf("100.64.%d.2/24", i+1), "dev", "wan"},
+			[]string{"-n", natNS, "addr", "add", "192.168.1.1/24", "dev", "lan"},
+			[]string{"-n", natNS, "link", "set", "dev", "wan", "up"},
+			[]string{"-n", natNS, "link", "set", "dev", "lan", "up"},
+			[]string{"-n", natNS, "route", "add", "default", "via", wanIP},
+			[]string{"-n", host, "addr", "add", "192.168.1.2/24", "dev", "eth0"},
+			[]string{"-n", host, "link", "set", "dev", "eth0", "up"},
+			[]string{"-n", host, "link", "set", "dev", "lo", "up"},
+			[]string{"-n", host, "route", "add", "default", "via", "192.168.1.1"},
+		)
+	}
+	for _, args := range cmds {
+		if err := ip(args...); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, role := range []string{"wan", "nat-a", "nat-b"} {
+		if err := t.run(role, "", "sysctl", "-qw", "net.ipv4.ip_forward=1"); err != nil {
+			return nil, err
+		}
+	}
+	for _, side := range []struct {
+		role string
+		p    profile
+	}{{"nat-a", s.a}, {"nat-b", s.b}} {
+		if err := t.run(side.role, fmt.Sprintf(nftRuleset, side.p.nft), "nft", "-f", "-"); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// sideProc is a side running as a child process.
+type sideProc struct {
+	cmd   *exec.Cmd
+	stdin *os.File
+	out   *bufio.Scanner
+}
+
+func (t *topology) startSide(ctx context.Context, role string, stunServers []string, cfg runConfig) (*sideProc, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{exe, "side",
+		"-stun", strings.Join(stunServers, ","),
+		"-timeout", cfg.timeout.String(),
+		"-sockets", strconv.Itoa(cfg.sockets),
+	}
+	if cfg.verbose {
+		args = append(args, "-v")
+	}
+
+	cmd := t.command(ctx, role, args...)
+	cmd.Stderr = os.Stderr
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = stdin
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	stdin.Close()
+	return &sideProc{cmd: cmd, stdin: w, out: bufio.NewScanner(stdout)}, nil
+}
+
+// line reads the next message of the side, "null" if it exited.
+func (p *sideProc) line() []byte {
+	if !p.out.Scan() {
+		return []byte("null")
+	}
+	return append([]byte(nil), p.out.Bytes()...)
+}
+
+func (p *sideProc) result() sideResult {
+	var res sideResult
+	if err := json.Unmarshal(p.line(), &res); err != nil || res == (sideResult{}) {
+		res.Error = "the side process failed"
+	}
+	return res
+}
+
+// runNetns runs the sides in network namespaces behind nftables NATs.
+func runNetns(s scenario, cfg runConfig) (a, b sideResult, err error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return a, b, err
+	}
+	t, err := newTopology(s)
+	if err != nil {
+		return a, b, err
+	}
+	defer t.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout+netnsGrace)
+	defer cancel()
+
+	var stunAddrs, stunServers []string
+	for _, ip := range netnsSTUNIPs {
+		addr := net.JoinHostPort(ip, strconv.Itoa(stunPort))
+		stunAddrs = append(stunAddrs, addr)
+		stunServers = append(stunServers, "stun:"+addr)
+	}
+	stun := t.command(ctx, "wan", append([]string{exe, "stun"}, stunAddrs...)...)
+	stun.Stderr = os.Stderr
+	stunOut, err := stun.StdoutPipe()
+	if err != nil {
+		return a, b, err
+	}
+	if err := stun.Start(); err != nil {
+		return a, b, err
+	}
+	defer func() {
+		stun.Process.Kill()
+		stun.Wait()
+	}()
+	if !bufio.NewScanner(stunOut).Scan() {
+		return a, b, fmt.Errorf("STUN server failed to start")
+	}
+
+	var sides [2]*sideProc
+	for i, role := range []string{"host-a", "host-b"} {
+		if sides[i], err = t.startSide(ctx, role, stunServers, cfg); err != nil {
+			return a, b, err
+		}
+		defer func(p *sideProc) {
+			p.stdin.Close()
+			p.cmd.Wait()
+		}(sides[i])
+	}
+
+	infos := [2][]byte{sides[0].line(), sides[1].line()}
+	for i, p := range sides {
+		p.stdin.Write(append(infos[1-i], '\n'))
+	}
+	return sides[0].result(), sides[1].result(), nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func runNetns(s scenario, cfg runConfig) (a, b sideResult, err error) {
+	return a, b, errors.New("netns mode is only supported on Linux")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+)
+
+const (
+	// both sides in-process on an emulated network
+	modeVnet = "vnet"
+	// each side in its own network namespace behind an nftables NAT
+	modeNetns = "netns"
+)
+
+const stunPort = 3478
+
+// runConfig is shared by all scenarios of a run.
+type runConfig struct {
+	mode     string
+	timeout  time.Duration
+	sockets  int
+	observer nat.Observer
+	verbose  bool
+}
+
+// result of a scenario
+type result struct {
+	Scenario string        `json:"scenario"`
+	A        sideResult    `json:"a"`
+	B        sideResult    `json:"b"`
+	Pass     bool          `json:"pass"`
+	Reason   string        `json:"reason,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// evaluate compares the outcome of a scenario with the expected one:
+// both NATs are detected correctly and the peers connect unless both NATs are hard.
+func evaluate(s scenario, a, b sideResult) result {
+	r := result{
+		Scenario: s.String(),
+		A:        a,
+		B:        b,
+		Duration: max(a.Duration, b.Duration),
+	}
+
+	sides := []struct {
+		name string
+		p    profile
+		res  sideResult
+	}{{"A", s.a, a}, {"B", s.b, b}}
+
+	for _, side := range sides {
+		if side.res.NAT == nil {
+			r.Reason = fmt.Sprintf("%s: %s", side.name, side.res.Error)
+			return r
+		}
+		if *side.res.NAT != side.p.kind {
+			r.Reason = fmt.Sprintf("%s: detected %s NAT, expected %s", side.name, side.res.NAT, side.p.kind)
+			return r
+		}
+	}
+
+	if !s.feasible() {
+		r.Pass = a.Strategy == rendezvous.StrategyInfeasible && b.Strategy == rendezvous.StrategyInfeasible
+		if !r.Pass {
+			r.Reason = "hole punching should not have been attempted"
+		}
+		return r
+	}
+	for _, side := range sides {
+		if side.res.Error != "" {
+			r.Reason = fmt.Sprintf("%s: %s", side.name, side.res.Error)
+			return r
+		}
+	}
+	r.Pass = true
+	return r
+}
+
+func runScenario(s scenario, cfg runConfig) result {
+	var a, b sideResult
+	var err error
+	if cfg.mode == modeNetns {
+		a, b, err = runNetns(s, cfg)
+	} else {
+		a, b, err = runVnet(s, cfg)
+	}
+	if err != nil {
+		return result{Scenario: s.String(), Reason: err.Error()}
+	}
+	return evaluate(s, a, b)
+}
+
+func printProfiles() {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROFILE\tNAT\tMODES")
+	for _, p := range profiles {
+		var modes []string
+		for _, mode := range []string{modeVnet, modeNetns} {
+			if p.supports(mode) {
+				modes = append(modes, mode)
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.name, p.kind, strings.Join(modes, ","))
+	}
+	tw.Flush()
+}
+
+func strategy(r result) string {
+	if r.A.Strategy == r.B.Strategy {
+		return r.A.Strategy
+	}
+	return r.A.Strategy + "/" + r.B.Strategy
+}
+
+func detected(res sideResult) string {
+	if res.NAT == nil {
+		return "?"
+	}
+	return res.NAT.String()
+}
+
+func printReport(results []result) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCENARIO\tNAT A\tNAT B\tSTRATEGY\tPROBES\tTIME\tRESULT\tREASON")
+	for _, r := range results {
+		res := "FAIL"
+		if r.Pass {
+			res = "pass"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			r.Scenario, detected(r.A), detected(r.B), strategy(r),
+			r.A.Probes+r.B.Probes, r.Duration.Round(time.Millisecond), res, r.Reason)
+	}
+	tw.Flush()
+}
+
+// runCmd runs the scenarios one by one. It exits with status 1 if any of them failed.
+func runCmd(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: multi-hole-punching-test run <options>\n")
+		fs.PrintDefaults()
+	}
+
+	cfg := runConfig{}
+	var scenarioList string
+	var list, jsonOutput bool
+	fs.StringVar(&cfg.mode, "mode", modeVnet, "vnet (in-process emulated network) or netns (network namespaces with nftables NAT, needs root)")
+	fs.StringVar(&scenarioList, "scenarios", "", "comma-separated NAT profile pairs (e.g. full-cone/symmetric) or profiles (all their pairs), default all pairs")
+	fs.DurationVar(&cfg.timeout, "timeout", 30*time.Second, "time limit of a scenario")
+	fs.IntVar(&cfg.sockets, "sockets", 384, "number of sockets opened behind hard NAT")
+	fs.BoolVar(&list, "list", false, "list NAT profiles")
+	fs.BoolVar(&jsonOutput, "json", false, "JSON output")
+	fs.BoolVar(&cfg.verbose, "v", false, "log every probe")
+	fs.Parse(args)
+
+	if list {
+		printProfiles()
+		return
+	}
+	if cfg.mode != modeVnet && cfg.mode != modeNetns {
+		fail(fmt.Errorf("invalid mode %q", cfg.mode))
+	}
+	if cfg.verbose {
+		cfg.observer = newObserver(true)
+	}
+
+	var items []string
+	if scenarioList != "" {
+		items = strings.Split(scenarioList, ",")
+	}
+	scenarios, err := parseScenarios(items, cfg.mode)
+	if err != nil {
+		fail(err)
+	}
+
+	var results []result
+	failed := 0
+	for _, s := range scenarios {
+		fmt.Fprintf(os.Stderr, "running %s ...\n", s)
+		r := runScenario(s, cfg)
+		if !r.Pass {
+			failed++
+		}
+		results = append(results, r)
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			fail(err)
+		}
+	} else {
+		printReport(results)
+		fmt.Printf("\n%d/%d scenarios passed\n", len(results)-failed, len(results))
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/pion/transport/v2/vnet"
+)
+
+// profile is an emulated NAT.
+type profile struct {
+	name string
+	// kind which should be detected by STUN
+	kind nat.NAT
+	// behavior in the in-process mode, nil if not supported there
+	vnet *vnet.NATType
+	// nftables statement of the NAT in the netns mode, empty if not supported there
+	nft string
+}
+
+var profiles = []profile{
+	{
+		name: "full-cone",
+		kind: nat.NAT_EASY,
+		vnet: &vnet.NATType{
+			MappingBehavior:   vnet.EndpointIndependent,
+			FilteringBehavior: vnet.EndpointIndependent,
+		},
+	},
+	{
+		name: "restricted",
+		kind: nat.NAT_EASY,
+		vnet: &vnet.NATType{
+			MappingBehavior:   vnet.EndpointIndependent,
+			FilteringBehavior: vnet.EndpointAddrDependent,
+		},
+	},
+	{
+		// the Linux default, source ports are kept if possible
+		name: "port-restricted",
+		kind: nat.NAT_EASY,
+		vnet: &vnet.NATType{
+			MappingBehavior:   vnet.EndpointIndependent,
+			FilteringBehavior: vnet.EndpointAddrPortDependent,
+		},
+		nft: "masquerade",
+	},
+	{
+		name: "symmetric",
+		kind: nat.NAT_HARD,
+		vnet: &vnet.NATType{
+			MappingBehavior:   vnet.EndpointAddrPortDependent,
+			FilteringBehavior: vnet.EndpointAddrPortDependent,
+		},
+		nft: "masquerade random",
+	},
+	{
+		name: "fully-random",
+		kind: nat.NAT_HARD,
+		nft:  "masquerade fully-random",
+	},
+}
+
+func (p profile) supports(mode string) bool {
+	if mode == modeNetns {
+		return p.nft != ""
+	}
+	return p.vnet != nil
+}
+
+func findProfile(name string) (profile, bool) {
+	for _, p := range profiles {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return profile{}, false
+}
+
+// profileNames returns the profiles supported by the mode, all of them for an empty mode.
+func profileNames(mode string) []string {
+	var names []string
+	for _, p := range profiles {
+		if mode == "" || p.supports(mode) {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// scenario is a pair of peers, each behind its own NAT.
+type scenario struct {
+	a, b profile
+}
+
+func (s scenario) String() string {
+	return s.a.name + "/" + s.b.name
+}
+
+// feasible reports whether hole punching should succeed.
+func (s scenario) feasible() bool {
+	return s.a.kind == nat.NAT_EASY || s.b.kind == nat.NAT_EASY
+}
+
+// parseScenarios returns the scenarios given as A/B pairs of profiles,
+// a single profile stands for all pairs it is part of.
+// All pairs of the profiles supported by the mode are returned for an empty list.
+func parseScenarios(list []string, mode string) ([]scenario, error) {
+	names := profileNames(mode)
+	if len(list) == 0 {
+		list = names
+	}
+
+	var scenarios []scenario
+	seen := map[string]bool{}
+	add := func(a, b string) error {
+		var s scenario
+		for i, name := range []string{a, b} {
+			p, ok := findProfile(name)
+			if !ok {
+				return fmt.Errorf("unknown NAT profile %q (known: %s)", name, strings.Join(profileNames(""), ", "))
+			}
+			if !p.supports(mode) {
+				return fmt.Errorf("NAT profile %s is not supported in %s mode", name, mode)
+			}
+			if i == 0 {
+				s.a = p
+			} else {
+				s.b = p
+			}
+		}
+		// the sides are symmetric, A/B is the same as B/A
+		if !seen[s.String()] && !seen[s.b.name+"/"+s.a.name] {
+			seen[s.String()] = true
+			scenarios = append(scenarios, s)
+		}
+		return nil
+	}
+
+	for _, item := range list {
+		if a, b, ok := strings.Cut(item, "/"); ok {
+			if err := add(a, b); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, b := range names {
+			if err := add(item, b); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return scenarios, nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/pion/transport/v2"
+)
+
+const (
+	verifyPayload  = "PING"
+	verifyInterval = 50 * time.Millisecond
+	// how long to keep sending after the peer's packet arrived, so that it gets ours too
+	verifyLinger = 500 * time.Millisecond
+)
+
+var errInfeasible = errors.New("both sides are behind hard NAT")
+
+// sideResult is the outcome of one side of a scenario.
+type sideResult struct {
+	// detected by STUN, nil if detection failed
+	NAT      *nat.NAT      `json:"nat,omitempty"`
+	Strategy string        `json:"strategy,omitempty"`
+	Probes   int           `json:"probes_sent"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// side is one peer of a scenario, it does what wgnt-client does
+// (without Wireguard and the server) and then checks that the punched ports work.
+type side struct {
+	net      transport.Net
+	stun     []nat.STUNSrv
+	timeout  time.Duration
+	sockets  int
+	observer nat.Observer
+	// exchange publishes our public address (nil if it is unknown)
+	// and returns the one of the peer
+	exchange func(info *nat.STUNInfo) (*nat.STUNInfo, error)
+}
+
+func (s *side) run() sideResult {
+	var res sideResult
+	start := time.Now()
+	connected, err := s.punch(&res, start.Add(s.timeout))
+	if err != nil {
+		res.Error = err.Error()
+		connected = time.Now()
+	}
+	res.Duration = connected.Sub(start)
+	return res
+}
+
+// punch returns when the peer's packet arrived through the punched hole.
+func (s *side) punch(res *sideResult, deadline time.Time) (time.Time, error) {
+	conn, err := s.net.ListenUDP("udp", &net.UDPAddr{})
+	if err != nil {
+		s.exchange(nil)
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	info, err := nat.GetPublicAddrWithNATKind(conn, s.stun...)
+	if err != nil {
+		s.exchange(nil)
+		return time.Time{}, fmt.Errorf("STUN error: %w", err)
+	}
+	res.NAT = &info.NATKind
+
+	peer, err := s.exchange(info)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if info.NATKind == nat.NAT_HARD && peer.NATKind == nat.NAT_HARD {
+		res.Strategy = rendezvous.StrategyInfeasible
+		return time.Time{}, errInfeasible
+	}
+
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+	remote := &net.UDPAddr{IP: net.ParseIP(peer.PublicIP), Port: peer.PublicPort}
+	res.Strategy = rendezvous.StrategyDirect
+
+	var stats nat.Stats
+	opts := []nat.Option{
+		nat.WithNet(s.net),
+		nat.WithStats(&stats),
+		nat.WithObserver(s.observer),
+		nat.WithTimeout(time.Until(deadline)),
+		nat.WithSockets(s.sockets),
+	}
+	if info.NATKind == nat.NAT_EASY && peer.NATKind == nat.NAT_HARD {
+		res.Strategy = rendezvous.StrategyGuessRemote
+		remote.Port, err = nat.GuessRemotePort(peer.PublicIP, append(opts,
+			nat.WithConn(conn),
+			nat.WithPubAddr(info.PublicIP, info.PublicPort),
+		)...)
+	} else if info.NATKind == nat.NAT_HARD {
+		res.Strategy = rendezvous.StrategyGuessLocal
+		localPort, err = nat.GuessLocalPort(remote.String(), opts...)
+	}
+	res.Probes = stats.ProbesSent
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// the port is handed over, like to Wireguard
+	conn.Close()
+	return verify(s.net, localPort, remote, deadline)
+}
+
+// verify checks that packets pass both ways between the local port and the remote address.
+// It returns when the first packet of the peer arrived.
+func verify(n transport.Net, localPort int, remote *net.UDPAddr, deadline time.Time) (time.Time, error) {
+	conn, err := n.ListenUDP("udp", &net.UDPAddr{Port: localPort})
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+
+	received := make(chan struct{})
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			from, ok := addr.(*net.UDPAddr)
+			if ok && from.IP.Equal(remote.IP) && from.Port == remote.Port && string(buf[:n]) == verifyPayload {
+				close(received)
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(verifyInterval)
+	defer ticker.Stop()
+	timeout := time.After(time.Until(deadline))
+	var linger <-chan time.Time
+	var connected time.Time
+
+	for {
+		if _, err := conn.WriteTo([]byte(verifyPayload), remote); err != nil {
+			return time.Time{}, err
+		}
+		select {
+		case <-received:
+			received = nil
+			connected = time.Now()
+			linger = time.After(verifyLinger)
+		case <-linger:
+			return connected, nil
+		case <-timeout:
+			return time.Time{}, fmt.Errorf("no packets from %s after hole punching", remote)
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/pion/logging"
+	"github.com/pion/transport/v2/vnet"
+)
+
+// addresses of the emulated network
+var (
+	vnetSTUNIPs   = []string{"1.0.0.1", "1.0.0.2"}
+	vnetPublicIPs = [2]string{"27.0.0.1", "28.0.0.1"}
+)
+
+const vnetLANCIDR = "192.168.0.0/24"
+
+var errPeerFailed = errors.New("the peer failed to get its public address")
+
+// exchanger passes the public addresses between the sides running in-process.
+type exchanger struct {
+	ch      [2]chan *nat.STUNInfo
+	timeout time.Duration
+}
+
+func newExchanger(timeout time.Duration) *exchanger {
+	return &exchanger{
+		ch:      [2]chan *nat.STUNInfo{make(chan *nat.STUNInfo, 1), make(chan *nat.STUNInfo, 1)},
+		timeout: timeout,
+	}
+}
+
+func (e *exchanger) side(i int) func(*nat.STUNInfo) (*nat.STUNInfo, error) {
+	return func(info *nat.STUNInfo) (*nat.STUNInfo, error) {
+		e.ch[i] <- info
+		select {
+		case peer := <-e.ch[1-i]:
+			if peer == nil {
+				return nil, errPeerFailed
+			}
+			return peer, nil
+		case <-time.After(e.timeout):
+			return nil, errors.New("timed out waiting for the peer")
+		}
+	}
+}
+
+// runVnet runs both sides in-process. The network is emulated by pion/vnet:
+// a WAN router with two STUN servers and a NAT router in front of each side.
+func runVnet(s scenario, cfg runConfig) (a, b sideResult, err error) {
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	loggerFactory.DefaultLogLevel = logging.LogLevelDisabled
+
+	wan, err := vnet.NewRouter(&vnet.RouterConfig{
+		CIDR:          "0.0.0.0/0",
+		LoggerFactory: loggerFactory,
+	})
+	if err != nil {
+		return a, b, err
+	}
+
+	stunNet, err := vnet.NewNet(&vnet.NetConfig{StaticIPs: vnetSTUNIPs})
+	if err != nil {
+		return a, b, err
+	}
+	if err := wan.AddNet(stunNet); err != nil {
+		return a, b, err
+	}
+
+	var hosts [2]*vnet.Net
+	for i, p := range []profile{s.a, s.b} {
+		natType := *p.vnet
+		lan, err := vnet.NewRouter(&vnet.RouterConfig{
+			CIDR:          vnetLANCIDR,
+			StaticIPs:     []string{vnetPublicIPs[i]},
+			NATType:       &natType,
+			LoggerFactory: loggerFactory,
+		})
+		if err != nil {
+			return a, b, err
+		}
+		if err := wan.AddRouter(lan); err != nil {
+			return a, b, err
+		}
+		if hosts[i], err = vnet.NewNet(&vnet.NetConfig{}); err != nil {
+			return a, b, err
+		}
+		if err := lan.AddNet(hosts[i]); err != nil {
+			return a, b, err
+		}
+	}
+
+	if err := wan.Start(); err != nil {
+		return a, b, err
+	}
+	defer wan.Stop()
+
+	var stunServers []nat.STUNSrv
+	for _, ip := range vnetSTUNIPs {
+		addr := net.JoinHostPort(ip, fmt.Sprint(stunPort))
+		conn, err := stunNet.ListenPacket("udp", addr)
+		if err != nil {
+			return a, b, err
+		}
+		defer conn.Close()
+		go nat.ServeSTUN(conn)
+		stunServers = append(stunServers, nat.STUNSrv("stun:"+addr))
+	}
+
+	ex := newExchanger(cfg.timeout)
+	results := [2]chan sideResult{make(chan sideResult), make(chan sideResult)}
+	for i := range hosts {
+		sd := &side{
+			net:      hosts[i],
+			stun:     stunServers,
+			timeout:  cfg.timeout,
+			sockets:  cfg.sockets,
+			observer: cfg.observer,
+			exchange: ex.side(i),
+		}
+		go func(i int) {
+			results[i] <- sd.run()
+		}(i)
+	}
+	return <-results[0], <-results[1], nil
+}
@@ -1,144 +1,151 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
-	"net/http"
 	"net/netip"
-	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"golang.zx2c4.com/wireguard/wgctrl"
-	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
 )
 
 type WgClient struct {
-	client   *wgctrl.Client
-	iface    string
+	wg       *wireguard.WgClient
 	serverIP string
+	hub      *rendezvous.Client
 }
 
 func NewWgClient(iface, serverIP string) (*WgClient, error) {
-	client, err := wgctrl.New()
+	hub, err := rendezvous.NewClient(rendezvous.ServerURL(serverIP))
 	if err != nil {
 		return nil, err
 	}
+	wg, err := wireguard.NewWgClient(iface)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(wg, serverIP, hub), nil
+}
+
+// newClient allows using any Wireguard backend, e.g. a fake one.
+func newClient(wg *wireguard.WgClient, serverIP string, hub *rendezvous.Client) *WgClient {
 	return &WgClient{
-		client:   client,
-		iface:    iface,
+		wg:       wg,
 		serverIP: serverIP,
-	}, nil
+		hub:      hub,
+	}
 }
 
+// getServerPubKey finds the peer of the server: the one connecting
+// to the server address or, if the server is reached through the tunnel,
+// the one routing it.
 func (c *WgClient) getServerPubKey() (string, error) {
-	serverIP := strings.Split(c.serverIP, ":")[0]
-
-	dev, err := c.client.Device(c.iface)
+	addrs, err := net.LookupHost(c.hub.Hostname())
 	if err != nil {
 		return "", err
 	}
 
-	for _, p := range dev.Peers {
-		for _, ip := range p.AllowedIPs {
-			if serverIP == ip.IP.String() {
-				return p.PublicKey.String(), nil
-			}
+	for _, addr := range addrs {
+		key, err := c.wg.FindPeerByRemoteIP(addr)
+		if err == nil {
+			return key, nil
+		}
+		if !errors.Is(err, wireguard.ErrPeerNotFound) {
+			return "", err
 		}
 	}
-	return "", fmt.Errorf("no peer corresponding to the server")
-}
-
-func (c *WgClient) getPeerList() []string {
-	dev, err := c.client.Device(c.iface)
-	if err != nil {
-		return nil
-	}
-
-	var peerPubKeys []string
-	for _, p := range dev.Peers {
-		peerPubKeys = append(peerPubKeys, p.PublicKey.String())
+	for _, addr := range addrs {
+		p, err := c.wg.FindPeerByAllowedIP(addr)
+		if err == nil {
+			return p.PublicKey.String(), nil
+		}
+		if !errors.Is(err, wireguard.ErrPeerNotFound) {
+			return "", err
+		}
 	}
-	return peerPubKeys
+	return "", fmt.Errorf("no peer corresponding to the server")
 }
 
-func (c *WgClient) getExternalEndpoint(peer string) string {
-	escapedPeer := url.QueryEscape(peer)
-	request := fmt.Sprintf("http://%s?pubkey=%s", c.serverIP, escapedPeer)
-	resp, err := http.Get(request)
-	if err != nil {
-		return ""
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return ""
-	}
+func (c *WgClient) setPeer(peer string, endpoint netip.AddrPort) error {
+	log.Printf("setting %s endpoint to %s\n", peer, endpoint)
 
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return ""
-	}
-	return strings.TrimSpace(string(respBytes))
+	return c.wg.SetPeerRemotePort(peer, endpoint.Addr().String(), int(endpoint.Port()))
 }
 
-func (c *WgClient) setPeer(peer, endpoint string) error {
-	endpointAddrPort, err := netip.ParseAddrPort(endpoint)
-	if err != nil {
-		return err
-	}
-	endpointUDPAddr := net.UDPAddrFromAddrPort(endpointAddrPort)
-	keepalive := 25 * time.Second
-
-	pubKey, err := wgtypes.ParseKey(peer)
+// resolvePeers sets the endpoints of the peers which differ from the ones
+// known by the server. It reports whether any peer has no recent handshake.
+func (c *WgClient) resolvePeers(ctx context.Context, serverPubKey string) (stale bool, err error) {
+	peers, err := c.wg.GetPeers()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	log.Printf("setting %s endpoint to %s\n", peer, endpoint)
-
-	return c.client.ConfigureDevice(c.iface, wgtypes.Config{
-		Peers: []wgtypes.PeerConfig{
-			{
-				PublicKey:                   pubKey,
-				Endpoint:                    endpointUDPAddr,
-				PersistentKeepaliveInterval: &keepalive,
-			},
-		},
-	})
-}
+	var errs []error
+	for _, p := range peers {
+		key := p.PublicKey.String()
+		if key == serverPubKey {
+			continue
+		}
+		if time.Since(p.LastHandshakeTime) > handshakeTimeout {
+			stale = true
+		}
 
-func (c *WgClient) resolvePeers(serverPubKey string) {
-	for _, p := range c.getPeerList() {
-		if p == serverPubKey {
-			log.Printf("skipping %s", p)
+		endpoint, err := c.hub.SimpleHubEndpoint(ctx, key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting %s endpoint: %w", key, err))
+			continue
+		}
+		if endpoint == "" {
+			log.Printf("endpoint of %s unknown to the server", key)
 			continue
 		}
-		endpoint := c.getExternalEndpoint(p)
-		if endpoint != "" {
-			err := c.setPeer(p, endpoint)
-			if err != nil {
-				log.Printf("error configuring peer: %v", err)
+		addrPort, err := netip.ParseAddrPort(endpoint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s endpoint: %w", key, err))
+			continue
+		}
+		if p.Endpoint != nil {
+			cur := p.Endpoint.AddrPort()
+			if netip.AddrPortFrom(cur.Addr().Unmap(), cur.Port()) == addrPort {
+				continue
 			}
 		}
+		if err := c.setPeer(key, addrPort); err != nil {
+			errs = append(errs, fmt.Errorf("error configuring %s: %w", key, err))
+		}
 	}
+	return stale, errors.Join(errs...)
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		log.Fatalf("usage: %s SERVER_IP[:PORT] [WG_IFACE]", os.Args[0])
+	syncMode := flag.Bool("sync", false, "keep the endpoints in sync with the server until interrupted")
+	interval := flag.Duration("interval", 30*time.Second, "refresh interval in sync mode")
+	fastInterval := flag.Duration("fast-interval", 5*time.Second, "refresh interval while a peer has no recent handshake or the refresh failed")
+	serverPubKey := flag.String("server-key", "", "public key of the server peer (default the peer connecting to or routing the server address)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] SERVER_IP[:PORT]|SERVER_URL [WG_IFACE]\n", os.Args[0])
+		flag.PrintDefaults()
 	}
+	flag.Parse()
 
-	serverIP := os.Args[1]
-	if !strings.Contains(serverIP, ":") {
-		serverIP += ":8080"
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
 	}
 
+	serverIP := flag.Arg(0)
+
 	iface := "wg0"
-	if len(os.Args) > 2 {
-		iface = os.Args[2]
+	if flag.NArg() > 1 {
+		iface = flag.Arg(1)
 	}
 
 	client, err := NewWgClient(iface, serverIP)
@@ -146,11 +153,21 @@ func main() {
 		log.Fatal(err)
 	}
 
-	serverPubKey, err := client.getServerPubKey()
-	if err != nil {
-		log.Fatal(err)
+	if *serverPubKey == "" {
+		*serverPubKey, err = client.getServerPubKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *syncMode {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		client.syncPeers(ctx, *serverPubKey, *interval, *fastInterval)
+		return
 	}
-	// fmt.Println(serverPubKey)
 
-	client.resolvePeers(serverPubKey)
+	if _, err := client.resolvePeers(context.Background(), *serverPubKey); err != nil {
+		log.Fatal(err)
+	}
 }
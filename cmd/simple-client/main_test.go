@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.PublicKey()
+}
+
+func endpointOf(t *testing.T, wg *wireguard.WgClient, key wgtypes.Key) string {
+	t.Helper()
+	p, err := wg.FindPeerByPublicKey(key.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Endpoint == nil {
+		return ""
+	}
+	return p.Endpoint.String()
+}
+
+func TestResolvePeers(t *testing.T) {
+	const (
+		serverEndpoint = "203.0.113.1:51820"
+		oldEndpoint    = "198.51.100.2:51820"
+		newEndpoint    = "198.51.100.1:40000"
+	)
+
+	tests := []struct {
+		name string
+		// endpoints of the hub by peer (a, b or server), missing peers are unknown
+		hub map[string]string
+		// statuses of the hub by peer, instead of the endpoint
+		status    map[string]int
+		json      bool
+		reachable bool
+		failNext  error
+		// endpoint of a afterwards, b keeps oldEndpoint
+		want    string
+		configs int
+		err     bool
+		stale   bool
+	}{
+		{name: "changed", hub: map[string]string{"a": newEndpoint, "b": oldEndpoint}, want: newEndpoint, configs: 1, stale: true},
+		{name: "json", hub: map[string]string{"a": newEndpoint, "b": oldEndpoint}, json: true, want: newEndpoint, configs: 1, stale: true},
+		{name: "handshake", hub: map[string]string{"a": newEndpoint, "b": oldEndpoint}, reachable: true, want: newEndpoint, configs: 1, stale: true},
+		{name: "server peer ignored", hub: map[string]string{"b": oldEndpoint, "server": newEndpoint}, stale: true},
+		{name: "unknown to hub", hub: map[string]string{"b": oldEndpoint}, stale: true},
+		{name: "empty endpoint", hub: map[string]string{"a": "", "b": oldEndpoint}, stale: true},
+		{name: "hub error", hub: map[string]string{"b": oldEndpoint}, status: map[string]int{"a": http.StatusInternalServerError}, err: true, stale: true},
+		{name: "invalid endpoint", hub: map[string]string{"a": "198.51.100.1", "b": oldEndpoint}, err: true, stale: true},
+		{name: "configure failure", hub: map[string]string{"a": newEndpoint, "b": oldEndpoint}, failNext: errors.New("configure failed"), configs: 1, err: true, stale: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := wireguard.NewFake("wg0")
+			wg := wireguard.NewFakeClient(f, "wg0")
+			keys := map[string]wgtypes.Key{"a": newKey(t), "b": newKey(t), "server": newKey(t)}
+			names := map[string]string{}
+			for name, key := range keys {
+				names[key.String()] = name
+			}
+			for _, spec := range []wireguard.PeerSpec{
+				{PublicKey: keys["server"].String(), Endpoint: serverEndpoint, AllowedIPs: []string{"10.0.0.1/32"}},
+				{PublicKey: keys["a"].String(), AllowedIPs: []string{"10.0.0.2/32"}},
+				{PublicKey: keys["b"].String(), Endpoint: oldEndpoint, AllowedIPs: []string{"10.0.0.3/32"}},
+			} {
+				if err := wg.AddPeer(spec); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f.Handshake("wg0", keys["b"])
+			f.Reset()
+			if tt.reachable {
+				f.SetReachable(newEndpoint)
+			}
+			if tt.failNext != nil {
+				f.FailNext(tt.failNext)
+			}
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				key := r.URL.Query().Get("pubkey")
+				name := names[key]
+				if name == "server" {
+					t.Errorf("server peer looked up")
+				}
+				if st, ok := tt.status[name]; ok {
+					w.WriteHeader(st)
+					return
+				}
+				endpoint, ok := tt.hub[name]
+				switch {
+				case !ok:
+					w.WriteHeader(http.StatusNotFound)
+				case tt.json:
+					w.Header().Set("Content-Type", "application/json")
+					json.NewEncoder(w).Encode(rendezvous.SimpleHubPeer{PublicKey: key, Endpoint: endpoint})
+				default:
+					w.Write([]byte(endpoint + "\n"))
+				}
+			}))
+			defer srv.Close()
+			hub, err := rendezvous.NewClient(srv.URL, rendezvous.WithBackoff(rendezvous.Backoff{Attempts: 1}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := newClient(wg, srv.Listener.Addr().String(), hub)
+
+			stale, err := client.resolvePeers(context.Background(), keys["server"].String())
+			if (err != nil) != tt.err {
+				t.Errorf("got error %v, want error %v", err, tt.err)
+			}
+			if stale != tt.stale {
+				t.Errorf("got stale %v, want %v", stale, tt.stale)
+			}
+			if n := len(f.Configs()); n != tt.configs {
+				t.Errorf("got %d configuration requests, want %d", n, tt.configs)
+			}
+			if got := endpointOf(t, wg, keys["a"]); got != tt.want {
+				t.Errorf("endpoint of a %q, want %q", got, tt.want)
+			}
+			if got := endpointOf(t, wg, keys["b"]); got != oldEndpoint {
+				t.Errorf("endpoint of b %q, want %q", got, oldEndpoint)
+			}
+			if got := endpointOf(t, wg, keys["server"]); got != serverEndpoint {
+				t.Errorf("endpoint of the server %q, want %q", got, serverEndpoint)
+			}
+
+			if tt.reachable {
+				// nothing to do once the peer is connected
+				f.Reset()
+				stale, err := client.resolvePeers(context.Background(), keys["server"].String())
+				if err != nil || stale || len(f.Configs()) != 0 {
+					t.Errorf("got stale %v, %v, %d configuration requests", stale, err, len(f.Configs()))
+				}
+			}
+		})
+	}
+}
+
+func TestGetServerPubKey(t *testing.T) {
+	f := wireguard.NewFake("wg0")
+	wg := wireguard.NewFakeClient(f, "wg0")
+	direct, routing := newKey(t), newKey(t)
+	for _, spec := range []wireguard.PeerSpec{
+		{PublicKey: direct.String(), Endpoint: "127.0.0.1:51820", AllowedIPs: []string{"10.0.0.1/32"}},
+		{PublicKey: routing.String(), AllowedIPs: []string{"10.0.0.0/24", "192.0.2.0/24"}},
+	} {
+		if err := wg.AddPeer(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		server string
+		want   wgtypes.Key
+	}{
+		{"127.0.0.1:8080", direct},
+		// reached through the tunnel
+		{"192.0.2.10:8080", routing},
+		{"10.0.0.1:8080", direct},
+	}
+	for _, tt := range tests {
+		hub, err := rendezvous.NewClient(rendezvous.ServerURL(tt.server))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := newClient(wg, tt.server, hub).getServerPubKey()
+		if err != nil {
+			t.Errorf("%s: %v", tt.server, err)
+			continue
+		}
+		if key != tt.want.String() {
+			t.Errorf("%s: got %s, want %s", tt.server, key, tt.want)
+		}
+	}
+
+	hub, err := rendezvous.NewClient(rendezvous.ServerURL("198.51.100.1:8080"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := newClient(wg, "198.51.100.1:8080", hub).getServerPubKey(); err == nil {
+		t.Errorf("got %s for an unknown server", key)
+	}
+}
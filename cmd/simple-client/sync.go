@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// how long after the last handshake a peer is considered disconnected,
+// sessions in use are renewed every 2 minutes
+const handshakeTimeout = 3 * time.Minute
+
+// syncPeers keeps the endpoints in sync with the server until ctx is done.
+// Peers without a recent handshake may be waiting for a new endpoint,
+// so they are refreshed more often, like after a failure.
+func (c *WgClient) syncPeers(ctx context.Context, serverPubKey string, interval, fastInterval time.Duration) {
+	for {
+		wait := interval
+		stale, err := c.resolvePeers(ctx, serverPubKey)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("refresh failed: %v", err)
+		}
+		if err != nil || stale {
+			wait = fastInterval
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -1,51 +1,124 @@
 package main
 
 import (
-	"fmt"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
 
-	"golang.zx2c4.com/wireguard/wgctrl"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 type WgClient struct {
-	client *wgctrl.Client
-	iface  string
+	wg *wireguard.WgClient
 }
 
 func NewWgClient(iface string) (*WgClient, error) {
-	client, err := wgctrl.New()
+	wg, err := wireguard.NewWgClient(iface)
 	if err != nil {
 		return nil, err
 	}
-	return &WgClient{
-		client: client,
-		iface:  iface,
-	}, nil
+	return &WgClient{wg: wg}, nil
 }
 
-func (c *WgClient) getEndpoint(peer string) string {
-	dev, err := c.client.Device(c.iface)
+func hubPeer(p wgtypes.Peer) rendezvous.SimpleHubPeer {
+	hp := rendezvous.SimpleHubPeer{
+		PublicKey:     p.PublicKey.String(),
+		LastHandshake: p.LastHandshakeTime,
+		ReceiveBytes:  p.ReceiveBytes,
+		TransmitBytes: p.TransmitBytes,
+		AllowedIPs:    []string{},
+	}
+	if p.Endpoint != nil {
+		hp.Endpoint = p.Endpoint.String()
+	}
+	for _, ipNet := range p.AllowedIPs {
+		hp.AllowedIPs = append(hp.AllowedIPs, ipNet.String())
+	}
+	return hp
+}
+
+func (c *WgClient) getPeer(pubKey string) (rendezvous.SimpleHubPeer, error) {
+	p, err := c.wg.FindPeerByPublicKey(pubKey)
 	if err != nil {
-		return ""
-	}
-	for _, p := range dev.Peers {
-		if p.PublicKey.String() == peer {
-			if p.Endpoint == nil {
-				return ""
-			}
-			return p.Endpoint.String()
-		}
+		return rendezvous.SimpleHubPeer{}, err
+	}
+	return hubPeer(p), nil
+}
+
+func (c *WgClient) getPeers() ([]rendezvous.SimpleHubPeer, error) {
+	peers, err := c.wg.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	hubPeers := make([]rendezvous.SimpleHubPeer, 0, len(peers))
+	for _, p := range peers {
+		hubPeers = append(hubPeers, hubPeer(p))
+	}
+	return hubPeers, nil
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json encode error: %v", err)
 	}
-	return ""
 }
 
+func writeError(w http.ResponseWriter, st int) {
+	http.Error(w, http.StatusText(st), st)
+}
+
+// makeHandler reports the peer given by the pubkey parameter.
 func makeHandler(c *WgClient) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path != "/":
+			writeError(w, http.StatusNotFound)
+			return
+		case r.Method != http.MethodGet:
+			writeError(w, http.StatusMethodNotAllowed)
+			return
+		}
+
 		pubKey := r.URL.Query().Get("pubkey")
-		endpoint := c.getEndpoint(pubKey)
-		_, _ = fmt.Fprintln(w, endpoint)
+		if _, err := wgtypes.ParseKey(pubKey); err != nil {
+			writeError(w, http.StatusBadRequest)
+			return
+		}
+
+		peer, err := c.getPeer(pubKey)
+		if errors.Is(err, wireguard.ErrPeerNotFound) {
+			writeError(w, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("error reading peers: %v", err)
+			writeError(w, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, peer)
+	}
+}
+
+// makePeersHandler reports all peers, e.g. for monitoring.
+func makePeersHandler(c *WgClient) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed)
+			return
+		}
+
+		peers, err := c.getPeers()
+		if err != nil {
+			log.Printf("error reading peers: %v", err)
+			writeError(w, http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, peers)
 	}
 }
 
@@ -59,5 +132,6 @@ func main() {
 		log.Fatal(err)
 	}
 	http.HandleFunc("/", makeHandler(client))
+	http.HandleFunc("/peers", makePeersHandler(client))
 	log.Fatal(http.ListenAndServe(":8080", nil))
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/certs"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wgnt-admin <options> COMMAND\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  peers                      list registered peers\n")
+	fmt.Fprintf(os.Stderr, "  clients                    list peers connected over WebSocket\n")
+	fmt.Fprintf(os.Stderr, "  traversals                 list recent traversal attempts\n")
+	fmt.Fprintf(os.Stderr, "  evict [-n NETWORK] PUBKEY  remove peer registration\n")
+	fmt.Fprintf(os.Stderr, "  kick [-n NETWORK] PUBKEY   close peer WebSocket connection\n\n")
+	fmt.Fprintf(os.Stderr, "Options:\n")
+	flag.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fail(err)
+	}
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+}
+
+func since(t time.Time) string {
+	return time.Since(t).Round(time.Second).String()
+}
+
+func main() {
+	var serverURL, token, caFile, pins string
+	var jsonOutput bool
+
+	flag.Usage = usage
+	flag.StringVar(&serverURL, "s", "", "server IP/hostname[:port] or URL")
+	flag.StringVar(&token, "t", "", "admin token (default $WGNT_ADMIN_TOKEN)")
+	flag.StringVar(&caFile, "ca", "", "CA bundle for verifying the server certificate")
+	flag.StringVar(&pins, "pin", "", "comma-separated sha256/<base64> pins of the server certificate or its CA")
+	flag.BoolVar(&jsonOutput, "json", false, "JSON output")
+	flag.Parse()
+
+	if serverURL == "" || flag.NArg() < 1 {
+		usage()
+		os.Exit(1)
+	}
+	if token == "" {
+		token = os.Getenv("WGNT_ADMIN_TOKEN")
+	}
+
+	var opts []rendezvous.Option
+	if caFile != "" || pins != "" {
+		var pinList []string
+		if pins != "" {
+			pinList = strings.Split(pins, ",")
+		}
+		tlsConfig, err := certs.ClientConfig(caFile, pinList)
+		if err != nil {
+			fail(err)
+		}
+		opts = append(opts, rendezvous.WithTLSConfig(tlsConfig))
+	}
+
+	client, err := rendezvous.NewAdminClient(rendezvous.ServerURL(serverURL), token, opts...)
+	if err != nil {
+		fail(err)
+	}
+
+	ctx := context.Background()
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	switch cmd {
+	case "peers":
+		peers, err := client.Peers(ctx)
+		if err != nil {
+			fail(err)
+		}
+		if jsonOutput {
+			printJSON(peers)
+			return
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "NETWORK\tPUBKEY\tENDPOINT\tNAT\tTAGS\tREGISTERED\tEXPIRES IN")
+		for _, p := range peers {
+			fmt.Fprintf(tw, "%s\t%s\t%s:%d\t%s\t%s\t%s ago\t%s\n",
+				p.Network, p.PubKey, p.Info.PublicIP, p.Info.PublicPort, p.Info.NATKind,
+				strings.Join(p.Tags, ","), since(p.RegisteredAt),
+				time.Until(p.ExpiresAt).Round(time.Second))
+		}
+		tw.Flush()
+
+	case "clients":
+		clients, err := client.Clients(ctx)
+		if err != nil {
+			fail(err)
+		}
+		if jsonOutput {
+			printJSON(clients)
+			return
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "NETWORK\tPUBKEY\tREMOTE ADDR\tTAGS\tCONNECTED\tQUEUE")
+		for _, c := range clients {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s ago\t%d\n",
+				c.Network, c.PubKey, c.RemoteAddr, strings.Join(c.Tags, ","),
+				since(c.ConnectedAt), c.QueueLen)
+		}
+		tw.Flush()
+
+	case "traversals":
+		traversals, err := client.Traversals(ctx)
+		if err != nil {
+			fail(err)
+		}
+		if jsonOutput {
+			printJSON(traversals)
+			return
+		}
+		tw := newTable()
+		fmt.Fprintln(tw, "TIME\tNETWORK\tFROM\tTO\tOUTCOME")
+		for _, t := range traversals {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+				t.Time.Format(time.DateTime), t.Network, t.From, t.To, t.Outcome)
+		}
+		tw.Flush()
+
+	case "evict", "kick":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		network := fs.String("n", "", "network of the peer")
+		fs.Parse(args)
+		if fs.NArg() != 1 {
+			usage()
+			os.Exit(1)
+		}
+
+		if cmd == "evict" {
+			err = client.EvictPeer(ctx, *network, fs.Arg(0))
+		} else {
+			err = client.KickClient(ctx, *network, fs.Arg(0))
+		}
+		if err != nil {
+			fail(err)
+		}
+
+	default:
+		usage()
+		os.Exit(1)
+	}
+}
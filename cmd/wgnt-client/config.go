@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/wgquick"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"gopkg.in/yaml.v3"
+)
+
+// Config of the client, read from a YAML file.
+// Command line flags take precedence over the file.
+type Config struct {
+	Server        string   `yaml:"server"`
+	Interface     string   `yaml:"interface"`
+	UAPI          string   `yaml:"uapi"`
+	Daemon        bool     `yaml:"daemon"`
+	Hub           bool     `yaml:"hub"`
+	Network       string   `yaml:"network"`
+	Token         string   `yaml:"token"`
+	Tags          []string `yaml:"tags"`
+	CA            string   `yaml:"ca"`
+	Pins          []string `yaml:"pins"`
+	Control       string   `yaml:"control"`
+	MetricsListen string   `yaml:"metrics_listen"`
+	OTLP          string   `yaml:"otlp"`
+	MappingProbe  string   `yaml:"mapping_probe"`
+	Report        bool     `yaml:"report"`
+	Verbose       bool     `yaml:"verbose"`
+
+	Setup        Setup        `yaml:"setup"`
+	Userspace    Userspace    `yaml:"userspace"`
+	Subscription Subscription `yaml:"subscription"`
+	Roaming      Roaming      `yaml:"roaming"`
+	WgQuick      WgQuick      `yaml:"wg_quick"`
+
+	Tuning `yaml:",inline"`
+}
+
+// Setup configures the Wireguard interface, so that it doesn't have to be
+// prepared beforehand (e.g. by wg-quick). Everything is undone on exit,
+// except after a successful traversal outside of daemon mode.
+type Setup struct {
+	// create the interface, otherwise an existing one is configured
+	Create bool `yaml:"create"`
+	// private key file, generated if missing
+	PrivateKey string   `yaml:"private_key"`
+	MTU        int      `yaml:"mtu"`
+	Addresses  []string `yaml:"addresses"`
+	// route allowed IPs of the peers through the interface
+	Routes bool   `yaml:"routes"`
+	Peers  []Peer `yaml:"peers"`
+}
+
+// Peer is a Wireguard peer set up by the client.
+// Its endpoint is found by traversals unless it is given.
+type Peer struct {
+	PublicKey    string        `yaml:"public_key"`
+	PresharedKey string        `yaml:"preshared_key"`
+	Endpoint     string        `yaml:"endpoint"`
+	AllowedIPs   []string      `yaml:"allowed_ips"`
+	Keepalive    time.Duration `yaml:"keepalive"`
+}
+
+func (s *Setup) active() bool {
+	return s.Create || s.PrivateKey != "" || len(s.Addresses) > 0 || len(s.Peers) > 0
+}
+
+func (s *Setup) validate() error {
+	if s.MTU != 0 && (s.MTU < 576 || s.MTU > 65535) {
+		return errors.New("mtu must be between 576 and 65535")
+	}
+	for _, a := range s.Addresses {
+		if _, _, err := net.ParseCIDR(a); err != nil {
+			return fmt.Errorf("addresses: %w", err)
+		}
+	}
+	for _, p := range s.Peers {
+		if _, err := wgtypes.ParseKey(p.PublicKey); err != nil {
+			return fmt.Errorf("peers: invalid public key %q", p.PublicKey)
+		}
+		if p.PresharedKey != "" {
+			if _, err := wgtypes.ParseKey(p.PresharedKey); err != nil {
+				return fmt.Errorf("peers: %s: invalid preshared key", p.PublicKey)
+			}
+		}
+		for _, a := range p.AllowedIPs {
+			if _, _, err := net.ParseCIDR(a); err != nil {
+				return fmt.Errorf("peers: %s: allowed_ips: %w", p.PublicKey, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Userspace runs Wireguard in-process instead of using a kernel interface,
+// so no privileges are needed. Tunnel addresses and peers come from the setup section.
+// The tunnel is not visible to the system, it is reachable only through
+// the proxies and port forwards.
+type Userspace struct {
+	Enabled bool `yaml:"enabled"`
+	// DNS servers used for names resolved through the proxies
+	DNS       []string `yaml:"dns"`
+	SOCKS5    string   `yaml:"socks5"`
+	HTTPProxy string   `yaml:"http_proxy"`
+	// from a local address to an address in the tunnel
+	Forwards []Forward `yaml:"forwards"`
+	// from an address (usually just :port) in the tunnel to a local address
+	ReverseForwards []Forward `yaml:"reverse_forwards"`
+	// lets the proxies and forwards listen on non-loopback addresses,
+	// which opens the tunnel to anyone who can reach them
+	AllowRemote bool `yaml:"allow_remote"`
+}
+
+type Forward struct {
+	Listen string `yaml:"listen"`
+	Target string `yaml:"target"`
+}
+
+// DefaultUserspaceInterface names the device when no interface is given.
+const DefaultUserspaceInterface = "wgnt0"
+
+// checkListen rejects local listen addresses reachable from other hosts
+// unless allowed.
+func checkListen(addr string, allowRemote bool) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || allowRemote || host == "localhost" {
+		return err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%q is not a loopback address, set allow_remote to listen on it", addr)
+}
+
+func (u *Userspace) validate(s *Setup) error {
+	if !u.Enabled {
+		return nil
+	}
+	switch {
+	case s.Create:
+		return errors.New("setup.create cannot be combined with userspace")
+	case len(s.Addresses) == 0:
+		return errors.New("setup.addresses are required")
+	}
+	for _, d := range u.DNS {
+		if _, err := netip.ParseAddr(d); err != nil {
+			return fmt.Errorf("dns: %w", err)
+		}
+	}
+	if u.SOCKS5 != "" {
+		if err := checkListen(u.SOCKS5, u.AllowRemote); err != nil {
+			return fmt.Errorf("socks5: %w", err)
+		}
+	}
+	if u.HTTPProxy != "" {
+		if err := checkListen(u.HTTPProxy, u.AllowRemote); err != nil {
+			return fmt.Errorf("http_proxy: %w", err)
+		}
+	}
+	for _, f := range u.Forwards {
+		if err := checkListen(f.Listen, u.AllowRemote); err != nil {
+			return fmt.Errorf("forwards: listen: %w", err)
+		}
+	}
+	// reverse forwards listen in the tunnel
+	for _, f := range append(slices.Clone(u.Forwards), u.ReverseForwards...) {
+		if _, _, err := net.SplitHostPort(f.Listen); err != nil {
+			return fmt.Errorf("forwards: listen: %w", err)
+		}
+		if _, _, err := net.SplitHostPort(f.Target); err != nil {
+			return fmt.Errorf("forwards: target: %w", err)
+		}
+	}
+	return nil
+}
+
+// Subscription configures the WebSocket connection of the daemon.
+type Subscription struct {
+	PingInterval time.Duration `yaml:"ping_interval"`
+	// the connection is considered dead after this long without any message
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+	// bounds of the exponential backoff between reconnection attempts
+	ReconnectInitial time.Duration `yaml:"reconnect_initial"`
+	ReconnectMax     time.Duration `yaml:"reconnect_max"`
+}
+
+func (s Subscription) validate() error {
+	switch {
+	case s.PingInterval <= 0:
+		return errors.New("ping_interval must be positive")
+	case s.ReadTimeout <= s.PingInterval:
+		return errors.New("read_timeout must be longer than ping_interval")
+	case s.ReconnectInitial <= 0 || s.ReconnectMax < s.ReconnectInitial:
+		return errors.New("reconnect_initial must be positive and not above reconnect_max")
+	}
+	return nil
+}
+
+func (s Subscription) Options() []rendezvous.SubscribeOption {
+	return []rendezvous.SubscribeOption{
+		rendezvous.WithKeepalive(s.PingInterval, s.ReadTimeout),
+		rendezvous.WithReconnectBackoff(rendezvous.Backoff{
+			Initial: s.ReconnectInitial,
+			Max:     s.ReconnectMax,
+		}),
+	}
+}
+
+// Roaming configures how the daemon reacts to changes of the local network.
+type Roaming struct {
+	Disabled bool `yaml:"disabled"`
+	// how long to wait for the network to settle after the first change
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+func (r Roaming) validate() error {
+	if !r.Disabled && r.Debounce <= 0 {
+		return errors.New("debounce must be positive")
+	}
+	return nil
+}
+
+// WgQuick ties the client to the wg-quick configuration file of the interface.
+type WgQuick struct {
+	// default /etc/wireguard/<interface>.conf
+	Path string `yaml:"path"`
+	// write endpoints found by traversals to the file,
+	// so that they survive restarts of the interface
+	Persist bool `yaml:"persist"`
+	// also write the listen port used for the traversal
+	ListenPort bool `yaml:"listen_port"`
+	// set up the peers of the file instead of setup.peers
+	Peers bool `yaml:"peers"`
+}
+
+func (w *WgQuick) validate(s *Setup) error {
+	switch {
+	case w.ListenPort && !w.Persist:
+		return errors.New("listen_port requires persist")
+	case w.Peers && len(s.Peers) > 0:
+		return errors.New("peers cannot be combined with setup.peers")
+	}
+	return nil
+}
+
+// path of the file of the interface
+func (w *WgQuick) path(iface string) string {
+	if w.Path != "" {
+		return w.Path
+	}
+	return wgquick.DefaultPath(iface)
+}
+
+// Tuning holds the settings which are reloaded on SIGHUP.
+type Tuning struct {
+	STUNServers []string                `yaml:"stun_servers"`
+	Traversal   Traversal               `yaml:"traversal"`
+	Peers       map[string]PeerOverride `yaml:"peers"`
+}
+
+// Traversal tunes traversals to a peer.
+type Traversal struct {
+	// persistent keepalive set on the Wireguard peer
+	Keepalive time.Duration `yaml:"keepalive"`
+	// set the keepalive just under the measured NAT mapping timeout (daemon mode only),
+	// Keepalive is used until it is measured
+	AutoKeepalive bool `yaml:"auto_keepalive"`
+	// how often to ask the server whether the peer has registered
+	PollInterval time.Duration `yaml:"poll_interval"`
+	// limit of hole punching, 0 means unlimited
+	Timeout time.Duration `yaml:"timeout"`
+	// how long to wait for a handshake before the previous endpoint is restored,
+	// 0 disables the check
+	Verify time.Duration `yaml:"verify"`
+	// probes per port and round, 0 means the default of the strategy
+	Probes int `yaml:"probes"`
+	// sockets opened behind hard NAT, 0 means the default
+	Sockets int `yaml:"sockets"`
+}
+
+// PeerOverride replaces the traversal settings of a single peer.
+// Unset fields keep the values from the traversal section.
+type PeerOverride struct {
+	Keepalive     *time.Duration `yaml:"keepalive"`
+	AutoKeepalive *bool          `yaml:"auto_keepalive"`
+	PollInterval  *time.Duration `yaml:"poll_interval"`
+	Timeout       *time.Duration `yaml:"timeout"`
+	Verify        *time.Duration `yaml:"verify"`
+	Probes        *int           `yaml:"probes"`
+	Sockets       *int           `yaml:"sockets"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Setup: Setup{
+			Routes: true,
+		},
+		Subscription: Subscription{
+			PingInterval:     15 * time.Second,
+			ReadTimeout:      45 * time.Second,
+			ReconnectInitial: rendezvous.DefaultReconnectBackoff.Initial,
+			ReconnectMax:     rendezvous.DefaultReconnectBackoff.Max,
+		},
+		Roaming: Roaming{
+			Debounce: 2 * time.Second,
+		},
+		Tuning: Tuning{
+			Traversal: Traversal{
+				Keepalive:    wireguard.DefaultKeepalive,
+				PollInterval: 300 * time.Millisecond,
+				Verify:       15 * time.Second,
+			},
+		},
+	}
+}
+
+// LoadConfig reads the YAML file at path into cfg.
+// Settings missing from the file keep their values.
+func LoadConfig(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Server == "" {
+		return errors.New("missing server IP/hostname")
+	}
+	if c.Interface == "" && !c.Userspace.Enabled {
+		return errors.New("missing Wireguard interface")
+	}
+	if c.UAPI != "" && (c.Userspace.Enabled || c.Setup.Create) {
+		return errors.New("uapi cannot be combined with userspace or setup.create")
+	}
+	if err := c.Setup.validate(); err != nil {
+		return fmt.Errorf("setup: %w", err)
+	}
+	if err := c.Userspace.validate(&c.Setup); err != nil {
+		return fmt.Errorf("userspace: %w", err)
+	}
+	if err := c.WgQuick.validate(&c.Setup); err != nil {
+		return fmt.Errorf("wg_quick: %w", err)
+	}
+	if err := c.Subscription.validate(); err != nil {
+		return fmt.Errorf("subscription: %w", err)
+	}
+	if err := c.Roaming.validate(); err != nil {
+		return fmt.Errorf("roaming: %w", err)
+	}
+	return c.Tuning.Validate()
+}
+
+func (t *Tuning) Validate() error {
+	if len(t.STUNServers) == 1 {
+		return errors.New("stun_servers: at least two servers are needed to detect NAT kind")
+	}
+	if _, err := t.STUN(); err != nil {
+		return fmt.Errorf("stun_servers: %w", err)
+	}
+	if err := t.Traversal.validate(); err != nil {
+		return fmt.Errorf("traversal: %w", err)
+	}
+	for key, o := range t.Peers {
+		if _, err := wgtypes.ParseKey(key); err != nil {
+			return fmt.Errorf("peers: invalid public key %q", key)
+		}
+		if err := t.Traversal.With(o).validate(); err != nil {
+			return fmt.Errorf("peers: %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// maximum persistent keepalive interval supported by Wireguard
+const maxKeepalive = 65535 * time.Second
+
+func (tr Traversal) validate() error {
+	switch {
+	case tr.Keepalive < 0 || tr.Keepalive > maxKeepalive:
+		return fmt.Errorf("keepalive must be between 0 and %v", maxKeepalive)
+	case tr.PollInterval <= 0:
+		return errors.New("poll_interval must be positive")
+	case tr.Timeout < 0:
+		return errors.New("timeout must not be negative")
+	case tr.Verify < 0:
+		return errors.New("verify must not be negative")
+	case tr.Probes < 0:
+		return errors.New("probes must not be negative")
+	case tr.Sockets < 0 || tr.Sockets > 4096:
+		return errors.New("sockets must be between 0 and 4096")
+	}
+	return nil
+}
+
+// STUN returns the configured STUN servers, nil means the defaults.
+func (t *Tuning) STUN() ([]nat.STUNSrv, error) {
+	var servers []nat.STUNSrv
+	for _, s := range t.STUNServers {
+		srv, err := nat.ParseSTUNServer(s)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, srv)
+	}
+	return servers, nil
+}
+
+// With applies the override of a peer.
+func (tr Traversal) With(o PeerOverride) Traversal {
+	if o.Keepalive != nil {
+		tr.Keepalive = *o.Keepalive
+	}
+	if o.AutoKeepalive != nil {
+		tr.AutoKeepalive = *o.AutoKeepalive
+	}
+	if o.PollInterval != nil {
+		tr.PollInterval = *o.PollInterval
+	}
+	if o.Timeout != nil {
+		tr.Timeout = *o.Timeout
+	}
+	if o.Verify != nil {
+		tr.Verify = *o.Verify
+	}
+	if o.Probes != nil {
+		tr.Probes = *o.Probes
+	}
+	if o.Sockets != nil {
+		tr.Sockets = *o.Sockets
+	}
+	return tr
+}
+
+// ForPeer returns the traversal settings of the peer.
+func (t *Tuning) ForPeer(pubKey string) Traversal {
+	return t.Traversal.With(t.Peers[pubKey])
+}
+
+// autoKeepalive reports whether any peer needs the NAT mapping timeout measured.
+func (t *Tuning) autoKeepalive() bool {
+	if t.Traversal.AutoKeepalive {
+		return true
+	}
+	for _, o := range t.Peers {
+		if o.AutoKeepalive != nil && *o.AutoKeepalive {
+			return true
+		}
+	}
+	return false
+}
+
+// NATOptions turns the settings into hole punching options.
+func (tr Traversal) NATOptions() []nat.Option {
+	var opts []nat.Option
+	if tr.Timeout > 0 {
+		opts = append(opts, nat.WithTimeout(tr.Timeout))
+	}
+	if tr.Probes > 0 {
+		opts = append(opts, nat.WithProbes(tr.Probes))
+	}
+	if tr.Sockets > 0 {
+		opts = append(opts, nat.WithSockets(tr.Sockets))
+	}
+	return opts
+}
+
+var tuning atomic.Pointer[Tuning]
+
+// reloadTuning reads the file again and applies the settings which can change at runtime.
+// Settings which cannot (server, interface, ...) are ignored.
+func reloadTuning(path string) error {
+	cfg := DefaultConfig()
+	if err := LoadConfig(path, cfg); err != nil {
+		return err
+	}
+	if err := cfg.Tuning.Validate(); err != nil {
+		return err
+	}
+	tuning.Store(&cfg.Tuning)
+	return nil
+}
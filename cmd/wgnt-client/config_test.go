@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestUserspaceValidate(t *testing.T) {
+	setup := &Setup{Addresses: []string{"10.0.0.1/24"}}
+	tests := []struct {
+		name  string
+		u     Userspace
+		valid bool
+	}{
+		{"proxies", Userspace{SOCKS5: "127.0.0.1:1080", HTTPProxy: "localhost:8080"}, true},
+		{"IPv6 proxy", Userspace{SOCKS5: "[::1]:1080"}, true},
+		{"any address", Userspace{HTTPProxy: ":8080"}, false},
+		{"any address allowed", Userspace{HTTPProxy: ":8080", AllowRemote: true}, true},
+		{"public address", Userspace{SOCKS5: "192.0.2.1:1080"}, false},
+		{"host name", Userspace{SOCKS5: "proxy.example.com:1080"}, false},
+		{"socks5 without port", Userspace{SOCKS5: "127.0.0.1"}, false},
+		{"http_proxy without port", Userspace{HTTPProxy: "localhost"}, false},
+		{"unbracketed IPv6", Userspace{HTTPProxy: "::1:8080"}, false},
+		{"forward", Userspace{Forwards: []Forward{{Listen: "127.0.0.1:2222", Target: "10.0.0.2:22"}}}, true},
+		{"forward on any address", Userspace{Forwards: []Forward{{Listen: ":2222", Target: "10.0.0.2:22"}}}, false},
+		{"reverse forward on any address", Userspace{ReverseForwards: []Forward{{Listen: ":8080", Target: "127.0.0.1:80"}}}, true},
+		{"forward without target port", Userspace{Forwards: []Forward{{Listen: "127.0.0.1:2222", Target: "10.0.0.2"}}}, false},
+		{"dns", Userspace{DNS: []string{"10.0.0.53"}}, true},
+		{"invalid dns", Userspace{DNS: []string{"dns.example.com"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.u.Enabled = true
+			err := tt.u.validate(setup)
+			if (err == nil) != tt.valid {
+				t.Errorf("got %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
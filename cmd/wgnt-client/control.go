@@ -0,0 +1,183 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/control"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+)
+
+// Daemon tracks the state of traversals to each peer for the control socket.
+type Daemon struct {
+	wgClient   *wireguard.WgClient
+	sub        *rendezvous.Subscription
+	iface      string
+	pubKey     string
+	server     string
+	paused     bool
+	peers      map[string]*control.PeerStatus
+	mu         sync.Mutex
+	reconnects chan string
+}
+
+func NewDaemon(wgClient *wireguard.WgClient, sub *rendezvous.Subscription, iface, pubKey, server string, peerKeys []string) *Daemon {
+	d := &Daemon{
+		wgClient:   wgClient,
+		sub:        sub,
+		iface:      iface,
+		pubKey:     pubKey,
+		server:     server,
+		peers:      map[string]*control.PeerStatus{},
+		reconnects: make(chan string, 16),
+	}
+	for _, key := range peerKeys {
+		d.peers[key] = &control.PeerStatus{PubKey: key, State: control.StateIdle}
+	}
+	return d
+}
+
+func (d *Daemon) Status() control.Status {
+	d.mu.Lock()
+	status := control.Status{
+		Interface: d.iface,
+		PubKey:    d.pubKey,
+		Server:    d.server,
+		Connected: d.sub.Connected(),
+		Paused:    d.paused,
+		Peers:     make([]control.PeerStatus, 0, len(d.peers)),
+	}
+	for _, p := range d.peers {
+		status.Peers = append(status.Peers, *p)
+	}
+	d.mu.Unlock()
+
+	sort.Slice(status.Peers, func(i, j int) bool {
+		return status.Peers[i].PubKey < status.Peers[j].PubKey
+	})
+
+	// endpoints and handshakes come from the device itself
+	peers, err := d.wgClient.GetPeers()
+	if err != nil {
+		log.Printf("error getting wg peers: %v", err)
+		return status
+	}
+	for i := range status.Peers {
+		for _, p := range peers {
+			if p.PublicKey.String() != status.Peers[i].PubKey {
+				continue
+			}
+			if p.Endpoint != nil {
+				status.Peers[i].Endpoint = p.Endpoint.String()
+			}
+			status.Peers[i].LastHandshake = p.LastHandshakeTime
+		}
+	}
+	return status
+}
+
+func (d *Daemon) Reconnect(pubKey string) error {
+	d.mu.Lock()
+	_, known := d.peers[pubKey]
+	paused := d.paused
+	d.mu.Unlock()
+
+	switch {
+	case !known:
+		return control.ErrUnknownPeer
+	case paused:
+		return control.ErrPaused
+	}
+
+	select {
+	case d.reconnects <- pubKey:
+		return nil
+	default:
+		return control.ErrBusy
+	}
+}
+
+func (d *Daemon) Pause() {
+	d.mu.Lock()
+	d.paused = true
+	d.mu.Unlock()
+	log.Println("paused")
+}
+
+func (d *Daemon) Resume() {
+	d.mu.Lock()
+	d.paused = false
+	d.mu.Unlock()
+	log.Println("resumed")
+}
+
+func (d *Daemon) Paused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// Reconnects delivers peers requested through the control socket.
+func (d *Daemon) Reconnects() <-chan string {
+	return d.reconnects
+}
+
+// Attempted returns peers to which a traversal has been attempted.
+func (d *Daemon) Attempted() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var keys []string
+	for key, p := range d.peers {
+		if !p.LastAttempt.IsZero() {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Started marks the beginning of a traversal to the peer.
+func (d *Daemon) Started(pubKey string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if p, ok := d.peers[pubKey]; ok {
+		p.State = control.StateConnecting
+		p.LastAttempt = time.Now()
+	}
+}
+
+// Finished records the outcome of the traversal (completed by Telemetry.Record).
+func (d *Daemon) Finished(r *rendezvous.TraversalReport) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	p, ok := d.peers[r.Peer]
+	if !ok {
+		return
+	}
+	p.State = control.StateConnected
+	if !r.Success {
+		p.State = control.StateFailed
+	}
+	report := *r
+	p.Last = &report
+}
+
+// ServeControl serves the control API on a Unix socket at path.
+func (d *Daemon) ServeControl(path string) error {
+	l, err := control.Listen(path)
+	if err != nil {
+		return err
+	}
+	log.Printf("control socket: %s", path)
+	go func() {
+		log.Fatal(http.Serve(l, control.Handler(d)))
+	}()
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/tracing"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// resolveHub finds the endpoint of the peer through a hub server.
+// Nothing is punched: the Wireguard port keeps its NAT mapping open
+// by talking to the hub and the peer is given the endpoint the hub sees.
+func resolveHub(ctx context.Context, wgClient *wireguard.WgClient, peerPubKey string, client *rendezvous.Client, report *rendezvous.TraversalReport) (*STUNParams, error) {
+	report.Strategy = rendezvous.StrategyHub
+
+	snapshot, err := wgClient.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+
+	waitCtx, span := tracer.Start(ctx, "rendezvous.wait_peer")
+	peerInfo, err := client.WaitForHubPeer(waitCtx, peerPubKey, tuning.Load().ForPeer(peerPubKey).PollInterval)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("server error: %w", err)
+	}
+	fmt.Printf("peer %s:%d - seen by the hub\n", peerInfo.PublicIP, peerInfo.PublicPort)
+
+	return &STUNParams{
+		localPrivPort: snapshot.ListenPort,
+		remote:        *peerInfo,
+	}, nil
+}
+
+// withoutHub removes the hub from the peers, it is the one routing
+// the server address (or having it as its endpoint).
+func withoutHub(wgClient *wireguard.WgClient, peers []wgtypes.Peer, server string) ([]wgtypes.Peer, error) {
+	u, err := url.Parse(rendezvous.ServerURL(server))
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := net.LookupHost(u.Hostname())
+	if err != nil {
+		return nil, err
+	}
+
+	var hub wgtypes.Peer
+	found := false
+	for _, addr := range addrs {
+		if hub, err = wgClient.FindPeerByAllowedIP(addr); err == nil {
+			found = true
+			break
+		}
+		if key, err := wgClient.FindPeerByRemoteIP(addr); err == nil {
+			if hub, err = wgClient.FindPeerByPublicKey(key); err == nil {
+				found = true
+				break
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("the hub %s is not a peer of the interface", u.Hostname())
+	}
+	if hub.PersistentKeepaliveInterval == 0 {
+		log.Printf("hub %s has no persistent keepalive, the endpoints it sees may go stale", hub.PublicKey)
+	}
+
+	return slices.DeleteFunc(slices.Clone(peers), func(p wgtypes.Peer) bool {
+		return p.PublicKey == hub.PublicKey
+	}), nil
+}
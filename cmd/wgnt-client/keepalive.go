@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+)
+
+// measured NAT mapping timeout, zero until measured
+// or mappingTooShort if the mapping expired before the shortest probed delay
+var mappingTimeout atomic.Int64
+
+const mappingTooShort = -1
+
+// peerKeepalive returns the persistent keepalive interval of the peer.
+func peerKeepalive(pubKey string) time.Duration {
+	settings := tuning.Load().ForPeer(pubKey)
+	if settings.AutoKeepalive {
+		switch timeout := time.Duration(mappingTimeout.Load()); {
+		case timeout == mappingTooShort:
+			// only known to be shorter than any keepalive we would compute
+			return wireguard.MinKeepalive
+		case timeout > 0:
+			return wireguard.KeepaliveForMappingTimeout(timeout)
+		}
+	}
+	return settings.Keepalive
+}
+
+// mappingProbeAddr returns the address of the mapping timeout probe service.
+// It runs on the rendezvous server by default.
+func mappingProbeAddr(probe, serverURL string) (string, error) {
+	if probe == "" {
+		u, err := url.Parse(serverURL)
+		if err != nil {
+			return "", err
+		}
+		probe = u.Hostname()
+	}
+	if _, _, err := net.SplitHostPort(probe); err != nil {
+		probe = net.JoinHostPort(probe, strconv.Itoa(nat.DefaultMappingProbePort))
+	}
+	return probe, nil
+}
+
+// KeepaliveTuner measures the NAT mapping timeout in the background
+// and updates keepalives of peers with auto_keepalive.
+type KeepaliveTuner struct {
+	probe    string
+	wgClient *wireguard.WgClient
+	daemon   *Daemon
+	running  atomic.Bool
+}
+
+func NewKeepaliveTuner(probe string, wgClient *wireguard.WgClient, daemon *Daemon) *KeepaliveTuner {
+	return &KeepaliveTuner{
+		probe:    probe,
+		wgClient: wgClient,
+		daemon:   daemon,
+	}
+}
+
+// Measure starts a measurement unless one is running or no peer needs it.
+func (k *KeepaliveTuner) Measure() {
+	if !tuning.Load().autoKeepalive() || !k.running.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer k.running.Store(false)
+
+		log.Printf("measuring NAT mapping timeout using %s", k.probe)
+		timeout, err := nat.MeasureMappingTimeout(k.probe)
+		switch {
+		case errors.Is(err, nat.ErrMappingTooShort):
+			mappingTimeout.Store(mappingTooShort)
+			mappingTimeoutSeconds.Set(0)
+			log.Printf("NAT mapping expires within %v, keepalive: %v", nat.DefaultMappingDelays[0], wireguard.MinKeepalive)
+		case err != nil:
+			log.Printf("error measuring NAT mapping timeout: %v", err)
+			return
+		default:
+			mappingTimeout.Store(int64(timeout))
+			mappingTimeoutSeconds.Set(timeout.Seconds())
+			log.Printf("NAT mapping timeout: at least %v, keepalive: %v",
+				timeout, wireguard.KeepaliveForMappingTimeout(timeout))
+		}
+
+		for _, key := range k.daemon.Attempted() {
+			if !tuning.Load().ForPeer(key).AutoKeepalive {
+				continue
+			}
+			if err := k.wgClient.SetPeerKeepalive(key, peerKeepalive(key)); err != nil {
+				log.Printf("error setting keepalive of %s: %v", key, err)
+			}
+		}
+	}()
+}
+
+// NetworkChanged forgets the timeout measured behind the previous NAT
+// and measures it again.
+func (k *KeepaliveTuner) NetworkChanged() {
+	mappingTimeout.Store(0)
+	mappingTimeoutSeconds.Set(0)
+	k.Measure()
+}
@@ -1,20 +1,29 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
-	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/gorilla/websocket"
+	"github.com/nohajc/wg-nat-traversal/common/certs"
+	"github.com/nohajc/wg-nat-traversal/common/control"
+	"github.com/nohajc/wg-nat-traversal/common/flags"
 	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/tracing"
 	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 func newConn() (*net.UDPConn, error) {
@@ -31,103 +40,55 @@ func newConn() (*net.UDPConn, error) {
 	return conn, nil
 }
 
-type Client struct {
-	ServerURL string
-}
-
-type Message = nat.Message
-
-func NewClient(serverHost string) *Client {
-	return &Client{
-		ServerURL: fmt.Sprintf("http://%s:8080/", serverHost),
-	}
-}
-
-func (c *Client) PublishPeerInfo(pubKey string, info *nat.STUNInfo) error {
-	reqPayload, err := json.Marshal(info)
-	if err != nil {
-		return err
-	}
-	resp, err := http.Post(
-		fmt.Sprintf("%s?pubkey=%s", c.ServerURL, pubKey),
-		"application/json", bytes.NewReader(reqPayload),
-	)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected http status: %s", resp.Status)
-	}
-	return nil
-}
-
-func (c *Client) GetPeerInfo(peerPubKey string) (*nat.STUNInfo, error) {
-	resp, err := http.Get(fmt.Sprintf("%s?pubkey=%s", c.ServerURL, peerPubKey))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode == http.StatusNoContent {
-		return nil, nil
-	}
-	result := &nat.STUNInfo{}
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(result)
-	return result, err
-}
-
-func (c *Client) WaitForPeerInfo(peerPubKey string) (*nat.STUNInfo, error) {
-	for {
-		result, err := c.GetPeerInfo(peerPubKey)
-		if err != nil {
-			return nil, err
-		}
-		if result != nil {
-			return result, nil
-		}
-		time.Sleep(300 * time.Millisecond)
-	}
-}
-
-func setWireguardPorts(wgClient *wireguard.WgClient, peerPubKey string, params *STUNParams) error {
+func setWireguardPorts(ctx context.Context, wgClient *wireguard.WgClient, peerPubKey string, params *STUNParams) error {
 	fmt.Println("setWireguardPorts:")
 	fmt.Printf("- peer: %s:%d\n", params.remote.PublicIP, params.remote.PublicPort)
 	fmt.Printf("- local listen port: %d\n", params.localPrivPort)
 
-	err := wgClient.SetPeerRemotePort(peerPubKey, params.remote.PublicIP, params.remote.PublicPort)
-	if err != nil {
-		return err
-	}
+	return wgClient.ApplyEndpoint(ctx, wireguard.EndpointConfig{
+		Peer:       peerPubKey,
+		IP:         params.remote.PublicIP,
+		Port:       params.remote.PublicPort,
+		ListenPort: params.localPrivPort,
+		Keepalive:  peerKeepalive(peerPubKey),
+	}, tuning.Load().ForPeer(peerPubKey).Verify)
+}
 
-	err = wgClient.SetListenPort(params.localPrivPort)
-	if err != nil {
-		return err
-	}
+var natObserver nat.Observer
 
-	return nil
-}
+var tracer = otel.Tracer("github.com/nohajc/wg-nat-traversal/cmd/wgnt-client")
 
 type STUNParams struct {
 	localPrivPort int
 	remote        nat.STUNInfo
 }
 
-func resolvePorts(wgClient *wireguard.WgClient, peerPubKey string, serverHost string) (*STUNParams, error) {
+func resolvePorts(ctx context.Context, wgClient *wireguard.WgClient, peerPubKey string, client *rendezvous.Client, report *rendezvous.TraversalReport) (_ *STUNParams, err error) {
 	conn, err := newConn()
 	if err != nil {
 		return nil, fmt.Errorf("connection error: %w", err)
 	}
 	defer conn.Close()
 
-	stunInfo, err := nat.GetPublicAddrWithNATKind(conn)
+	tun := tuning.Load()
+	settings := tun.ForPeer(peerPubKey)
+	stunServers, err := tun.STUN()
+	if err != nil {
+		return nil, err
+	}
+
+	_, span := tracer.Start(ctx, "stun")
+	stunInfo, err := nat.GetPublicAddrWithNATKind(conn, stunServers...)
+	if err == nil {
+		span.SetAttributes(attribute.String("wgnt.nat", stunInfo.NATKind.String()))
+	}
+	tracing.End(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("STUN error: %w", err)
 	}
 
 	fmt.Printf("NAT type: %s\n", stunInfo.NATKind)
+	report.LocalNAT = stunInfo.NATKind
 	if stunInfo.NATKind == nat.NAT_EASY {
 		fmt.Printf("%s -> %s:%d\n", conn.LocalAddr().String(), stunInfo.PublicIP, stunInfo.PublicPort)
 	} else {
@@ -139,37 +100,62 @@ func resolvePorts(wgClient *wireguard.WgClient, peerPubKey string, serverHost st
 		return nil, fmt.Errorf("error getting wg interface public key: %w", err)
 	}
 
-	client := NewClient(serverHost)
-	err = client.PublishPeerInfo(pubKey, stunInfo)
+	publishCtx, span := tracer.Start(ctx, "rendezvous.publish")
+	err = client.Publish(publishCtx, pubKey, stunInfo)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("server error: %w", err)
 	}
 
-	peerInfo, err := client.WaitForPeerInfo(peerPubKey)
+	waitCtx, span := tracer.Start(ctx, "rendezvous.wait_peer")
+	peerInfo, err := client.WaitForPeer(waitCtx, peerPubKey, settings.PollInterval)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, fmt.Errorf("server error: %w", err)
 	}
 	fmt.Printf("peer %s:%d - NAT type: %s\n", peerInfo.PublicIP, peerInfo.PublicPort, peerInfo.NATKind)
+	report.RemoteNAT = peerInfo.NATKind
 
 	if stunInfo.NATKind == nat.NAT_HARD && peerInfo.NATKind == nat.NAT_HARD {
+		report.Strategy = rendezvous.StrategyInfeasible
 		return nil, errors.New("both peers are behind symmetric NAT, hole punching not feasible; exiting")
 	}
 
 	localPrivPort := conn.LocalAddr().(*net.UDPAddr).Port
+	report.Strategy = rendezvous.StrategyDirect
 
 	if stunInfo.NATKind == nat.NAT_HARD || peerInfo.NATKind == nat.NAT_HARD {
+		var stats nat.Stats
+		_, span := tracer.Start(ctx, "nat.punch")
+		observer := nat.Observers(natObserver, tracing.Observer(span))
+		natOpts := append(settings.NATOptions(), nat.WithStats(&stats), nat.WithObserver(observer))
+		defer func() {
+			report.ProbesSent = stats.ProbesSent
+			report.FirstResponse = stats.FirstResponse
+			span.SetAttributes(
+				attribute.String("wgnt.strategy", report.Strategy),
+				attribute.Int("wgnt.probes_sent", stats.ProbesSent),
+			)
+			tracing.End(span, err)
+		}()
+
 		if stunInfo.NATKind == nat.NAT_EASY {
-			remotePort, err := nat.GuessRemotePort(
-				peerInfo.PublicIP, nat.WithConn(conn),
+			report.Strategy = rendezvous.StrategyGuessRemote
+			var remotePort int
+			remotePort, err = nat.GuessRemotePort(peerInfo.PublicIP, append(natOpts,
+				nat.WithConn(conn),
 				nat.WithPubAddr(stunInfo.PublicIP, stunInfo.PublicPort),
-			)
+			)...)
 			if err != nil {
 				return nil, fmt.Errorf("guess remote port error: %w", err)
 			}
 			peerInfo.PublicPort = remotePort
 		} else {
-			localPort, err := nat.GuessLocalPort(
+			report.Strategy = rendezvous.StrategyGuessLocal
+			var localPort int
+			localPort, err = nat.GuessLocalPort(
 				fmt.Sprintf("%s:%d", peerInfo.PublicIP, peerInfo.PublicPort),
+				natOpts...,
 			)
 			if err != nil {
 				return nil, fmt.Errorf("guess local port error: %w", err)
@@ -185,80 +171,376 @@ func resolvePorts(wgClient *wireguard.WgClient, peerPubKey string, serverHost st
 	}, nil
 }
 
-func main() {
-	var serverHost, wgDevice string
-	var daemonMode bool // should be used by the peer with a wireguard server
+func hasPeer(peers []wgtypes.Peer, pubKey string) bool {
+	for _, p := range peers {
+		if p.PublicKey.String() == pubKey {
+			return true
+		}
+	}
+	return false
+}
 
-	flag.BoolVar(&daemonMode, "d", false, "daemon mode (listen for peers)")
-	flag.StringVar(&serverHost, "s", "", "server IP/hostname")
-	flag.StringVar(&wgDevice, "w", "", "Wireguard interface")
+func main() {
+	var configPath string
+	cfg := DefaultConfig()
+
+	flag.StringVar(&configPath, "c", "", "YAML config file (reloaded on SIGHUP), flags take precedence")
+	flag.BoolVar(&cfg.Daemon, "d", false, "daemon mode (listen for peers)") // daemon mode should be used by the peer with a wireguard server
+	flag.StringVar(&cfg.Server, "s", "", "server IP/hostname[:port] or URL (e.g. https://example.com/wgnt/)")
+	flag.BoolVar(&cfg.Hub, "hub", false, "the server is a hub (a Wireguard peer reporting the endpoints it sees), no hole punching")
+	flag.StringVar(&cfg.Interface, "w", "", "Wireguard interface")
+	flag.StringVar(&cfg.UAPI, "uapi", "", "control a userspace Wireguard implementation (e.g. wireguard-go) through its UAPI socket in this directory")
+	flag.BoolVar(&cfg.Userspace.Enabled, "userspace", false, "run Wireguard in-process without privileges (see the userspace section of the config)")
+	flag.BoolVar(&cfg.Setup.Create, "create", false, "create the Wireguard interface (deleted on exit, see the setup section of the config)")
+	flag.StringVar(&cfg.Control, "control", "", "control socket in daemon mode (default /run/wgnt/<interface>.sock, \"off\" to disable)")
+	flag.StringVar(&cfg.Network, "n", "", "network to join on the server")
+	flag.StringVar(&cfg.Token, "t", "", "network join token (default $WGNT_TOKEN)")
+	flag.Var(flags.List(&cfg.Tags), "tags", "comma-separated tags to advertise (subject to server policy)")
+	flag.BoolVar(&cfg.Verbose, "v", false, "verbose logging (every hole punching probe)")
+	flag.StringVar(&cfg.MetricsListen, "metrics-listen", "", "listen address for Prometheus /metrics (default disabled)")
+	flag.StringVar(&cfg.MappingProbe, "mapping-probe", "", "host[:port] of the NAT mapping timeout probe for auto_keepalive (default server host)")
+	flag.BoolVar(&cfg.WgQuick.Persist, "persist", false, "write discovered endpoints to the wg-quick config of the interface (see the wg_quick section of the config)")
+	flag.BoolVar(&cfg.Report, "report", false, "report traversal outcomes to the server")
+	flag.StringVar(&cfg.OTLP, "otlp", "", "OTLP/HTTP endpoint for exporting traces, e.g. localhost:4318 (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.StringVar(&cfg.CA, "ca", "", "CA bundle for verifying the server certificate")
+	flag.Var(flags.List(&cfg.Pins), "pin", "comma-separated sha256/<base64> pins of the server certificate or its CA")
 	flag.Parse()
 
-	if serverHost == "" {
-		fmt.Fprintln(os.Stderr, "missing server IP/hostname")
-		os.Exit(1)
+	if configPath != "" {
+		if err := LoadConfig(configPath, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		// flags override the file
+		flag.Parse()
 	}
-	if wgDevice == "" {
-		fmt.Fprintln(os.Stderr, "missing Wireguard interface")
-		os.Exit(1)
+	if cfg.Userspace.Enabled && cfg.Interface == "" {
+		cfg.Interface = DefaultUserspaceInterface
 	}
-
-	wgClient, err := wireguard.NewWgClient(wgDevice)
-	if err != nil {
+	if err := cfg.Validate(); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
+	if cfg.WgQuick.Peers {
+		if err := loadWgQuickPeers(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+	tuning.Store(&cfg.Tuning)
+
+	logLevel := slog.LevelInfo
+	if cfg.Verbose {
+		logLevel = slog.LevelDebug
+	}
+	natObserver = nat.SlogObserver(
+		slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})),
+	)
+
+	// handled once everything is set up
+	var hup chan os.Signal
+	if configPath != "" {
+		hup = make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+	}
+	onReload := func() {}
+
+	// undoes the setup of the interface, also on fatal errors
+	teardown := func() {}
+	exit := func(code int) {
+		teardown()
+		os.Exit(code)
+	}
+
+	var wgClient *wireguard.WgClient
+	if cfg.Userspace.Enabled {
+		dev, err := startUserspace(cfg.Interface, cfg.Setup, cfg.Userspace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		teardown = func() {
+			dev.Close()
+		}
+		wgClient = wireguard.NewUserspaceClient(dev)
+		if err := configureDevice(wgClient, cfg.Setup, true); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exit(1)
+		}
+		if err := serveTunnel(dev, cfg.Userspace); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exit(1)
+		}
+	} else if cfg.UAPI != "" {
+		wgClient = wireguard.NewWgClientWithBackend(wireguard.NewUAPIBackend(cfg.UAPI), cfg.Interface)
+	} else {
+		var err error
+		wgClient, err = wireguard.NewWgClient(cfg.Interface)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if cfg.Setup.active() && !cfg.Userspace.Enabled {
+		l, err := setupInterface(wgClient, cfg.Interface, cfg.Setup)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		teardown = func() {
+			if err := l.Close(); err != nil {
+				log.Printf("error tearing down %s: %v", cfg.Interface, err)
+			}
+		}
+	}
 
 	peers, err := wgClient.GetPeers()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
-		os.Exit(1)
+		exit(1)
+	}
+	if cfg.Hub {
+		peers, err = withoutHub(wgClient, peers, cfg.Server)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exit(1)
+		}
 	}
 	if len(peers) < 1 {
 		fmt.Fprintln(os.Stderr, "at least one Peer required in wg config")
-		os.Exit(1)
+		exit(1)
 	}
 	peerPubKey := peers[0].PublicKey.String()
 
-	var wsConn *websocket.Conn
-	if daemonMode {
-		pubKey, err := wgClient.GetInterfacePublicKey()
+	pubKey, err := wgClient.GetInterfacePublicKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting wg interface public key: %v\n", err)
+		exit(1)
+	}
+	privKey, err := wgClient.GetInterfacePrivateKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting wg interface private key: %v\n", err)
+		exit(1)
+	}
+
+	ctx := context.Background()
+	shutdownTracing, err := tracing.Setup(ctx, "wgnt-client", cfg.OTLP)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exit(1)
+	}
+	defer shutdownTracing(ctx)
+
+	// the interface is restored to its original state when the client is stopped
+	initial, err := wgClient.Snapshot()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exit(1)
+	}
+	shutdown := func(code int) {
+		if err := wgClient.Restore(initial); err != nil {
+			log.Printf("error restoring %s: %v", cfg.Interface, err)
+			code = 1
+		}
+		shutdownTracing(ctx)
+		exit(code)
+	}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-stop
+		log.Printf("%v received, restoring %s", sig, cfg.Interface)
+		shutdown(0)
+	}()
+
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("WGNT_TOKEN")
+	}
+	clientOpts := []rendezvous.Option{
+		rendezvous.WithNetwork(cfg.Network, cfg.Token),
+		rendezvous.WithIdentity(pubKey, cfg.Tags...),
+		rendezvous.WithPrivateKey(privKey),
+	}
+	if cfg.CA != "" || len(cfg.Pins) > 0 {
+		tlsConfig, err := certs.ClientConfig(cfg.CA, cfg.Pins)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exit(1)
+		}
+		clientOpts = append(clientOpts, rendezvous.WithTLSConfig(tlsConfig))
+	}
+
+	client, err := rendezvous.NewClient(rendezvous.ServerURL(cfg.Server), clientOpts...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		exit(1)
+	}
+
+	if cfg.MetricsListen != "" {
+		go serveMetrics(cfg.MetricsListen)
+	}
+	telemetry := NewTelemetry(client, cfg.Report)
+
+	var daemon *Daemon
+	var notifications chan rendezvous.Message
+	if cfg.Daemon {
+		subOpts := append(cfg.Subscription.Options(),
+			rendezvous.OnDisconnect(func(err error) {
+				log.Printf("connection to server lost: %v; reconnecting", err)
+			}),
+			rendezvous.OnReconnect(func(context.Context) {
+				log.Println("reconnected to server")
+			}),
+		)
+		sub, err := client.Subscribe(ctx, pubKey, subOpts...)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "error getting wg interface public key: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			exit(1)
+		}
+		defer sub.Close()
+
+		notifications = make(chan rendezvous.Message)
+		go func() {
+			defer close(notifications)
+			for {
+				msg, err := sub.Next()
+				if err != nil {
+					log.Printf("subscription failed: %v", err)
+					return
+				}
+				notifications <- msg
+			}
+		}()
+
+		peerKeys := make([]string, 0, len(peers))
+		for _, p := range peers {
+			peerKeys = append(peerKeys, p.PublicKey.String())
+		}
+		daemon = NewDaemon(wgClient, sub, cfg.Interface, pubKey, client.URL(), peerKeys)
+		if cfg.Control == "" {
+			cfg.Control = control.DefaultSocketPath(cfg.Interface)
+		}
+		if cfg.Control != "off" {
+			if err := daemon.ServeControl(cfg.Control); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				exit(1)
+			}
 		}
-		wsURL := fmt.Sprintf("ws://%s:8080/ws?pubkey=%s", serverHost, pubKey)
-		wsConn, _, err = websocket.DefaultDialer.Dial(wsURL, nil)
+
+		probe, err := mappingProbeAddr(cfg.MappingProbe, client.URL())
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			exit(1)
+		}
+		keepalives := NewKeepaliveTuner(probe, wgClient, daemon)
+		keepalives.Measure()
+		// auto_keepalive may have been enabled
+		onReload = keepalives.Measure
+
+		if !cfg.Roaming.Disabled {
+			if err := watchNetwork(ctx, daemon, cfg.Interface, cfg.Roaming, keepalives.NetworkChanged); err != nil {
+				log.Printf("roaming detection disabled: %v", err)
+			}
+		}
+	}
+
+	if hup != nil {
+		go func() {
+			for range hup {
+				if err := reloadTuning(configPath); err != nil {
+					log.Printf("config reload failed: %v", err)
+					continue
+				}
+				log.Printf("config reloaded from %s", configPath)
+				onReload()
+			}
+		}()
+	}
+
+	record := func(ctx context.Context, report *rendezvous.TraversalReport, start time.Time, err error) {
+		telemetry.Record(ctx, report, start, err)
+		if daemon != nil {
+			daemon.Finished(report)
 		}
 	}
 
 	for {
-		if wsConn != nil {
-			msg := Message{}
-			err = wsConn.ReadJSON(&msg)
-			if err != nil {
-				log.Println("read:", err)
-				return
+		parentCtx := ctx
+		if daemon != nil {
+			select {
+			case msg, ok := <-notifications:
+				if !ok {
+					log.Printf("subscription closed, restoring %s", cfg.Interface)
+					shutdown(1)
+				}
+				log.Println("received notification from peer")
+
+				if daemon.Paused() {
+					log.Println("paused, ignoring notification")
+					continue
+				}
+				if msg.From != "" {
+					if !hasPeer(peers, msg.From) {
+						log.Printf("ignoring unknown peer %s", msg.From)
+						continue
+					}
+					peerPubKey = msg.From
+				}
+				// continue the trace of the peer which asked for us
+				parentCtx = tracing.Extract(ctx, msg.Trace)
+
+			case peerPubKey = <-daemon.Reconnects():
+				log.Printf("reconnecting to %s", peerPubKey)
 			}
-			log.Println("received notification from peer")
+			daemon.Started(peerPubKey)
 		}
 
-		params, err := resolvePorts(wgClient, peerPubKey, serverHost)
+		report := &rendezvous.TraversalReport{Peer: peerPubKey}
+		start := time.Now()
+		traversalCtx, span := tracer.Start(parentCtx, "traversal", trace.WithAttributes(
+			attribute.String("wgnt.peer", peerPubKey),
+			attribute.Bool("wgnt.daemon", daemon != nil),
+		))
+
+		resolve := resolvePorts
+		if cfg.Hub {
+			resolve = resolveHub
+		}
+		params, err := resolve(traversalCtx, wgClient, peerPubKey, client, report)
 		if err != nil {
+			record(traversalCtx, report, start, err)
+			tracing.End(span, err)
 			fmt.Fprintf(os.Stderr, "%v\n", err)
 			continue
 		}
 
-		err = setWireguardPorts(wgClient, peerPubKey, params)
+		_, wgSpan := tracer.Start(traversalCtx, "wireguard.configure")
+		err = setWireguardPorts(traversalCtx, wgClient, peerPubKey, params)
+		tracing.End(wgSpan, err)
+		record(traversalCtx, report, start, err)
+		tracing.End(span, err)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "%v\n", err)
-			os.Exit(1)
+			if daemon != nil {
+				// the previous configuration has been restored
+				continue
+			}
+			shutdownTracing(ctx)
+			exit(1)
+		}
+		if cfg.WgQuick.Persist {
+			if err := persistEndpoint(cfg.WgQuick, cfg.Interface, peerPubKey, params); err != nil {
+				log.Printf("error persisting the endpoint of %s: %v", peerPubKey, err)
+			}
 		}
 
-		if wsConn == nil {
+		if daemon == nil {
+			if cfg.Userspace.Enabled {
+				// the tunnel lives in this process
+				log.Println("tunnel is up, serving until interrupted")
+				select {}
+			}
 			break
 		}
 	}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// newFakeDevice returns a fake device with a private key and a peer
+// at a previous endpoint, as found before a traversal.
+func newFakeDevice(t *testing.T) (*wireguard.Fake, *wireguard.WgClient, string) {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerKey, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := wireguard.NewFake("wg0")
+	listenPort, keepalive := 51820, wireguard.DefaultKeepalive
+	err = f.ConfigureDevice("wg0", wgtypes.Config{
+		PrivateKey: &key,
+		ListenPort: &listenPort,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:                   peerKey.PublicKey(),
+			Endpoint:                    &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+			PersistentKeepaliveInterval: &keepalive,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Reset()
+	return f, wireguard.NewWgClientWithBackend(f, "wg0"), peerKey.PublicKey().String()
+}
+
+func checkPeer(t *testing.T, wgClient *wireguard.WgClient, peer string, listenPort int, endpoint string, keepalive time.Duration) {
+	t.Helper()
+	s, err := wgClient.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ListenPort != listenPort {
+		t.Errorf("listen port %d, want %d", s.ListenPort, listenPort)
+	}
+	for _, p := range s.Peers {
+		if p.PublicKey.String() != peer {
+			continue
+		}
+		if p.Endpoint == nil || p.Endpoint.String() != endpoint {
+			t.Errorf("endpoint %v, want %s", p.Endpoint, endpoint)
+		}
+		if p.Keepalive != keepalive {
+			t.Errorf("keepalive %v, want %v", p.Keepalive, keepalive)
+		}
+		return
+	}
+	t.Fatal("peer not found")
+}
+
+func TestSetWireguardPorts(t *testing.T) {
+	errConfigure := errors.New("configure failed")
+	overridden := 10 * time.Second
+	auto := true
+	defer mappingTimeout.Store(0)
+
+	params := &STUNParams{
+		localPrivPort: 40001,
+		remote:        nat.STUNInfo{PublicIP: "198.51.100.7", PublicPort: 40000},
+	}
+	tests := []struct {
+		name      string
+		verify    time.Duration
+		override  *PeerOverride
+		mapping   time.Duration
+		reachable bool
+		failNext  error
+		err       error
+		keepalive time.Duration
+	}{
+		{name: "verified", verify: time.Second, reachable: true, keepalive: wireguard.DefaultKeepalive},
+		{name: "not verified", keepalive: wireguard.DefaultKeepalive},
+		{name: "peer keepalive", override: &PeerOverride{Keepalive: &overridden}, keepalive: overridden},
+		{name: "auto keepalive", override: &PeerOverride{AutoKeepalive: &auto}, mapping: time.Minute, keepalive: 48 * time.Second},
+		{name: "mapping too short", override: &PeerOverride{AutoKeepalive: &auto}, mapping: mappingTooShort, keepalive: wireguard.MinKeepalive},
+		{name: "no handshake", verify: 300 * time.Millisecond, err: wireguard.ErrNoHandshake},
+		{name: "configure failure", failNext: errConfigure, err: errConfigure},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, wgClient, peer := newFakeDevice(t)
+			tun := DefaultConfig().Tuning
+			tun.Traversal.Verify = tt.verify
+			if tt.override != nil {
+				tun.Peers = map[string]PeerOverride{peer: *tt.override}
+			}
+			tuning.Store(&tun)
+			mappingTimeout.Store(int64(tt.mapping))
+			if tt.reachable {
+				f.SetReachable("198.51.100.7:40000")
+			}
+			if tt.failNext != nil {
+				f.FailNext(tt.failNext)
+			}
+
+			err := setWireguardPorts(context.Background(), wgClient, peer, params)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				checkPeer(t, wgClient, peer, 51820, "192.0.2.1:51820", wireguard.DefaultKeepalive)
+				return
+			}
+			checkPeer(t, wgClient, peer, 40001, "198.51.100.7:40000", tt.keepalive)
+		})
+	}
+}
+
+// fakeRendezvous is a rendezvous server storing the published STUN info.
+type fakeRendezvous struct {
+	mu        sync.Mutex
+	published map[string]nat.STUNInfo
+	// of publish requests, 0 means OK
+	publishStatus int
+}
+
+func (s *fakeRendezvous) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := r.URL.Query().Get("pubkey")
+	switch r.Method {
+	case http.MethodPost:
+		if s.publishStatus != 0 {
+			w.WriteHeader(s.publishStatus)
+			return
+		}
+		var info nat.STUNInfo
+		if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.published[key] = info
+	case http.MethodGet:
+		info, ok := s.published[key]
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
+func (s *fakeRendezvous) get(key string) (nat.STUNInfo, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, ok := s.published[key]
+	return info, ok
+}
+
+// startSTUN runs two local STUN servers, enough to detect the NAT kind.
+func startSTUN(t *testing.T) []string {
+	t.Helper()
+	var servers []string
+	for i := 0; i < 2; i++ {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go nat.ServeSTUN(conn)
+		servers = append(servers, conn.LocalAddr().String())
+	}
+	return servers
+}
+
+func TestResolvePorts(t *testing.T) {
+	stunServers := startSTUN(t)
+	peerInfo := nat.STUNInfo{PublicIP: "198.51.100.7", PublicPort: 40000, NATKind: nat.NAT_EASY}
+
+	tests := []struct {
+		name          string
+		noDevice      bool
+		peerMissing   bool
+		publishStatus int
+		err           bool
+		code          int
+	}{
+		{name: "direct"},
+		{name: "no interface", noDevice: true, err: true},
+		{name: "publish rejected", publishStatus: http.StatusForbidden, err: true, code: http.StatusForbidden},
+		{name: "peer not registered", peerMissing: true, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, wgClient, peer := newFakeDevice(t)
+			if tt.noDevice {
+				wgClient = wireguard.NewWgClientWithBackend(wireguard.NewFake(), "wg0")
+			}
+			tun := DefaultConfig().Tuning
+			tun.STUNServers = stunServers
+			tun.Traversal.PollInterval = 50 * time.Millisecond
+			tuning.Store(&tun)
+
+			rv := &fakeRendezvous{published: map[string]nat.STUNInfo{}, publishStatus: tt.publishStatus}
+			if !tt.peerMissing {
+				rv.published[peer] = peerInfo
+			}
+			srv := httptest.NewServer(rv)
+			defer srv.Close()
+			client, err := rendezvous.NewClient(srv.URL, rendezvous.WithBackoff(rendezvous.Backoff{Attempts: 1}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			report := &rendezvous.TraversalReport{}
+			params, err := resolvePorts(ctx, wgClient, peer, client, report)
+
+			if tt.err {
+				if err == nil {
+					t.Fatalf("got %+v, want an error", params)
+				}
+				var se *rendezvous.StatusError
+				if tt.code != 0 && (!errors.As(err, &se) || se.Code != tt.code) {
+					t.Errorf("got %v, want status %d", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if params.remote != peerInfo {
+				t.Errorf("remote %+v, want %+v", params.remote, peerInfo)
+			}
+			if report.Strategy != rendezvous.StrategyDirect || report.LocalNAT != nat.NAT_EASY || report.RemoteNAT != nat.NAT_EASY {
+				t.Errorf("report %+v", report)
+			}
+
+			// published as seen by the STUN servers, from the port used as the listen port
+			pubKey, err := wgClient.GetInterfacePublicKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			own, ok := rv.get(pubKey)
+			if !ok {
+				t.Fatal("not published")
+			}
+			if own.PublicIP != "127.0.0.1" || own.PublicPort != params.localPrivPort || own.NATKind != nat.NAT_EASY {
+				t.Errorf("published %+v, listen port %d", own, params.localPrivPort)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"github.com/nohajc/wg-nat-traversal/common/control"
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/netmon"
+)
+
+// discoverPublicAddr runs STUN discovery on a fresh socket.
+func discoverPublicAddr(stunServers []nat.STUNSrv) (*nat.STUNInfo, error) {
+	conn, err := newConn()
+	if err != nil {
+		return nil, fmt.Errorf("connection error: %w", err)
+	}
+	defer conn.Close()
+
+	return nat.GetPublicAddrWithNATKind(conn, stunServers...)
+}
+
+// localRoute returns the local address and interface the traffic to the STUN server
+// leaves from. Connecting a UDP socket only looks up the route, nothing is sent.
+func localRoute(srv nat.STUNSrv) (string, error) {
+	conn, err := net.Dial("udp", strings.TrimPrefix(string(srv), "stun:"))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	ip := conn.LocalAddr().(*net.UDPAddr).IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return fmt.Sprintf("%s on %s", ip, iface.Name), nil
+			}
+		}
+	}
+	return ip.String(), nil
+}
+
+// network is what the NAT mappings of the Wireguard port depend on.
+type network struct {
+	local  string
+	public *nat.STUNInfo
+}
+
+func (n *network) String() string {
+	return fmt.Sprintf("%s, public address %s (NAT type: %s)", n.local, n.public.PublicIP, n.public.NATKind)
+}
+
+func (n *network) differs(o *network) bool {
+	return n.local != o.local || n.public.PublicIP != o.public.PublicIP || n.public.NATKind != o.public.NATKind
+}
+
+func currentNetwork() (*network, error) {
+	stunServers, err := tuning.Load().STUN()
+	if err != nil {
+		return nil, err
+	}
+	if len(stunServers) == 0 {
+		return nil, errors.New("no STUN servers")
+	}
+	local, err := localRoute(stunServers[0])
+	if err != nil {
+		return nil, err
+	}
+	public, err := discoverPublicAddr(stunServers)
+	if err != nil {
+		return nil, fmt.Errorf("STUN error: %w", err)
+	}
+	return &network{local: local, public: public}, nil
+}
+
+// watchNetwork re-punches peers when a change of the local network
+// (e.g. another Wi-Fi or a new DHCP lease) changes the local address
+// or the public address. A new local address loses the NAT mappings of
+// the Wireguard port even behind the same NAT with the same public address.
+// Traversals started by Reconnect publish the new address to the server.
+// onChange is called when the network changes.
+func watchNetwork(ctx context.Context, daemon *Daemon, iface string, cfg Roaming, onChange func()) error {
+	// changes of the Wireguard interface are caused by the traversals
+	mon, err := netmon.New(netmon.WithDebounce(cfg.Debounce), netmon.IgnoreInterfaces(iface))
+	if err != nil {
+		return err
+	}
+
+	last, err := currentNetwork()
+	if err != nil {
+		log.Printf("roaming: %v", err)
+	}
+
+	go func() {
+		defer mon.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case change, ok := <-mon.Changes():
+				if !ok {
+					return
+				}
+				log.Printf("roaming: network changed (links: %d, addresses: %d, routes: %d)",
+					change.Links, change.Addrs, change.Routes)
+
+				cur, err := currentNetwork()
+				if err != nil {
+					// probably offline, the next change tells
+					log.Printf("roaming: %v", err)
+					continue
+				}
+				if last != nil && !cur.differs(last) {
+					log.Printf("roaming: network unchanged (%s)", cur)
+					continue
+				}
+				if last != nil {
+					log.Printf("roaming: network changed from %s to %s", last, cur)
+				} else {
+					log.Printf("roaming: network %s", cur)
+				}
+				last = cur
+				onChange()
+
+				// the connection to the server went through the old network
+				daemon.sub.Reconnect()
+				for _, key := range daemon.Attempted() {
+					err := daemon.Reconnect(key)
+					switch {
+					case errors.Is(err, control.ErrPaused):
+						log.Printf("roaming: paused, not reconnecting to %s", key)
+					case err != nil:
+						log.Printf("roaming: cannot reconnect to %s: %v", key, err)
+					}
+				}
+			}
+		}
+	}()
+	return nil
+}
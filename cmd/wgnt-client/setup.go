@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nohajc/wg-nat-traversal/common/link"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// setupInterface creates or opens the interface and configures its key, peers,
+// addresses and routes. Closing the returned link undoes it.
+func setupInterface(wgClient *wireguard.WgClient, iface string, s Setup) (_ *link.Link, err error) {
+	var l *link.Link
+	if s.Create {
+		l, err = link.Create(iface)
+	} else {
+		l, err = link.Open(iface)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			l.Close()
+		}
+	}()
+	if l.Created() {
+		log.Printf("created interface %s", iface)
+	}
+
+	if err := configureDevice(wgClient, s, l.Created()); err != nil {
+		return nil, err
+	}
+
+	if s.MTU > 0 {
+		if err := l.SetMTU(s.MTU); err != nil {
+			return nil, fmt.Errorf("error setting MTU: %w", err)
+		}
+	}
+	for _, addr := range s.Addresses {
+		if err := l.AddAddress(addr); err != nil {
+			return nil, err
+		}
+	}
+	if err := l.Up(); err != nil {
+		return nil, fmt.Errorf("error bringing %s up: %w", iface, err)
+	}
+
+	if s.Routes {
+		for _, p := range s.Peers {
+			for _, cidr := range p.AllowedIPs {
+				if link.DefaultRoute(cidr) {
+					// it would also route the Wireguard and STUN packets
+					log.Printf("not routing %s through %s, configure policy routing instead", cidr, iface)
+					continue
+				}
+				if err := l.AddRoute(cidr); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return l, nil
+}
+
+// configureDevice sets the private key and the peers. On a device created
+// by the client, a temporary key is generated without a private_key file
+// and peers missing from the setup are removed. Other devices may have
+// peers managed by something else, they are only added or updated.
+func configureDevice(wgClient *wireguard.WgClient, s Setup, created bool) error {
+	var key wgtypes.Key
+	var err error
+	switch {
+	case s.PrivateKey != "":
+		key, err = wireguard.LoadOrGenerateKey(s.PrivateKey)
+	case created:
+		// the device would have no key at all
+		log.Printf("no private_key file, generating a temporary key")
+		key, err = wgtypes.GeneratePrivateKey()
+	}
+	if err != nil {
+		return err
+	}
+	if key != (wgtypes.Key{}) {
+		if err := wgClient.SetPrivateKey(key); err != nil {
+			return fmt.Errorf("error setting private key: %w", err)
+		}
+		log.Printf("public key: %s", key.PublicKey())
+	}
+
+	if len(s.Peers) > 0 {
+		specs := make([]wireguard.PeerSpec, 0, len(s.Peers))
+		for _, p := range s.Peers {
+			specs = append(specs, wireguard.PeerSpec{
+				PublicKey:    p.PublicKey,
+				PresharedKey: p.PresharedKey,
+				Endpoint:     p.Endpoint,
+				AllowedIPs:   p.AllowedIPs,
+				Keepalive:    p.Keepalive,
+			})
+		}
+		apply := wgClient.Merge
+		if created {
+			apply = wgClient.Reconcile
+		}
+		diff, err := apply(specs)
+		if err != nil {
+			return fmt.Errorf("error configuring peers: %w", err)
+		}
+		log.Printf("peers: %d added, %d updated, %d removed", len(diff.Add), len(diff.Update), len(diff.Remove))
+	}
+	return nil
+}
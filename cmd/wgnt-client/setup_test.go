@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func TestConfigureDevice(t *testing.T) {
+	tests := []struct {
+		name    string
+		created bool
+		// whether the peer found on the device is kept
+		kept bool
+	}{
+		{"created", true, false},
+		{"existing", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, wgClient, other := newFakeDevice(t)
+			key, err := wgtypes.GeneratePrivateKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			peer := key.PublicKey().String()
+
+			s := Setup{Peers: []Peer{{PublicKey: peer, AllowedIPs: []string{"10.0.0.2/32"}}}}
+			if err := configureDevice(wgClient, s, tt.created); err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := wgClient.FindPeerByPublicKey(peer); err != nil {
+				t.Errorf("configured peer: %v", err)
+			}
+			_, err = wgClient.FindPeerByPublicKey(other)
+			switch {
+			case tt.kept && err != nil:
+				t.Errorf("peer of the device: %v", err)
+			case !tt.kept && !errors.Is(err, wireguard.ErrPeerNotFound):
+				t.Errorf("peer of the device not removed: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const metricsNamespace = "wgnt_client"
+
+var (
+	traversalsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "traversals_total",
+		Help:      "Number of traversal attempts.",
+	}, []string{"strategy", "local_nat", "remote_nat", "result"})
+	traversalDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "traversal_duration_seconds",
+		Help:      "Duration of traversal attempts from STUN discovery to Wireguard reconfiguration.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"strategy"})
+	firstResponseTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "time_to_first_response_seconds",
+		Help:      "Time from the first hole punching probe to the first response from the peer.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	}, []string{"strategy"})
+	probesSent = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "probes_sent",
+		Help:      "Number of hole punching probes sent per traversal attempt.",
+		Buckets:   prometheus.ExponentialBuckets(10, 2, 12),
+	}, []string{"strategy"})
+	mappingTimeoutSeconds = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "nat_mapping_timeout_seconds",
+		Help:      "Measured lower bound of the NAT mapping timeout, 0 if not measured.",
+	})
+)
+
+// Telemetry records traversal attempts as metrics
+// and optionally reports them back to the server.
+type Telemetry struct {
+	client *rendezvous.Client
+	report bool
+}
+
+func NewTelemetry(client *rendezvous.Client, report bool) *Telemetry {
+	return &Telemetry{
+		client: client,
+		report: report,
+	}
+}
+
+// Record completes the report of an attempt which started at start and ended with err.
+// The outcome is also added to the span of ctx.
+func (t *Telemetry) Record(ctx context.Context, r *rendezvous.TraversalReport, start time.Time, err error) {
+	r.Duration = time.Since(start)
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	result := rendezvous.OutcomeFailed
+	if r.Success {
+		result = rendezvous.OutcomeSucceeded
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("wgnt.strategy", r.Strategy),
+		attribute.String("wgnt.local_nat", r.LocalNAT.String()),
+		attribute.String("wgnt.remote_nat", r.RemoteNAT.String()),
+		attribute.String("wgnt.outcome", result),
+	)
+	traversalsTotal.WithLabelValues(r.Strategy, r.LocalNAT.String(), r.RemoteNAT.String(), result).Inc()
+	traversalDuration.WithLabelValues(r.Strategy).Observe(r.Duration.Seconds())
+	if r.Strategy == rendezvous.StrategyGuessRemote || r.Strategy == rendezvous.StrategyGuessLocal {
+		probesSent.WithLabelValues(r.Strategy).Observe(float64(r.ProbesSent))
+		if r.FirstResponse > 0 {
+			firstResponseTime.WithLabelValues(r.Strategy).Observe(r.FirstResponse.Seconds())
+		}
+	}
+
+	if t.report && r.Strategy != "" {
+		if err := t.client.Report(ctx, r); err != nil {
+			log.Printf("failed to report traversal: %v", err)
+		}
+	}
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+
+	"github.com/nohajc/wg-nat-traversal/common/tunnel"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+)
+
+// startUserspace starts the userspace device with the tunnel addresses of the setup.
+func startUserspace(iface string, s Setup, u Userspace) (*wireguard.Userspace, error) {
+	var addrs, dns []netip.Addr
+	for _, a := range s.Addresses {
+		prefix, err := netip.ParsePrefix(a)
+		if err != nil {
+			return nil, err
+		}
+		addrs = append(addrs, prefix.Addr())
+	}
+	for _, d := range u.DNS {
+		addr, err := netip.ParseAddr(d)
+		if err != nil {
+			return nil, err
+		}
+		dns = append(dns, addr)
+	}
+
+	dev, err := wireguard.NewUserspace(iface, addrs, dns, s.MTU, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting userspace Wireguard: %w", err)
+	}
+	log.Printf("userspace Wireguard %s started with addresses %v", iface, addrs)
+	return dev, nil
+}
+
+// serveTunnel exposes the tunnel of the userspace device through the proxies and forwards.
+func serveTunnel(dev *wireguard.Userspace, u Userspace) error {
+	tnet := dev.Net()
+	var localDialer net.Dialer
+
+	if u.SOCKS5 != "" {
+		l, err := net.Listen("tcp", u.SOCKS5)
+		if err != nil {
+			return err
+		}
+		log.Printf("SOCKS5 proxy: %s", l.Addr())
+		go func() {
+			log.Fatal(tunnel.ServeSOCKS5(l, tnet.DialContext))
+		}()
+	}
+
+	if u.HTTPProxy != "" {
+		l, err := net.Listen("tcp", u.HTTPProxy)
+		if err != nil {
+			return err
+		}
+		log.Printf("HTTP proxy: %s", l.Addr())
+		go func() {
+			log.Fatal(tunnel.ServeHTTPProxy(l, tnet.DialContext))
+		}()
+	}
+
+	for _, f := range u.Forwards {
+		l, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			return err
+		}
+		log.Printf("forwarding %s to %s in the tunnel", l.Addr(), f.Target)
+		go func(f Forward) {
+			log.Fatal(tunnel.Forward(l, tnet.DialContext, f.Target))
+		}(f)
+	}
+
+	for _, f := range u.ReverseForwards {
+		addr, err := net.ResolveTCPAddr("tcp", f.Listen)
+		if err != nil {
+			return err
+		}
+		l, err := tnet.ListenTCP(addr)
+		if err != nil {
+			return fmt.Errorf("error listening on %s in the tunnel: %w", f.Listen, err)
+		}
+		log.Printf("forwarding %s in the tunnel to %s", f.Listen, f.Target)
+		go func(f Forward) {
+			log.Fatal(tunnel.Forward(l, localDialer.DialContext, f.Target))
+		}(f)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/nohajc/wg-nat-traversal/common/wgquick"
+)
+
+// loadWgQuickPeers sets up the peers of the wg-quick file of the interface.
+func loadWgQuickPeers(cfg *Config) error {
+	path := cfg.WgQuick.path(cfg.Interface)
+	f, err := wgquick.Load(path)
+	if err != nil {
+		return err
+	}
+	specs, err := f.Peers()
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("no peers in %s", path)
+	}
+
+	for _, p := range specs {
+		cfg.Setup.Peers = append(cfg.Setup.Peers, Peer{
+			PublicKey:    p.PublicKey,
+			PresharedKey: p.PresharedKey,
+			Endpoint:     p.Endpoint,
+			AllowedIPs:   p.AllowedIPs,
+			Keepalive:    p.Keepalive,
+		})
+	}
+	log.Printf("%d peers loaded from %s", len(specs), path)
+	return nil
+}
+
+// persistEndpoint writes the result of a traversal to the wg-quick file.
+func persistEndpoint(w WgQuick, iface, peerPubKey string, params *STUNParams) error {
+	return wgquick.Update(w.path(iface), func(f *wgquick.File) error {
+		endpoint := net.JoinHostPort(params.remote.PublicIP, strconv.Itoa(params.remote.PublicPort))
+		if err := f.SetEndpoint(peerPubKey, endpoint); err != nil {
+			return err
+		}
+		if w.ListenPort {
+			return f.SetListenPort(params.localPrivPort)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const traversalLogSize = 256
+
+// TraversalLog keeps the most recent traversal records.
+type TraversalLog struct {
+	records []rendezvous.TraversalRecord
+	next    int
+	mu      sync.Mutex
+}
+
+func (tl *TraversalLog) Add(rec rendezvous.TraversalRecord) {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	if len(tl.records) < traversalLogSize {
+		tl.records = append(tl.records, rec)
+		return
+	}
+	tl.records[tl.next] = rec
+	tl.next = (tl.next + 1) % traversalLogSize
+}
+
+// Records returns the records from the oldest to the newest.
+func (tl *TraversalLog) Records() []rendezvous.TraversalRecord {
+	tl.mu.Lock()
+	defer tl.mu.Unlock()
+
+	result := make([]rendezvous.TraversalRecord, 0, len(tl.records))
+	result = append(result, tl.records[tl.next:]...)
+	result = append(result, tl.records[:tl.next]...)
+	return result
+}
+
+var traversalLog = &TraversalLog{}
+
+func listPeers() []rendezvous.PeerRecord {
+	peerTableMu.Lock()
+	defer peerTableMu.Unlock()
+
+	result := make([]rendezvous.PeerRecord, 0, len(peerTable))
+	for id, entry := range peerTable {
+		result = append(result, rendezvous.PeerRecord{
+			Network:      id.Network,
+			PubKey:       id.PubKey,
+			Info:         entry.Value,
+			Tags:         entry.Tags,
+			RegisteredAt: entry.RegisteredAt,
+			ExpiresAt:    entry.ExpiresAt,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].RegisteredAt.Before(result[j].RegisteredAt)
+	})
+	return result
+}
+
+func evictPeer(id PeerID) bool {
+	peerTableMu.Lock()
+	defer peerTableMu.Unlock()
+
+	entry, ok := peerTable[id]
+	if !ok {
+		return false
+	}
+	entry.Expiry.Stop()
+	delete(peerTable, id)
+	return true
+}
+
+func (wsr *WebSockRouter) listClients() []rendezvous.ClientRecord {
+	wsr.clientsMu.RLock()
+	defer wsr.clientsMu.RUnlock()
+
+	result := make([]rendezvous.ClientRecord, 0, len(wsr.clients))
+	for id, c := range wsr.clients {
+		result = append(result, rendezvous.ClientRecord{
+			Network:     id.Network,
+			PubKey:      id.PubKey,
+			Tags:        c.tags,
+			RemoteAddr:  c.conn.RemoteAddr().String(),
+			ConnectedAt: c.connectedAt,
+			QueueLen:    len(c.writeChan),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ConnectedAt.Before(result[j].ConnectedAt)
+	})
+	return result
+}
+
+func (wsr *WebSockRouter) kickClient(id PeerID) bool {
+	c, ok := wsr.GetClient(id)
+	if !ok {
+		return false
+	}
+	wsr.RemoveClient(c)
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json encode error: %v", err)
+	}
+}
+
+// adminAuthorized checks the admin token of the request.
+// Nothing is authorized when no token is configured.
+func adminAuthorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" || subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+		st := http.StatusUnauthorized
+		http.Error(w, http.StatusText(st), st)
+		return false
+	}
+	return true
+}
+
+// adminOnly requires the admin token for the handler.
+func adminOnly(token string, h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminAuthorized(w, r, token) {
+			h.ServeHTTP(w, r)
+		}
+	}
+}
+
+// adminHandler serves the administrative API under the admin/ path.
+// It is only enabled when a token is configured.
+func (wsr *WebSockRouter) adminHandler(token string, prefix string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(w, r, token) {
+			return
+		}
+
+		resource := strings.TrimPrefix(r.URL.Path, prefix)
+		id := PeerID{
+			Network: r.URL.Query().Get("network"),
+			PubKey:  r.URL.Query().Get("pubkey"),
+		}
+
+		switch {
+		case r.Method == http.MethodGet && resource == "peers":
+			writeJSON(w, listPeers())
+		case r.Method == http.MethodGet && resource == "clients":
+			writeJSON(w, wsr.listClients())
+		case r.Method == http.MethodGet && resource == "traversals":
+			writeJSON(w, traversalLog.Records())
+		case r.Method == http.MethodDelete && (resource == "peers" || resource == "clients"):
+			if id.PubKey == "" {
+				st := http.StatusBadRequest
+				http.Error(w, http.StatusText(st), st)
+				return
+			}
+
+			var found bool
+			if resource == "peers" {
+				found = evictPeer(id)
+			} else {
+				found = wsr.kickClient(id)
+			}
+			if !found {
+				st := http.StatusNotFound
+				http.Error(w, http.StatusText(st), st)
+				return
+			}
+			log.Printf("admin: removed %s from %s", id, resource)
+		default:
+			st := http.StatusNotFound
+			http.Error(w, http.StatusText(st), st)
+		}
+	}
+}
+
+func recordTraversal(ctx context.Context, from string, to PeerID, outcome string) {
+	lookupsTotal.WithLabelValues(outcome).Inc()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("wgnt.outcome", outcome))
+	traversalLog.Add(rendezvous.TraversalRecord{
+		Time:    time.Now(),
+		Network: to.Network,
+		From:    from,
+		To:      to.PubKey,
+		Outcome: outcome,
+	})
+}
+
+// reportHandler accepts traversal reports sent by clients
+// about peers of their network.
+func (wsr *WebSockRouter) reportHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := wsr.authorizePeer(w, r)
+	if !ok {
+		return
+	}
+	if r.Method != http.MethodPost {
+		st := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+	if !wsr.authenticate(w, r) {
+		return
+	}
+
+	_, span := startSpan(r, "rendezvous.report", id)
+	defer span.End()
+
+	report := &rendezvous.TraversalReport{}
+	if err := json.NewDecoder(r.Body).Decode(report); err != nil {
+		log.Printf("json decode error: %v", err)
+		errorsTotal.WithLabelValues(errJSONDecode).Inc()
+		st := http.StatusBadRequest
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+	// only traversals to peers of the same network are recorded
+	if !wsr.known(PeerID{Network: id.Network, PubKey: report.Peer}) {
+		log.Printf("report of %s about unknown peer %q", id, report.Peer)
+		errorsTotal.WithLabelValues(errBadRequest).Inc()
+		st := http.StatusNotFound
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+
+	outcome := rendezvous.OutcomeFailed
+	if report.Success {
+		outcome = rendezvous.OutcomeSucceeded
+	}
+	strategy := strategyLabel(report.Strategy)
+	traversalsTotal.WithLabelValues(strategy,
+		report.LocalNAT.String(), report.RemoteNAT.String(), outcome).Inc()
+	traversalDuration.WithLabelValues(strategy).Observe(report.Duration.Seconds())
+	span.SetAttributes(
+		attribute.String("wgnt.peer", report.Peer),
+		attribute.String("wgnt.strategy", report.Strategy),
+		attribute.String("wgnt.outcome", outcome),
+	)
+
+	traversalLog.Add(rendezvous.TraversalRecord{
+		Time:    time.Now(),
+		Network: id.Network,
+		From:    id.PubKey,
+		To:      report.Peer,
+		Outcome: outcome,
+		Report:  report,
+	})
+	log.Printf("traversal %s -> %s %s (%s, %v)", id, report.Peer, outcome, report.Strategy, report.Duration)
+}
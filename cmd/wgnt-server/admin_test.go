@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+)
+
+// TestReport checks that only reports of authenticated peers about peers
+// of their network are recorded.
+func TestReport(t *testing.T) {
+	timeouts.Store(&DefaultConfig().Timeouts)
+	traversalLog = &TraversalLog{}
+	keyA, keyB, keyC := newKey(t), newKey(t), newKey(t)
+	pubA, pubB, pubC := keyA.PublicKey().String(), keyB.PublicKey().String(), keyC.PublicKey().String()
+
+	policy := &PolicyStore{}
+	policy.policy.Store(&Policy{Rules: []Rule{{Src: []string{"*"}, Dst: []string{"*"}}}})
+	proofs, err := rendezvous.NewProofVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsr := NewWebSockRouter(nil, policy, proofs)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", wsr.requestHandler)
+	mux.HandleFunc("/report", wsr.reportHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// B in the default network, C in another one
+	for _, peer := range []struct {
+		pubKey string
+		opts   []rendezvous.Option
+	}{
+		{pubB, []rendezvous.Option{rendezvous.WithIdentity(pubB), rendezvous.WithPrivateKey(keyB)}},
+		{pubC, []rendezvous.Option{rendezvous.WithIdentity(pubC), rendezvous.WithPrivateKey(keyC), rendezvous.WithNetwork("other", "")}},
+	} {
+		c, err := rendezvous.NewClient(srv.URL, peer.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Publish(context.Background(), peer.pubKey, &nat.STUNInfo{PublicIP: "1.2.3.4", PublicPort: 51820}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts []rendezvous.Option
+		peer string
+		code int
+	}{
+		{"recorded", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyA)}, pubB, 0},
+		{"claimed identity", []rendezvous.Option{rendezvous.WithIdentity(pubA)}, pubB, http.StatusUnauthorized},
+		{"claimed identity with own key", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyC)}, pubB, http.StatusUnauthorized},
+		{"other network", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyA)}, pubC, http.StatusNotFound},
+		{"unknown peer", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyA)}, newKey(t).PublicKey().String(), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, rendezvous.WithBackoff(rendezvous.Backoff{Attempts: 1}))
+			c, err := rendezvous.NewClient(srv.URL, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = c.Report(context.Background(), &rendezvous.TraversalReport{Peer: tt.peer, Success: true})
+
+			if tt.code != 0 {
+				var se *rendezvous.StatusError
+				if !errors.As(err, &se) || se.Code != tt.code {
+					t.Errorf("got %v, want status %d", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	records := traversalLog.Records()
+	if len(records) != 1 || records[0].From != pubA || records[0].To != pubB {
+		t.Errorf("got records %+v", records)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config of the server, read from a YAML file.
+// Command line flags take precedence over the file.
+type Config struct {
+	Listen        string        `yaml:"listen"`
+	Base          string        `yaml:"base"`
+	Networks      string        `yaml:"networks"`
+	Policy        string        `yaml:"policy"`
+	PolicyPoll    time.Duration `yaml:"policy_poll"`
+	AdminToken    string        `yaml:"admin_token"`
+	MetricsListen string        `yaml:"metrics_listen"`
+	OTLP          string        `yaml:"otlp"`
+	MappingProbe  string        `yaml:"mapping_probe"`
+	TLS           TLSConfig     `yaml:"tls"`
+	Hub           HubConfig     `yaml:"hub"`
+
+	Timeouts `yaml:",inline"`
+}
+
+type TLSConfig struct {
+	Cert  string   `yaml:"cert"`
+	Key   string   `yaml:"key"`
+	Auto  bool     `yaml:"auto"`
+	CADir string   `yaml:"ca_dir"`
+	Hosts []string `yaml:"hosts"`
+}
+
+// HubConfig makes the server a hub: a Wireguard peer of all clients,
+// which reports the endpoints it sees on its interface.
+type HubConfig struct {
+	// disabled if empty
+	Interface string `yaml:"interface"`
+	// UAPI socket directory of a userspace implementation (default kernel)
+	UAPI string `yaml:"uapi"`
+	// network of the peers of the interface
+	Network string `yaml:"network"`
+	// endpoints of peers without a handshake for this long are not reported
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// Timeouts are reloaded on SIGHUP.
+type Timeouts struct {
+	// how long a registration is kept
+	PeerTTL time.Duration `yaml:"peer_ttl"`
+	// how often WebSocket clients are pinged,
+	// connected clients switch to a reloaded interval after their next ping
+	PingInterval time.Duration `yaml:"ping_interval"`
+	// how long to wait for a pong before the client is dropped
+	PongWait time.Duration `yaml:"pong_wait"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:     ":8080",
+		Base:       "/",
+		PolicyPoll: 5 * time.Second,
+		TLS: TLSConfig{
+			CADir: "wgnt-ca",
+		},
+		Hub: HubConfig{
+			// a session without a handshake expires after 3 minutes
+			MaxAge: 3 * time.Minute,
+		},
+		Timeouts: Timeouts{
+			PeerTTL:      20 * time.Second,
+			PingInterval: 20 * time.Second,
+			PongWait:     30 * time.Second,
+		},
+	}
+}
+
+// LoadConfig reads the YAML file at path into cfg.
+// Settings missing from the file keep their values.
+func LoadConfig(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	switch {
+	case c.Listen == "":
+		return errors.New("missing listen address")
+	case c.PolicyPoll <= 0:
+		return errors.New("policy_poll must be positive")
+	case (c.TLS.Cert == "") != (c.TLS.Key == ""):
+		return errors.New("tls: both cert and key are required")
+	case c.TLS.Auto && c.TLS.Cert != "":
+		return errors.New("tls: auto cannot be combined with cert and key")
+	case c.Hub.Interface != "" && c.Hub.MaxAge <= 0:
+		return errors.New("hub: max_age must be positive")
+	}
+	return c.Timeouts.Validate()
+}
+
+func (t *Timeouts) Validate() error {
+	switch {
+	case t.PeerTTL <= 0:
+		return errors.New("peer_ttl must be positive")
+	case t.PingInterval <= 0:
+		return errors.New("ping_interval must be positive")
+	case t.PongWait <= t.PingInterval:
+		return errors.New("pong_wait must be longer than ping_interval")
+	}
+	return nil
+}
+
+var timeouts atomic.Pointer[Timeouts]
+
+// reloadOnSignal reloads the timeouts from the config file
+// and the networks file on SIGHUP.
+func (wsr *WebSockRouter) reloadOnSignal(configPath, networksPath string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	for range hup {
+		wsr.reload(configPath, networksPath)
+	}
+}
+
+// reload reads the files which are given.
+// Invalid files are reported and the previous settings stay in effect.
+func (wsr *WebSockRouter) reload(configPath, networksPath string) {
+	if configPath != "" {
+		cfg := DefaultConfig()
+		err := LoadConfig(configPath, cfg)
+		if err == nil {
+			err = cfg.Timeouts.Validate()
+		}
+		if err != nil {
+			log.Printf("config reload failed: %v", err)
+		} else {
+			timeouts.Store(&cfg.Timeouts)
+			log.Printf("config reloaded from %s", configPath)
+		}
+	}
+
+	if networksPath != "" {
+		networks, err := LoadNetworks(networksPath)
+		if err != nil {
+			log.Printf("networks reload failed: %v", err)
+		} else {
+			wsr.networks.Store(networks)
+			log.Printf("networks reloaded from %s", networksPath)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/tracing"
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// how long a notification suppresses further ones for the same pair of peers
+const hubNotifyInterval = 10 * time.Second
+
+// Hub reflects the endpoints of the peers of a Wireguard interface of the server.
+// Every client is a peer of the hub, so the hub sees the public address
+// of its Wireguard port, which the other peers can connect to directly
+// (provided the NAT mapping does not depend on the destination).
+type Hub struct {
+	wg      *wireguard.WgClient
+	network string
+	maxAge  time.Duration
+
+	mu sync.Mutex
+	// when a peer (first) was notified about a lookup by another one (second)
+	notified map[[2]PeerID]time.Time
+}
+
+func NewHub(cfg HubConfig) (*Hub, error) {
+	var wg *wireguard.WgClient
+	if cfg.UAPI != "" {
+		wg = wireguard.NewWgClientWithBackend(wireguard.NewUAPIBackend(cfg.UAPI), cfg.Interface)
+	} else {
+		var err error
+		wg, err = wireguard.NewWgClient(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := wg.GetPeers(); err != nil {
+		return nil, err
+	}
+	return &Hub{
+		wg:       wg,
+		network:  cfg.Network,
+		maxAge:   cfg.MaxAge,
+		notified: map[[2]PeerID]time.Time{},
+	}, nil
+}
+
+// lookup returns the endpoint of the peer or nil if it is unknown or stale,
+// i.e. the peer is not connected to the hub.
+func (h *Hub) lookup(pubKey string) (*nat.STUNInfo, error) {
+	p, err := h.wg.FindPeerByPublicKey(pubKey)
+	if errors.Is(err, wireguard.ErrPeerNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Endpoint == nil || time.Since(p.LastHandshakeTime) > h.maxAge {
+		return nil, nil
+	}
+	return &nat.STUNInfo{
+		PublicIP:   p.Endpoint.IP.String(),
+		PublicPort: p.Endpoint.Port,
+		NATKind:    nat.NAT_EASY,
+	}, nil
+}
+
+// shouldNotify reports whether the peer looked up by from should be asked
+// to connect back. It should not if the lookup answers its own notification
+// (which would start a loop) or if it has been notified recently.
+func (h *Hub) shouldNotify(id, from PeerID) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for k, t := range h.notified {
+		if now.Sub(t) > hubNotifyInterval {
+			delete(h.notified, k)
+		}
+	}
+
+	if _, ok := h.notified[[2]PeerID{from, id}]; ok {
+		delete(h.notified, [2]PeerID{from, id})
+		return false
+	}
+	if _, ok := h.notified[[2]PeerID{id, from}]; ok {
+		return false
+	}
+	h.notified[[2]PeerID{id, from}] = now
+	return true
+}
+
+// hubHandler answers lookups of endpoints seen by the hub. The API is
+// the same as for registered peers, both peers are notified to connect
+// to each other.
+func (wsr *WebSockRouter) hubHandler(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := wsr.authorizePeer(w, r)
+		if !ok {
+			return
+		}
+		if r.Method != http.MethodGet {
+			st := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		if hub == nil || id.Network != hub.network {
+			st := http.StatusNotFound
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		log.Printf("hub GET request with pubkey = %s", id)
+
+		from := r.URL.Query().Get("from")
+		ctx, span := startSpan(r, "rendezvous.hub_lookup", id)
+		span.SetAttributes(attribute.String("wgnt.from", from))
+		var err error
+		defer func() {
+			tracing.End(span, err)
+		}()
+
+		if from != "" && !wsr.authenticate(w, r) {
+			return
+		}
+		if !wsr.allowed(r, id) {
+			recordTraversal(ctx, from, id, rendezvous.OutcomeDenied)
+			log.Printf("policy denies lookup of %s by %q", id, from)
+			st := http.StatusForbidden
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+
+		var info *nat.STUNInfo
+		info, err = hub.lookup(id.PubKey)
+		if err != nil {
+			log.Printf("error reading hub peers: %v", err)
+			errorsTotal.WithLabelValues(errHub).Inc()
+			st := http.StatusInternalServerError
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+
+		outcome := rendezvous.OutcomePending
+		if from != "" && hub.shouldNotify(id, PeerID{Network: id.Network, PubKey: from}) {
+			outcome = wsr.notify(ctx, id, from)
+		}
+		if info == nil {
+			recordTraversal(ctx, from, id, outcome)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		recordTraversal(ctx, from, id, rendezvous.OutcomeResolved)
+		if err = json.NewEncoder(w).Encode(info); err != nil {
+			log.Printf("json encode error: %v", err)
+			errorsTotal.WithLabelValues(errJSONEncode).Inc()
+		}
+	}
+}
@@ -3,79 +3,141 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/nohajc/wg-nat-traversal/common/certs"
+	"github.com/nohajc/wg-nat-traversal/common/flags"
 	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/nohajc/wg-nat-traversal/common/tracing"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Entry struct {
-	Value  nat.STUNInfo
-	Expiry *time.Timer
+	Value        nat.STUNInfo
+	Tags         []string
+	Expiry       *time.Timer
+	RegisteredAt time.Time
+	ExpiresAt    time.Time
 }
 
 var upgrader = websocket.Upgrader{}
 
-var peerTable = map[string]*Entry{}
-var peerTableMu sync.Mutex
+// longer delays than this are not worth measuring, keepalives are cheap
+const maxMappingProbeDelay = 5 * time.Minute
+
+// PeerID identifies a peer within its network.
+type PeerID struct {
+	Network string
+	PubKey  string
+}
+
+func (id PeerID) String() string {
+	if id.Network == "" {
+		return id.PubKey
+	}
+	return fmt.Sprintf("%s/%s", id.Network, id.PubKey)
+}
 
-var pongWait = 30 * time.Second
-var pingInterval = pongWait * 2 / 3
+var peerTable = map[PeerID]*Entry{}
+var peerTableMu sync.Mutex
 
 type WebSockRouter struct {
-	clients   map[string]*Client
+	clients   map[PeerID]*Client
 	clientsMu sync.RWMutex
+	networks  atomic.Pointer[Networks]
+	policy    *PolicyStore
+	proofs    *rendezvous.ProofVerifier
 }
 
-func NewWebSockRouter() *WebSockRouter {
-	return &WebSockRouter{
-		clients: map[string]*Client{},
+func NewWebSockRouter(networks *Networks, policy *PolicyStore, proofs *rendezvous.ProofVerifier) *WebSockRouter {
+	wsr := &WebSockRouter{
+		clients: map[PeerID]*Client{},
+		policy:  policy,
+		proofs:  proofs,
 	}
+	wsr.networks.Store(networks)
+	return wsr
 }
 
-func (wsr *WebSockRouter) AddClient(pubKey string, c *Client) {
+func (wsr *WebSockRouter) AddClient(id PeerID, c *Client) {
 	wsr.clientsMu.Lock()
-	wsr.clients[pubKey] = c
+	if old, ok := wsr.clients[id]; ok {
+		wsr.closeClient(old)
+	}
+	wsr.clients[id] = c
 	wsr.clientsMu.Unlock()
 }
 
-func (wsr *WebSockRouter) RemoveClient(pubKey string) {
+func (wsr *WebSockRouter) GetClient(id PeerID) (*Client, bool) {
+	wsr.clientsMu.RLock()
+	defer wsr.clientsMu.RUnlock()
+
+	c, ok := wsr.clients[id]
+	return c, ok
+}
+
+func (wsr *WebSockRouter) RemoveClient(c *Client) {
 	wsr.clientsMu.Lock()
 	defer wsr.clientsMu.Unlock()
 
-	c, ok := wsr.clients[pubKey]
-	if !ok {
+	if cur, ok := wsr.clients[c.id]; !ok || cur != c {
 		return
 	}
+	wsr.closeClient(c)
+	delete(wsr.clients, c.id)
+}
 
-	close(c.writeChan)
-	if err := c.conn.Close(); err != nil {
-		log.Printf("error closing socket: %v", err)
-	}
-
-	delete(wsr.clients, pubKey)
+// closeClient stops the writer of the client. The write channel stays open,
+// notify may still hold the client it got before the client was replaced.
+func (wsr *WebSockRouter) closeClient(c *Client) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		if err := c.conn.Close(); err != nil {
+			log.Printf("error closing socket: %v", err)
+		}
+	})
 }
 
 type Client struct {
-	pubKey    string
-	conn      *websocket.Conn
-	router    *WebSockRouter
-	writeChan chan WriteRequest
+	id          PeerID
+	tags        []string
+	conn        *websocket.Conn
+	router      *WebSockRouter
+	writeChan   chan WriteRequest
+	connectedAt time.Time
+	// closed when the client is removed
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func NewClient(pubKey string, conn *websocket.Conn, router *WebSockRouter) *Client {
+func NewClient(id PeerID, conn *websocket.Conn, router *WebSockRouter) *Client {
 	return &Client{
-		pubKey:    pubKey,
-		conn:      conn,
-		router:    router,
-		writeChan: make(chan WriteRequest, 4096),
+		id:          id,
+		conn:        conn,
+		router:      router,
+		writeChan:   make(chan WriteRequest, 4096),
+		connectedAt: time.Now(),
+		done:        make(chan struct{}),
 	}
 }
 
+var errClientClosed = errors.New("client disconnected")
+
 type WriteRequest struct {
 	message    Message
 	statusChan chan error
@@ -101,12 +163,12 @@ func (c *Client) readMessage() (Message, error) {
 }
 
 func (c *Client) readIncoming() {
-	defer c.router.RemoveClient(c.pubKey)
+	defer c.router.RemoveClient(c)
 
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadDeadline(time.Now().Add(timeouts.Load().PongWait))
 	c.conn.SetPongHandler(func(appData string) error {
 		log.Println("pong")
-		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		return c.conn.SetReadDeadline(time.Now().Add(timeouts.Load().PongWait))
 	})
 
 	for {
@@ -114,6 +176,7 @@ func (c *Client) readIncoming() {
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("socket read error: %v", err)
+				errorsTotal.WithLabelValues(errSocketRead).Inc()
 			}
 			break
 		}
@@ -123,31 +186,43 @@ func (c *Client) readIncoming() {
 
 func (c *Client) writeMessage(ctx context.Context, msg Message) error {
 	req := MakeWriteRequest(msg)
-	c.writeChan <- req
+	select {
+	case c.writeChan <- req:
+	case <-c.done:
+		return errClientClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
 	select {
 	case err := <-req.Error():
 		return err
+	case <-c.done:
+		return errClientClosed
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 }
 
 func (c *Client) writeOutgoing() {
+	pingInterval := timeouts.Load().PingInterval
 	ticker := time.NewTicker(pingInterval)
 	defer func() {
 		ticker.Stop()
-		c.router.RemoveClient(c.pubKey)
+		c.router.RemoveClient(c)
 	}()
 
 	for {
 		select {
-		case wReq, ok := <-c.writeChan:
-			if !ok {
-				_ = c.conn.WriteMessage(websocket.CloseMessage, nil)
-				return
-			}
+		case <-c.done:
+			_ = c.conn.WriteMessage(websocket.CloseMessage, nil)
+			return
+
+		case wReq := <-c.writeChan:
 			err := c.conn.WriteJSON(&wReq.message)
+			if err != nil {
+				errorsTotal.WithLabelValues(errSocketWrite).Inc()
+			}
 			wReq.statusChan <- err
 
 		case <-ticker.C:
@@ -155,101 +230,254 @@ func (c *Client) writeOutgoing() {
 			err := c.conn.WriteMessage(websocket.PingMessage, nil)
 			if err != nil {
 				log.Printf("socket write error: %v", err)
+				errorsTotal.WithLabelValues(errSocketWrite).Inc()
 				return
 			}
+			// the interval may have been reloaded
+			if d := timeouts.Load().PingInterval; d != pingInterval {
+				pingInterval = d
+				ticker.Reset(d)
+			}
 		}
 	}
 }
 
-func (wsr *WebSockRouter) wsRequestHandler(w http.ResponseWriter, r *http.Request) {
+// authorizePeer identifies the peer the request is about.
+// It writes an error response and returns false if the request is invalid.
+func (wsr *WebSockRouter) authorizePeer(w http.ResponseWriter, r *http.Request) (PeerID, bool) {
 	pubKey := r.URL.Query().Get("pubkey")
 	if pubKey == "" {
+		errorsTotal.WithLabelValues(errBadRequest).Inc()
 		st := http.StatusBadRequest
 		http.Error(w, http.StatusText(st), st)
+		return PeerID{}, false
+	}
+
+	network, ok := wsr.networks.Load().Authorize(r)
+	if !ok {
+		log.Printf("unauthorized request for network %q", r.URL.Query().Get("network"))
+		errorsTotal.WithLabelValues(errUnauthorized).Inc()
+		st := http.StatusUnauthorized
+		http.Error(w, http.StatusText(st), st)
+		return PeerID{}, false
+	}
+	return PeerID{Network: network, PubKey: pubKey}, true
+}
+
+func (wsr *WebSockRouter) wsRequestHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := wsr.authorizePeer(w, r)
+	if !ok || !wsr.authenticate(w, r) {
 		return
 	}
 
+	_, span := startSpan(r, "rendezvous.subscribe", id)
 	conn, err := upgrader.Upgrade(w, r, nil)
+	tracing.End(span, err)
 	if err != nil {
 		log.Printf("socket upgrade failed: %v", err)
+		errorsTotal.WithLabelValues(errSocketUpgrade).Inc()
 		return
 	}
 
-	c := NewClient(pubKey, conn, wsr)
-	wsr.AddClient(pubKey, c)
+	c := NewClient(id, conn, wsr)
+	c.tags = r.URL.Query()["tag"]
+	wsr.AddClient(id, c)
 
 	go c.readIncoming()
 	go c.writeOutgoing()
 }
 
+// peerTags returns tags advertised by a registered or listening peer.
+func (wsr *WebSockRouter) peerTags(id PeerID) []string {
+	peerTableMu.Lock()
+	entry, ok := peerTable[id]
+	peerTableMu.Unlock()
+	if ok {
+		return entry.Tags
+	}
+
+	if c, ok := wsr.GetClient(id); ok {
+		return c.tags
+	}
+	return nil
+}
+
+// known reports whether the peer is registered or listening.
+func (wsr *WebSockRouter) known(id PeerID) bool {
+	peerTableMu.Lock()
+	_, ok := peerTable[id]
+	peerTableMu.Unlock()
+	if ok {
+		return true
+	}
+	_, ok = wsr.GetClient(id)
+	return ok
+}
+
+// authenticate verifies that the requesting peer owns its key: the from peer
+// of a lookup or the peer itself when it registers, subscribes or reports.
+// Public keys are no secret, so without the proof anyone could get past
+// the access control policy, take over the notifications of another peer
+// or register endpoints in its name. It is not needed without a policy.
+// It writes an error response with the server key and returns false
+// if the proof is missing or invalid.
+func (wsr *WebSockRouter) authenticate(w http.ResponseWriter, r *http.Request) bool {
+	if wsr.policy.Get() == nil {
+		return true
+	}
+
+	err := wsr.proofs.Verify(r)
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, rendezvous.ErrProofMissing) {
+		log.Printf("rejected identity %q: %v", rendezvous.Identity(r), err)
+		errorsTotal.WithLabelValues(errUnauthenticated).Inc()
+	}
+	w.Header().Set(rendezvous.ProofKeyHeader, wsr.proofs.PublicKey())
+	st := http.StatusUnauthorized
+	http.Error(w, http.StatusText(st), st)
+	return false
+}
+
+// allowed checks whether the requesting peer (given by the from parameter
+// and verified by authenticate) may discover the peer identified by id.
+func (wsr *WebSockRouter) allowed(r *http.Request, id PeerID) bool {
+	policy := wsr.policy.Get()
+	if policy == nil {
+		return true
+	}
+
+	from := r.URL.Query().Get("from")
+	if from == "" {
+		return false
+	}
+	src := PeerID{Network: id.Network, PubKey: from}
+	return policy.Allows(src, wsr.peerTags(src), id, wsr.peerTags(id))
+}
+
+// notify asks the peer identified by id (if it is listening) to connect
+// to the peer from. It returns the outcome of the lookup.
+func (wsr *WebSockRouter) notify(ctx context.Context, id PeerID, from string) string {
+	wsPeer, ok := wsr.GetClient(id)
+	if !ok {
+		return rendezvous.OutcomePending
+	}
+
+	start := time.Now()
+	notifyCtx, notifySpan := tracer.Start(ctx, "rendezvous.notify")
+	err := wsPeer.writeMessage(notifyCtx, Message{
+		Test:  "msg",
+		From:  from,
+		Trace: tracing.Inject(notifyCtx),
+	})
+	tracing.End(notifySpan, err)
+	if err != nil {
+		log.Printf("failed to notify peer %s: %v", id, err)
+		errorsTotal.WithLabelValues(errNotify).Inc()
+		return rendezvous.OutcomePending
+	}
+	notificationLatency.Observe(time.Since(start).Seconds())
+	log.Printf("notified peer %s", id)
+	return rendezvous.OutcomeNotified
+}
+
 func (wsr *WebSockRouter) requestHandler(w http.ResponseWriter, r *http.Request) {
-	pubKey := r.URL.Query().Get("pubkey")
-	if pubKey == "" {
-		st := http.StatusBadRequest
-		http.Error(w, http.StatusText(st), st)
+	id, ok := wsr.authorizePeer(w, r)
+	if !ok {
 		return
 	}
 
 	switch r.Method {
 	case http.MethodGet:
-		log.Printf("GET request with pubkey = %s", pubKey)
+		log.Printf("GET request with pubkey = %s", id)
+
+		from := r.URL.Query().Get("from")
+		ctx, span := startSpan(r, "rendezvous.lookup", id)
+		span.SetAttributes(attribute.String("wgnt.from", from))
+		defer span.End()
+
+		// anonymous lookups are denied by the policy
+		if from != "" && !wsr.authenticate(w, r) {
+			return
+		}
+		if !wsr.allowed(r, id) {
+			recordTraversal(ctx, from, id, rendezvous.OutcomeDenied)
+			log.Printf("policy denies lookup of %s by %q", id, r.URL.Query().Get("from"))
+			st := http.StatusForbidden
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
 
 		peerTableMu.Lock()
-		peer, ok := peerTable[pubKey]
+		peer, ok := peerTable[id]
 		peerTableMu.Unlock()
 
 		if ok {
+			recordTraversal(ctx, from, id, rendezvous.OutcomeResolved)
 			enc := json.NewEncoder(w)
 			err := enc.Encode(&peer.Value)
 			if err != nil {
 				log.Printf("json encode error: %v", err)
+				errorsTotal.WithLabelValues(errJSONEncode).Inc()
 				st := http.StatusInternalServerError
 				http.Error(w, http.StatusText(st), st)
 				return
 			}
 		} else {
-			wsPeer, ok := wsr.clients[pubKey]
-			if ok {
-				err := wsPeer.writeMessage(r.Context(), Message{Test: "msg"})
-				if err == nil {
-					// TODO: wait for response from peer
-					// which will announce the port mapping
-					log.Printf("notified peer %s", pubKey)
-				} else {
-					log.Printf("failed to notify peer %s: %v", pubKey, err)
-				}
-			}
+			// TODO: wait for response from peer
+			// which will announce the port mapping
+			outcome := wsr.notify(ctx, id, from)
+			recordTraversal(ctx, from, id, outcome)
 			w.WriteHeader(http.StatusNoContent)
 		}
 	case http.MethodPost:
-		log.Printf("POST request with pubkey = %s", pubKey)
+		log.Printf("POST request with pubkey = %s", id)
+		if !wsr.authenticate(w, r) {
+			return
+		}
 
+		_, span := startSpan(r, "rendezvous.register", id)
 		info := nat.STUNInfo{}
 		err := json.NewDecoder(r.Body).Decode(&info)
+		span.SetAttributes(attribute.String("wgnt.nat", info.NATKind.String()))
+		tracing.End(span, err)
 		if err != nil {
 			log.Printf("json decode error: %v", err)
+			errorsTotal.WithLabelValues(errJSONDecode).Inc()
 			st := http.StatusBadRequest
 			http.Error(w, http.StatusText(st), st)
 			return
 		}
 
+		registrationsTotal.Inc()
+		tags := r.URL.Query()["tag"]
+		ttl := timeouts.Load().PeerTTL
+
 		peerTableMu.Lock()
-		if entry, ok := peerTable[pubKey]; ok {
+		if entry, ok := peerTable[id]; ok {
+			entry.Tags = tags
 			if entry.Value != info {
-				entry.Expiry.Reset(20 * time.Second)
+				entry.Expiry.Reset(ttl)
+				entry.ExpiresAt = time.Now().Add(ttl)
 				entry.Value = info
 			}
 		} else {
-			expiry := time.AfterFunc(20*time.Second, func() {
+			expiry := time.AfterFunc(ttl, func() {
 				peerTableMu.Lock()
-				delete(peerTable, pubKey)
-				log.Printf("deleted %s from table", pubKey)
+				delete(peerTable, id)
+				expiriesTotal.Inc()
+				log.Printf("deleted %s from table", id)
 				peerTableMu.Unlock()
 			})
 
-			peerTable[pubKey] = &Entry{
-				Value:  info,
-				Expiry: expiry,
+			now := time.Now()
+			peerTable[id] = &Entry{
+				Value:        info,
+				Tags:         tags,
+				Expiry:       expiry,
+				RegisteredAt: now,
+				ExpiresAt:    now.Add(ttl),
 			}
 		}
 		peerTableMu.Unlock()
@@ -257,8 +485,140 @@ func (wsr *WebSockRouter) requestHandler(w http.ResponseWriter, r *http.Request)
 }
 
 func main() {
-	wsr := NewWebSockRouter()
-	http.HandleFunc("/", wsr.requestHandler)
-	http.HandleFunc("/ws", wsr.wsRequestHandler)
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	var configPath string
+	cfg := DefaultConfig()
+
+	flag.StringVar(&configPath, "c", "", "YAML config file (reloaded on SIGHUP), flags take precedence")
+	flag.StringVar(&cfg.Listen, "l", cfg.Listen, "listen address")
+	flag.StringVar(&cfg.Base, "base", cfg.Base, "base path of the API")
+	flag.StringVar(&cfg.Networks, "networks", "", "JSON file with networks and their join tokens, reloaded on SIGHUP (default open access)")
+	flag.StringVar(&cfg.Policy, "policy", "", "JSON file with access control policy, reloaded on change (default allow all)")
+	flag.StringVar(&cfg.AdminToken, "admin-token", "", "token for the admin API (default $WGNT_ADMIN_TOKEN, API disabled if empty)")
+	flag.StringVar(&cfg.MetricsListen, "metrics-listen", "", "separate listen address for /metrics (default same as API, requiring the admin token)")
+	flag.StringVar(&cfg.OTLP, "otlp", "", "OTLP/HTTP endpoint for exporting traces, e.g. localhost:4318 (default $OTEL_EXPORTER_OTLP_ENDPOINT)")
+	flag.StringVar(&cfg.MappingProbe, "mapping-probe", "", fmt.Sprintf("UDP listen address of the NAT mapping timeout probe, e.g. :%d (default disabled)", nat.DefaultMappingProbePort))
+	flag.StringVar(&cfg.Hub.Interface, "hub", "", "Wireguard interface whose peer endpoints are reported by the hub API (default disabled)")
+	flag.StringVar(&cfg.TLS.Cert, "tls-cert", "", "TLS certificate file")
+	flag.StringVar(&cfg.TLS.Key, "tls-key", "", "TLS private key file")
+	flag.BoolVar(&cfg.TLS.Auto, "tls-auto", false, "issue TLS certificates from a local CA")
+	flag.StringVar(&cfg.TLS.CADir, "tls-ca-dir", cfg.TLS.CADir, "directory of the local CA (with -tls-auto)")
+	flag.Var(flags.List(&cfg.TLS.Hosts), "tls-hosts", "comma-separated server names allowed with -tls-auto (default any)")
+	flag.Parse()
+
+	if configPath != "" {
+		if err := LoadConfig(configPath, cfg); err != nil {
+			log.Fatal(err)
+		}
+		// flags override the file
+		flag.Parse()
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	timeouts.Store(&cfg.Timeouts)
+
+	basePath := cfg.Base
+	if !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+	if !strings.HasSuffix(basePath, "/") {
+		basePath += "/"
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), "wgnt-server", cfg.OTLP)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	var networks *Networks
+	if cfg.Networks != "" {
+		networks, err = LoadNetworks(cfg.Networks)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	policy, err := NewPolicyStore(cfg.Policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go policy.Watch(cfg.PolicyPoll)
+
+	proofs, err := rendezvous.NewProofVerifier()
+	if err != nil {
+		log.Fatal(err)
+	}
+	wsr := NewWebSockRouter(networks, policy, proofs)
+	if configPath != "" || cfg.Networks != "" {
+		go wsr.reloadOnSignal(configPath, cfg.Networks)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(basePath, wsr.requestHandler)
+	mux.HandleFunc(basePath+"ws", wsr.wsRequestHandler)
+	mux.HandleFunc(basePath+"report", wsr.reportHandler)
+
+	var hub *Hub
+	if cfg.Hub.Interface != "" {
+		hub, err = NewHub(cfg.Hub)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("hub reporting endpoints of %s", cfg.Hub.Interface)
+	}
+	mux.HandleFunc(basePath+"hub", wsr.hubHandler(hub))
+
+	adminToken := cfg.AdminToken
+	if adminToken == "" {
+		adminToken = os.Getenv("WGNT_ADMIN_TOKEN")
+	}
+	mux.HandleFunc(basePath+"admin/", wsr.adminHandler(adminToken, basePath+"admin/"))
+
+	prometheus.MustRegister(newRouterCollector(wsr))
+	if cfg.MetricsListen == "" {
+		// the API is public, the metrics are not
+		mux.Handle("/metrics", adminOnly(adminToken, promhttp.Handler()))
+	} else {
+		go func() {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", promhttp.Handler())
+			log.Fatal(http.ListenAndServe(cfg.MetricsListen, metricsMux))
+		}()
+	}
+
+	if cfg.MappingProbe != "" {
+		conn, err := net.ListenPacket("udp", cfg.MappingProbe)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("mapping probe listening on udp %s", cfg.MappingProbe)
+		go func() {
+			log.Fatal(nat.ServeMappingProbe(conn, maxMappingProbeDelay))
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Listen,
+		Handler: mux,
+	}
+
+	switch {
+	case cfg.TLS.Cert != "":
+		log.Printf("listening on https://%s%s", cfg.Listen, basePath)
+		log.Fatal(srv.ListenAndServeTLS(cfg.TLS.Cert, cfg.TLS.Key))
+	case cfg.TLS.Auto:
+		ca, err := certs.LoadOrCreateCA(cfg.TLS.CADir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ca.Hosts = cfg.TLS.Hosts
+		log.Printf("local CA: %s (pin %s)", certs.CACertPath(cfg.TLS.CADir), certs.Pin(ca.Cert))
+		srv.TLSConfig = ca.ServerConfig()
+		log.Printf("listening on https://%s%s", cfg.Listen, basePath)
+		log.Fatal(srv.ListenAndServeTLS("", ""))
+	default:
+		log.Printf("listening on http://%s%s", cfg.Listen, basePath)
+		log.Fatal(srv.ListenAndServe())
+	}
 }
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TestNotifyDuringReconnect notifies a peer while it keeps reconnecting,
+// which replaces and closes its client in the middle of the notifications.
+func TestNotifyDuringReconnect(t *testing.T) {
+	timeouts.Store(&DefaultConfig().Timeouts)
+	wsr := NewWebSockRouter(nil, &PolicyStore{}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(wsr.wsRequestHandler))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?pubkey=b"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				notifyCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+				wsr.notify(notifyCtx, PeerID{PubKey: "b"}, "a")
+				cancel()
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Millisecond)
+		defer conn.Close()
+	}
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "wgnt_server"
+
+const (
+	errBadRequest      = "bad_request"
+	errUnauthorized    = "unauthorized"
+	errUnauthenticated = "unauthenticated"
+	errJSONDecode      = "json_decode"
+	errJSONEncode      = "json_encode"
+	errSocketUpgrade   = "socket_upgrade"
+	errSocketRead      = "socket_read"
+	errSocketWrite     = "socket_write"
+	errNotify          = "notify"
+	errHub             = "hub"
+)
+
+// reported by clients with a strategy unknown to the server
+const strategyOther = "other"
+
+// strategyLabel limits the strategy label to the known values,
+// a client must not be able to create arbitrary time series.
+func strategyLabel(strategy string) string {
+	if slices.Contains(rendezvous.Strategies, strategy) {
+		return strategy
+	}
+	return strategyOther
+}
+
+var (
+	registrationsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "registrations_total",
+		Help:      "Number of peer registrations (POST requests).",
+	})
+	lookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "lookups_total",
+		Help:      "Number of peer lookups (GET requests) by outcome.",
+	}, []string{"outcome"})
+	expiriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "expiries_total",
+		Help:      "Number of peer registrations removed after their TTL.",
+	})
+	notificationLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "notification_latency_seconds",
+		Help:      "Time to deliver a WebSocket notification to a listening peer.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+	traversalsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reported_traversals_total",
+		Help:      "Number of traversal attempts reported by clients.",
+	}, []string{"strategy", "local_nat", "remote_nat", "result"})
+	traversalDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reported_traversal_duration_seconds",
+		Help:      "Duration of traversal attempts reported by clients.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"strategy"})
+	errorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of errors by type.",
+	}, []string{"type"})
+)
+
+// routerCollector exports metrics computed from the current server state.
+type routerCollector struct {
+	wsr *WebSockRouter
+
+	peers         *prometheus.Desc
+	clients       *prometheus.Desc
+	queueDepth    *prometheus.Desc
+	queueMaxDepth *prometheus.Desc
+}
+
+func newRouterCollector(wsr *WebSockRouter) *routerCollector {
+	return &routerCollector{
+		wsr: wsr,
+		peers: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "registered_peers"),
+			"Number of registered peers.", nil, nil,
+		),
+		clients: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "websocket_connections"),
+			"Number of peers connected over WebSocket.", nil, nil,
+		),
+		// per network only, the metrics must not reveal the peers
+		queueDepth: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "write_queue_depth"),
+			"Number of messages waiting to be written to the WebSocket clients of a network.",
+			[]string{"network"}, nil,
+		),
+		queueMaxDepth: prometheus.NewDesc(
+			prometheus.BuildFQName(metricsNamespace, "", "write_queue_max_depth"),
+			"Largest number of messages waiting to be written to a WebSocket client of a network.",
+			[]string{"network"}, nil,
+		),
+	}
+}
+
+func (rc *routerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rc.peers
+	ch <- rc.clients
+	ch <- rc.queueDepth
+	ch <- rc.queueMaxDepth
+}
+
+func (rc *routerCollector) Collect(ch chan<- prometheus.Metric) {
+	peerTableMu.Lock()
+	peerCount := len(peerTable)
+	peerTableMu.Unlock()
+	ch <- prometheus.MustNewConstMetric(rc.peers, prometheus.GaugeValue, float64(peerCount))
+
+	rc.wsr.clientsMu.RLock()
+	defer rc.wsr.clientsMu.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(rc.clients, prometheus.GaugeValue, float64(len(rc.wsr.clients)))
+
+	depth, maxDepth := map[string]int{}, map[string]int{}
+	for id, c := range rc.wsr.clients {
+		n := len(c.writeChan)
+		depth[id.Network] += n
+		maxDepth[id.Network] = max(maxDepth[id.Network], n)
+	}
+	for network, n := range depth {
+		ch <- prometheus.MustNewConstMetric(rc.queueDepth, prometheus.GaugeValue, float64(n), network)
+		ch <- prometheus.MustNewConstMetric(rc.queueMaxDepth, prometheus.GaugeValue, float64(maxDepth[network]), network)
+	}
+}
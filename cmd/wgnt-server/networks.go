@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type Network struct {
+	Tokens []string `json:"tokens"`
+}
+
+// Networks authorizes access to isolated peer namespaces.
+// Without a configuration, any network name is accepted without a token.
+type Networks struct {
+	networks map[string]*Network
+}
+
+// LoadNetworks reads a JSON object mapping network names to their join tokens,
+// e.g. {"team-a": {"tokens": ["secret"]}}.
+func LoadNetworks(path string) (*Networks, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	networks := map[string]*Network{}
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	for name, n := range networks {
+		if n == nil || len(n.Tokens) == 0 {
+			return nil, fmt.Errorf("network %q has no tokens", name)
+		}
+	}
+	return &Networks{networks: networks}, nil
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if token, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		return token
+	}
+	return ""
+}
+
+// Authorize returns the network the request belongs to
+// or false if the request does not carry a valid token for it.
+func (n *Networks) Authorize(r *http.Request) (string, bool) {
+	name := r.URL.Query().Get("network")
+	if n == nil || n.networks == nil {
+		return name, true
+	}
+
+	network, ok := n.networks[name]
+	if !ok {
+		return "", false
+	}
+
+	token := []byte(bearerToken(r))
+	for _, t := range network.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
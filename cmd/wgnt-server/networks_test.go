@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+)
+
+// newNetworksServer serves the rendezvous API with the networks
+// of the file written to a temporary directory.
+func newNetworksServer(t *testing.T, networks string) (*WebSockRouter, *httptest.Server, string) {
+	t.Helper()
+	timeouts.Store(&DefaultConfig().Timeouts)
+	path := filepath.Join(t.TempDir(), "networks.json")
+	if err := os.WriteFile(path, []byte(networks), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := LoadNetworks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wsr := NewWebSockRouter(n, &PolicyStore{}, nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", wsr.requestHandler)
+	mux.HandleFunc("/hub", wsr.hubHandler(&Hub{network: "team-a"}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return wsr, srv, path
+}
+
+func newNetworkClient(t *testing.T, srv *httptest.Server, network, token string) *rendezvous.Client {
+	t.Helper()
+	c, err := rendezvous.NewClient(srv.URL, rendezvous.WithNetwork(network, token), rendezvous.WithBackoff(rendezvous.Backoff{Attempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func checkStatus(t *testing.T, err error, code int) {
+	t.Helper()
+	var se *rendezvous.StatusError
+	if !errors.As(err, &se) || se.Code != code {
+		t.Errorf("got %v, want status %d", err, code)
+	}
+}
+
+const teams = `{"team-a": {"tokens": ["secret-a"]}, "team-b": {"tokens": ["secret-b", "spare-b"]}}`
+
+func TestNetworkTokens(t *testing.T) {
+	_, srv, _ := newNetworksServer(t, teams)
+	pubKey := newKey(t).PublicKey().String()
+
+	tests := []struct {
+		name           string
+		network, token string
+		ok             bool
+	}{
+		{"token", "team-a", "secret-a", true},
+		{"second token", "team-b", "spare-b", true},
+		{"no token", "team-a", "", false},
+		{"wrong token", "team-a", "secret", false},
+		{"token of another network", "team-a", "secret-b", false},
+		{"unknown network", "team-c", "secret-a", false},
+		{"no network", "", "secret-a", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newNetworkClient(t, srv, tt.network, tt.token)
+			err := c.Publish(context.Background(), pubKey, &nat.STUNInfo{PublicIP: "1.2.3.4", PublicPort: 51820})
+			_, lookupErr := c.Lookup(context.Background(), pubKey)
+			if tt.ok {
+				if err != nil || lookupErr != nil {
+					t.Errorf("got %v, %v", err, lookupErr)
+				}
+				return
+			}
+			checkStatus(t, err, http.StatusUnauthorized)
+			checkStatus(t, lookupErr, http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestNetworkIsolation(t *testing.T) {
+	_, srv, _ := newNetworksServer(t, teams)
+	a := newNetworkClient(t, srv, "team-a", "secret-a")
+	b := newNetworkClient(t, srv, "team-b", "secret-b")
+	shared, onlyB := newKey(t).PublicKey().String(), newKey(t).PublicKey().String()
+	ctx := context.Background()
+
+	// the same key registered in both networks
+	infoA := &nat.STUNInfo{PublicIP: "192.0.2.1", PublicPort: 51820}
+	infoB := &nat.STUNInfo{PublicIP: "198.51.100.1", PublicPort: 40000}
+	for _, reg := range []struct {
+		c      *rendezvous.Client
+		pubKey string
+		info   *nat.STUNInfo
+	}{{a, shared, infoA}, {b, shared, infoB}, {b, onlyB, infoB}} {
+		if err := reg.c.Publish(ctx, reg.pubKey, reg.info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		c    *rendezvous.Client
+		want *nat.STUNInfo
+	}{{a, infoA}, {b, infoB}} {
+		info, err := tt.c.Lookup(ctx, shared)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info == nil || *info != *tt.want {
+			t.Errorf("got %+v, want %+v", info, tt.want)
+		}
+	}
+
+	// unknown in team-a, as if not registered yet
+	if info, err := a.Lookup(ctx, onlyB); info != nil || err != nil {
+		t.Errorf("looked up across networks: %+v, %v", info, err)
+	}
+	// the hub belongs to team-a only
+	_, err := b.HubLookup(ctx, shared)
+	checkStatus(t, err, http.StatusNotFound)
+}
+
+func TestNetworksReload(t *testing.T) {
+	wsr, srv, path := newNetworksServer(t, teams)
+	pubKey := newKey(t).PublicKey().String()
+	info := &nat.STUNInfo{PublicIP: "1.2.3.4", PublicPort: 51820}
+	rotated := newNetworkClient(t, srv, "team-a", "rotated-a")
+
+	checkStatus(t, rotated.Publish(context.Background(), pubKey, info), http.StatusUnauthorized)
+
+	if err := os.WriteFile(path, []byte(`{"team-a": {"tokens": ["rotated-a"]}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wsr.reload("", path)
+	if err := rotated.Publish(context.Background(), pubKey, info); err != nil {
+		t.Fatal(err)
+	}
+	old := newNetworkClient(t, srv, "team-a", "secret-a")
+	checkStatus(t, old.Publish(context.Background(), pubKey, info), http.StatusUnauthorized)
+
+	// an invalid file keeps the networks
+	if err := os.WriteFile(path, []byte(`{"team-a": {"tokens": []}}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wsr.reload("", path)
+	if err := rotated.Publish(context.Background(), pubKey, info); err != nil {
+		t.Fatal(err)
+	}
+}
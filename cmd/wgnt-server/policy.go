@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Rule allows peers matching any of Src to discover and request
+// connections to peers matching any of Dst.
+//
+// Selectors are "*", "group:<name>", "tag:<name>" or a public key.
+type Rule struct {
+	Networks []string `json:"networks,omitempty"`
+	Src      []string `json:"src"`
+	Dst      []string `json:"dst"`
+}
+
+// Policy is a list of allow rules. Anything not allowed is denied.
+//
+// Groups map names to lists of public keys. Tags are advertised by peers
+// when they register, but a tag is only honored if the peer matches one of
+// the owners of the tag (selectors as in rules, except "tag:").
+type Policy struct {
+	Groups map[string][]string `json:"groups,omitempty"`
+	Tags   map[string][]string `json:"tags,omitempty"`
+	Rules  []Rule              `json:"rules"`
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *Policy) validateSelector(sel string, allowTags bool) error {
+	if name, ok := strings.CutPrefix(sel, "group:"); ok {
+		if _, ok := p.Groups[name]; !ok {
+			return fmt.Errorf("unknown group %q", name)
+		}
+	}
+	if name, ok := strings.CutPrefix(sel, "tag:"); ok {
+		if !allowTags {
+			return fmt.Errorf("tag owner cannot be a tag: %q", sel)
+		}
+		if _, ok := p.Tags[name]; !ok {
+			return fmt.Errorf("unknown tag %q", name)
+		}
+	}
+	if sel == "" {
+		return fmt.Errorf("empty selector")
+	}
+	return nil
+}
+
+func (p *Policy) validate() error {
+	for tag, owners := range p.Tags {
+		for _, sel := range owners {
+			if err := p.validateSelector(sel, false); err != nil {
+				return fmt.Errorf("tag %q: %w", tag, err)
+			}
+		}
+	}
+	for i, r := range p.Rules {
+		for _, sel := range append(append([]string{}, r.Src...), r.Dst...) {
+			if err := p.validateSelector(sel, true); err != nil {
+				return fmt.Errorf("rule %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Policy) inGroup(group, pubKey string) bool {
+	for _, k := range p.Groups[group] {
+		if k == pubKey {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) ownsTag(tag, pubKey string) bool {
+	for _, sel := range p.Tags[tag] {
+		if sel == "*" || sel == pubKey {
+			return true
+		}
+		if name, ok := strings.CutPrefix(sel, "group:"); ok && p.inGroup(name, pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) matches(sel, pubKey string, tags []string) bool {
+	if sel == "*" || sel == pubKey {
+		return true
+	}
+	if name, ok := strings.CutPrefix(sel, "group:"); ok {
+		return p.inGroup(name, pubKey)
+	}
+	if name, ok := strings.CutPrefix(sel, "tag:"); ok {
+		for _, t := range tags {
+			if t == name && p.ownsTag(t, pubKey) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *Policy) matchesAny(sels []string, pubKey string, tags []string) bool {
+	for _, sel := range sels {
+		if p.matches(sel, pubKey, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) appliesTo(network string) bool {
+	if len(r.Networks) == 0 {
+		return true
+	}
+	for _, n := range r.Networks {
+		if n == network {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows reports whether src may discover and notify dst.
+func (p *Policy) Allows(src PeerID, srcTags []string, dst PeerID, dstTags []string) bool {
+	if p == nil {
+		return true
+	}
+	for _, r := range p.Rules {
+		if !r.appliesTo(src.Network) {
+			continue
+		}
+		if p.matchesAny(r.Src, src.PubKey, srcTags) && p.matchesAny(r.Dst, dst.PubKey, dstTags) {
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyStore holds the current policy and reloads it when the file changes.
+type PolicyStore struct {
+	path    string
+	policy  atomic.Pointer[Policy]
+	modTime time.Time
+}
+
+// NewPolicyStore loads the policy from path.
+// An empty path means no policy, i.e. everything is allowed.
+func NewPolicyStore(path string) (*PolicyStore, error) {
+	ps := &PolicyStore{path: path}
+	if path == "" {
+		return ps, nil
+	}
+	if err := ps.Reload(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *PolicyStore) Get() *Policy {
+	return ps.policy.Load()
+}
+
+func (ps *PolicyStore) Reload() error {
+	st, err := os.Stat(ps.path)
+	if err != nil {
+		return err
+	}
+	p, err := LoadPolicy(ps.path)
+	if err != nil {
+		return err
+	}
+	ps.policy.Store(p)
+	ps.modTime = st.ModTime()
+	return nil
+}
+
+// Watch reloads the policy whenever its file is modified.
+// An invalid policy is reported and the previous one stays in effect.
+func (ps *PolicyStore) Watch(interval time.Duration) {
+	if ps.path == "" {
+		return
+	}
+	for range time.Tick(interval) {
+		st, err := os.Stat(ps.path)
+		if err != nil {
+			log.Printf("policy: %v", err)
+			continue
+		}
+		if st.ModTime().Equal(ps.modTime) {
+			continue
+		}
+		if err := ps.Reload(); err != nil {
+			log.Printf("policy reload failed: %v", err)
+			ps.modTime = st.ModTime()
+			continue
+		}
+		log.Printf("policy reloaded from %s", ps.path)
+	}
+}
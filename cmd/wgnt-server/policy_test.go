@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// TestLookupIdentity checks that the access control policy cannot be bypassed
+// by claiming the public key of an allowed peer.
+func TestLookupIdentity(t *testing.T) {
+	timeouts.Store(&DefaultConfig().Timeouts)
+	keyA, keyB, keyC := newKey(t), newKey(t), newKey(t)
+	pubA, pubB, pubC := keyA.PublicKey().String(), keyB.PublicKey().String(), keyC.PublicKey().String()
+
+	policy := &PolicyStore{}
+	policy.policy.Store(&Policy{Rules: []Rule{{Src: []string{pubA}, Dst: []string{pubB}}}})
+	proofs, err := rendezvous.NewProofVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsr := NewWebSockRouter(nil, policy, proofs)
+	srv := httptest.NewServer(http.HandlerFunc(wsr.requestHandler))
+	defer srv.Close()
+
+	b, err := rendezvous.NewClient(srv.URL, rendezvous.WithIdentity(pubB), rendezvous.WithPrivateKey(keyB))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish(context.Background(), pubB, &nat.STUNInfo{PublicIP: "1.2.3.4", PublicPort: 51820}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts []rendezvous.Option
+		code int
+	}{
+		{"allowed", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyA)}, 0},
+		{"denied", []rendezvous.Option{rendezvous.WithIdentity(pubC), rendezvous.WithPrivateKey(keyC)}, http.StatusForbidden},
+		{"anonymous", nil, http.StatusForbidden},
+		{"claimed identity", []rendezvous.Option{rendezvous.WithIdentity(pubA)}, http.StatusUnauthorized},
+		{"claimed identity with own key", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyC)}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := rendezvous.NewClient(srv.URL, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := c.Lookup(context.Background(), pubB)
+
+			if tt.code != 0 {
+				var se *rendezvous.StatusError
+				if !errors.As(err, &se) || se.Code != tt.code {
+					t.Fatalf("got %v, %v, want status %d", info, err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info == nil || info.PublicIP != "1.2.3.4" {
+				t.Fatalf("got %+v", info)
+			}
+		})
+	}
+}
+
+// TestRegisterIdentity checks that with a policy nobody else can register
+// endpoints or subscribe to notifications in the name of a peer.
+func TestRegisterIdentity(t *testing.T) {
+	timeouts.Store(&DefaultConfig().Timeouts)
+	keyA, keyC := newKey(t), newKey(t)
+	pubA := keyA.PublicKey().String()
+
+	policy := &PolicyStore{}
+	policy.policy.Store(&Policy{Rules: []Rule{{Src: []string{"*"}, Dst: []string{"*"}}}})
+	proofs, err := rendezvous.NewProofVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wsr := NewWebSockRouter(nil, policy, proofs)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", wsr.requestHandler)
+	mux.HandleFunc("/ws", wsr.wsRequestHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name string
+		opts []rendezvous.Option
+		code int
+	}{
+		{"own key", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyA)}, 0},
+		{"no identity", nil, http.StatusUnauthorized},
+		{"claimed identity", []rendezvous.Option{rendezvous.WithIdentity(pubA)}, http.StatusUnauthorized},
+		{"claimed identity with own key", []rendezvous.Option{rendezvous.WithIdentity(pubA), rendezvous.WithPrivateKey(keyC)}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append(tt.opts, rendezvous.WithBackoff(rendezvous.Backoff{Attempts: 1}))
+			c, err := rendezvous.NewClient(srv.URL, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			check := func(op string, err error) {
+				t.Helper()
+				if tt.code == 0 {
+					if err != nil {
+						t.Errorf("%s: %v", op, err)
+					}
+					return
+				}
+				var se *rendezvous.StatusError
+				if !errors.As(err, &se) || se.Code != tt.code {
+					t.Errorf("%s: got %v, want status %d", op, err, tt.code)
+				}
+			}
+
+			err = c.Publish(context.Background(), pubA, &nat.STUNInfo{PublicIP: "1.2.3.4", PublicPort: 51820})
+			check("publish", err)
+
+			sub, err := c.Subscribe(context.Background(), pubA)
+			check("subscribe", err)
+			if sub != nil {
+				sub.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/nohajc/wg-nat-traversal/cmd/wgnt-server")
+
+// startSpan starts a span handling the request about peer id.
+// It continues the trace of the client if the request carries one.
+func startSpan(r *http.Request, name string, id PeerID) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	return tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("wgnt.network", id.Network),
+			attribute.String("wgnt.pubkey", id.PubKey),
+		),
+	)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// span is a received span together with the service which sent it.
+type span struct {
+	*tracepb.Span
+	service string
+}
+
+func (s span) start() time.Time {
+	return time.Unix(0, int64(s.GetStartTimeUnixNano()))
+}
+
+func (s span) duration() time.Duration {
+	return time.Duration(s.GetEndTimeUnixNano() - s.GetStartTimeUnixNano())
+}
+
+// Collector buffers spans by trace and prints each trace
+// once no new spans of it arrived for a while.
+// Spans of one traversal come from both clients and the server.
+type Collector struct {
+	quiet  time.Duration
+	out    io.Writer
+	traces map[string][]span
+	timers map[string]*time.Timer
+	mu     sync.Mutex
+}
+
+func NewCollector(quiet time.Duration, out io.Writer) *Collector {
+	return &Collector{
+		quiet:  quiet,
+		out:    out,
+		traces: map[string][]span{},
+		timers: map[string]*time.Timer{},
+	}
+}
+
+func (c *Collector) Add(req *coltracepb.ExportTraceServiceRequest) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, rs := range req.GetResourceSpans() {
+		service := "unknown"
+		for _, kv := range rs.GetResource().GetAttributes() {
+			if kv.GetKey() == "service.name" {
+				service = kv.GetValue().GetStringValue()
+			}
+		}
+
+		for _, ss := range rs.GetScopeSpans() {
+			for _, s := range ss.GetSpans() {
+				traceID := hex.EncodeToString(s.GetTraceId())
+				c.traces[traceID] = append(c.traces[traceID], span{Span: s, service: service})
+
+				if t, ok := c.timers[traceID]; ok {
+					t.Reset(c.quiet)
+				} else {
+					c.timers[traceID] = time.AfterFunc(c.quiet, func() { c.flush(traceID) })
+				}
+			}
+		}
+	}
+}
+
+func (c *Collector) flush(traceID string) {
+	c.mu.Lock()
+	spans := c.traces[traceID]
+	delete(c.traces, traceID)
+	delete(c.timers, traceID)
+	c.mu.Unlock()
+
+	if len(spans) > 0 {
+		printTrace(c.out, traceID, spans)
+	}
+}
+
+func attrValue(v *commonpb.AnyValue) string {
+	switch v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.GetStringValue()
+	case *commonpb.AnyValue_BoolValue:
+		return fmt.Sprint(v.GetBoolValue())
+	case *commonpb.AnyValue_IntValue:
+		return fmt.Sprint(v.GetIntValue())
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprint(v.GetDoubleValue())
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func attrString(attrs []*commonpb.KeyValue) string {
+	parts := make([]string, 0, len(attrs))
+	for _, kv := range attrs {
+		parts = append(parts, fmt.Sprintf("%s=%s", kv.GetKey(), attrValue(kv.GetValue())))
+	}
+	return strings.Join(parts, " ")
+}
+
+func printTrace(out io.Writer, traceID string, spans []span) {
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].GetStartTimeUnixNano() < spans[j].GetStartTimeUnixNano()
+	})
+
+	ids := map[string]bool{}
+	for _, s := range spans {
+		ids[string(s.GetSpanId())] = true
+	}
+	children := map[string][]span{}
+	var roots []span
+	for _, s := range spans {
+		parent := string(s.GetParentSpanId())
+		if parent == "" || !ids[parent] {
+			roots = append(roots, s)
+		} else {
+			children[parent] = append(children[parent], s)
+		}
+	}
+
+	begin := spans[0].start()
+	var end time.Time
+	services := map[string]bool{}
+	for _, s := range spans {
+		if e := s.start().Add(s.duration()); e.After(end) {
+			end = e
+		}
+		services[s.service] = true
+	}
+
+	fmt.Fprintf(out, "trace %s (%d spans, %d services, %v)\n",
+		traceID, len(spans), len(services), end.Sub(begin).Round(time.Millisecond))
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	var walk func(s span, depth int)
+	walk = func(s span, depth int) {
+		indent := strings.Repeat("  ", depth)
+		status := ""
+		if s.GetStatus().GetCode() == tracepb.Status_STATUS_CODE_ERROR {
+			status = "ERROR " + s.GetStatus().GetMessage()
+		}
+		fmt.Fprintf(tw, "  %s%s\t+%v\t%v\t%s\t%s\t%s\n",
+			indent, s.GetName(),
+			s.start().Sub(begin).Round(time.Millisecond),
+			s.duration().Round(time.Millisecond),
+			s.service, status, attrString(s.GetAttributes()))
+
+		for _, e := range s.GetEvents() {
+			at := time.Unix(0, int64(e.GetTimeUnixNano()))
+			fmt.Fprintf(tw, "  %s  * %s\t+%v\t\t\t\t%s\n",
+				indent, e.GetName(), at.Sub(begin).Round(time.Millisecond),
+				attrString(e.GetAttributes()))
+		}
+		for _, child := range children[string(s.GetSpanId())] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, 0)
+	}
+	tw.Flush()
+	fmt.Fprintln(out)
+}
+
+func (c *Collector) tracesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		st := http.StatusMethodNotAllowed
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "application/x-protobuf" {
+		log.Printf("unsupported content type %q", ct)
+		st := http.StatusUnsupportedMediaType
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("read error: %v", err)
+		st := http.StatusBadRequest
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+
+	req := &coltracepb.ExportTraceServiceRequest{}
+	if err := proto.Unmarshal(body, req); err != nil {
+		log.Printf("protobuf decode error: %v", err)
+		st := http.StatusBadRequest
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+	c.Add(req)
+
+	resp, err := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	if err != nil {
+		log.Printf("protobuf encode error: %v", err)
+		st := http.StatusInternalServerError
+		http.Error(w, http.StatusText(st), st)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+// wgnt-trace-collector is a minimal stand-in for an OpenTelemetry collector.
+// It accepts traces over OTLP/HTTP (protobuf, uncompressed)
+// and prints them as trees, which is enough to see where a slow connect spends its time.
+func main() {
+	var listenAddr string
+	var quiet time.Duration
+
+	flag.StringVar(&listenAddr, "l", "localhost:4318", "listen address")
+	// longer than the default export interval of the SDK (5s)
+	flag.DurationVar(&quiet, "quiet", 10*time.Second, "print a trace after no new spans arrived for this long")
+	flag.Parse()
+
+	c := NewCollector(quiet, os.Stdout)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/traces", c.tracesHandler)
+
+	log.Printf("listening on http://%s/v1/traces", listenAddr)
+	log.Fatal(http.ListenAndServe(listenAddr, mux))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/control"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wgnt <options> COMMAND\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	fmt.Fprintf(os.Stderr, "  status            show peers of the running wgnt-client daemon\n")
+	fmt.Fprintf(os.Stderr, "  reconnect PUBKEY  start a new traversal to the peer\n")
+	fmt.Fprintf(os.Stderr, "  pause             ignore notifications from other peers\n")
+	fmt.Fprintf(os.Stderr, "  resume            handle notifications again\n\n")
+	fmt.Fprintf(os.Stderr, "Options:\n")
+	flag.PrintDefaults()
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	os.Exit(1)
+}
+
+func printJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fail(err)
+	}
+}
+
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+func printStatus(status *control.Status) {
+	state := "running"
+	if status.Paused {
+		state = "paused"
+	}
+	fmt.Printf("interface: %s (%s)\n", status.Interface, state)
+	fmt.Printf("public key: %s\n", status.PubKey)
+	conn := "connected"
+	if !status.Connected {
+		conn = "reconnecting"
+	}
+	fmt.Printf("server: %s (%s)\n\n", status.Server, conn)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PEER\tSTATE\tENDPOINT\tHANDSHAKE\tLOCAL NAT\tREMOTE NAT\tSTRATEGY\tLAST ATTEMPT\tERROR")
+	for _, p := range status.Peers {
+		var localNAT, remoteNAT, strategy, lastErr string
+		if p.Last != nil {
+			strategy = p.Last.Strategy
+			lastErr = p.Last.Error
+			// NAT kinds are only known once the strategy is decided
+			if strategy != "" {
+				localNAT = p.Last.LocalNAT.String()
+				remoteNAT = p.Last.RemoteNAT.String()
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.PubKey, p.State, p.Endpoint, ago(p.LastHandshake),
+			localNAT, remoteNAT, strategy, ago(p.LastAttempt), lastErr)
+	}
+	tw.Flush()
+}
+
+func main() {
+	var iface, socketPath string
+	var jsonOutput bool
+
+	flag.Usage = usage
+	flag.StringVar(&iface, "w", "", "Wireguard interface managed by the daemon")
+	flag.StringVar(&socketPath, "control", "", "control socket of the daemon (default /run/wgnt/<interface>.sock)")
+	flag.BoolVar(&jsonOutput, "json", false, "JSON output")
+	flag.Parse()
+
+	if flag.NArg() < 1 || (iface == "" && socketPath == "") {
+		usage()
+		os.Exit(1)
+	}
+	if socketPath == "" {
+		socketPath = control.DefaultSocketPath(iface)
+	}
+
+	client := control.NewClient(socketPath)
+	ctx := context.Background()
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+
+	var err error
+	switch cmd {
+	case "status":
+		status, err := client.Status(ctx)
+		if err != nil {
+			fail(err)
+		}
+		if jsonOutput {
+			printJSON(status)
+			return
+		}
+		printStatus(status)
+		return
+
+	case "reconnect":
+		if len(args) != 1 {
+			usage()
+			os.Exit(1)
+		}
+		err = client.Reconnect(ctx, args[0])
+	case "pause":
+		err = client.Pause(ctx)
+	case "resume":
+		err = client.Resume(ctx)
+
+	default:
+		usage()
+		os.Exit(1)
+	}
+	if err != nil {
+		fail(err)
+	}
+}
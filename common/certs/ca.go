@@ -0,0 +1,231 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	caCertFile = "ca.pem"
+	caKeyFile  = "ca-key.pem"
+
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 90 * 24 * time.Hour
+	// leaf certificates are reissued when less than this is left
+	renewBefore = 30 * 24 * time.Hour
+)
+
+// CA is a local certificate authority which issues server certificates on demand.
+// Clients trust it either by loading its certificate as a CA bundle or by pinning its key.
+type CA struct {
+	Cert *x509.Certificate
+	key  crypto.Signer
+
+	// Hosts restricts the server names certificates are issued for.
+	// If empty, any SNI is accepted.
+	Hosts []string
+
+	cache   map[string]*tls.Certificate
+	cacheMu sync.Mutex
+}
+
+// CACertPath returns the path of the CA certificate stored in dir,
+// to be distributed to clients as a CA bundle.
+func CACertPath(dir string) string {
+	return filepath.Join(dir, caCertFile)
+}
+
+// LoadOrCreateCA loads the CA stored in dir or creates a new one if there is none.
+func LoadOrCreateCA(dir string) (*CA, error) {
+	certPath := CACertPath(dir)
+	keyPath := filepath.Join(dir, caKeyFile)
+
+	pair, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err == nil {
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := pair.PrivateKey.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("unsupported CA key type")
+		}
+		return newCA(cert, signer), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error loading CA: %w", err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "wgnt local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := writePEM(keyPath, "PRIVATE KEY", keyDER, 0o600); err != nil {
+		return nil, err
+	}
+	if err := writePEM(certPath, "CERTIFICATE", der, 0o644); err != nil {
+		return nil, err
+	}
+	return newCA(cert, key), nil
+}
+
+func newCA(cert *x509.Certificate, key crypto.Signer) *CA {
+	return &CA{
+		Cert:  cert,
+		key:   key,
+		cache: map[string]*tls.Certificate{},
+	}
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	return os.WriteFile(path, data, perm)
+}
+
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Issue creates a leaf certificate for the given DNS names and IP addresses.
+func (ca *CA) Issue(hosts []string) (*tls.Certificate, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("no hosts to issue certificate for")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hosts[0]},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Certificate{
+		Certificate: [][]byte{der, ca.Cert.Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func (ca *CA) allowed(host string) bool {
+	if len(ca.Hosts) == 0 {
+		return true
+	}
+	for _, h := range ca.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCertificate can be used as tls.Config.GetCertificate.
+// It issues (and later renews) a certificate for the requested server name.
+// Clients connecting by IP address send no SNI; they get a certificate
+// for the local address of the connection.
+func (ca *CA) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if host == "" {
+		if addr, ok := hello.Conn.LocalAddr().(*net.TCPAddr); ok {
+			host = addr.IP.String()
+		}
+	}
+	if host == "" {
+		return nil, errors.New("cannot determine server name")
+	}
+	if !ca.allowed(host) && hello.ServerName != "" {
+		return nil, fmt.Errorf("server name %q not allowed", host)
+	}
+
+	ca.cacheMu.Lock()
+	defer ca.cacheMu.Unlock()
+
+	if cert, ok := ca.cache[host]; ok && time.Until(cert.Leaf.NotAfter) > renewBefore {
+		return cert, nil
+	}
+	cert, err := ca.Issue([]string{host})
+	if err != nil {
+		return nil, err
+	}
+	ca.cache[host] = cert
+	return cert, nil
+}
+
+// ServerConfig returns a TLS config serving certificates issued by the CA.
+func (ca *CA) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: ca.GetCertificate,
+	}
+}
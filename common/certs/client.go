@@ -0,0 +1,128 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const pinPrefix = "sha256/"
+
+var errNoPin = errors.New("server certificate does not match any pin")
+
+// Pin returns the pin of a certificate in the form "sha256/<base64>",
+// i.e. the hash of its SubjectPublicKeyInfo.
+func Pin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func parsePin(pin string) ([]byte, error) {
+	if !strings.HasPrefix(pin, pinPrefix) {
+		return nil, fmt.Errorf("invalid pin %q: expected %s<base64>", pin, pinPrefix)
+	}
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, pinPrefix))
+	if err != nil || len(sum) != sha256.Size {
+		return nil, fmt.Errorf("invalid pin %q", pin)
+	}
+	return sum, nil
+}
+
+// ClientConfig returns a TLS config for connecting to a server.
+//
+// If caFile is set, the server certificate must be signed by one of the CAs
+// in the bundle instead of the system roots. If pins are set, a certificate
+// of the verified chain (leaf or CA) must match one of them.
+// When pins are given without a CA bundle, the pinned certificates presented
+// by the server are the only roots, which allows connecting to servers using
+// a self-signed local CA. The host name is verified if it was sent as SNI,
+// i.e. unless the server is addressed by IP.
+func ClientConfig(caFile string, pins []string) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(pins) == 0 {
+		return cfg, nil
+	}
+
+	var sums [][]byte
+	for _, p := range pins {
+		sum, err := parsePin(p)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	pinned := func(cert *x509.Certificate) bool {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, s := range sums {
+			if bytes.Equal(sum[:], s) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if caFile != "" {
+		// the chain is verified by crypto/tls, only pins are checked here
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					if pinned(cert) {
+						return nil
+					}
+				}
+			}
+			return errNoPin
+		}
+		return cfg, nil
+	}
+
+	// There are no roots to verify the chain against before the pins are known,
+	// so it is verified in VerifyConnection with the pinned certificates as roots.
+	// Merely presenting a pinned certificate (e.g. the public CA certificate)
+	// is not enough, the leaf must chain up to it.
+	cfg.InsecureSkipVerify = true
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("no server certificate")
+		}
+		roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates {
+			if pinned(cert) {
+				roots.AddCert(cert)
+			} else {
+				intermediates.AddCert(cert)
+			}
+		}
+		_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: intermediates,
+			DNSName:       cs.ServerName,
+		})
+		if err != nil {
+			return fmt.Errorf("server certificate does not chain up to a pinned certificate: %w", err)
+		}
+		return nil
+	}
+	return cfg, nil
+}
@@ -0,0 +1,111 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// serveTLS accepts connections with the certificate until the test ends
+// and returns the port.
+func serveTLS(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.(*tls.Conn).Handshake()
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+func issue(t *testing.T, ca *CA, hosts ...string) *tls.Certificate {
+	t.Helper()
+	cert, err := ca.Issue(hosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestClientConfig(t *testing.T) {
+	caDir := t.TempDir()
+	ca, err := LoadOrCreateCA(caDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := LoadOrCreateCA(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := issue(t, ca, "localhost")
+	// a certificate of the attacker followed by the genuine (public) CA certificate
+	forged := issue(t, other, "localhost")
+	forged.Certificate[1] = ca.Cert.Raw
+
+	tests := []struct {
+		name   string
+		cert   *tls.Certificate
+		caFile string
+		pins   []string
+		host   string
+		// sent instead of host
+		serverName string
+		ok         bool
+	}{
+		{name: "pinned CA", cert: leaf, pins: []string{Pin(ca.Cert)}, ok: true},
+		{name: "pinned leaf", cert: leaf, pins: []string{Pin(leaf.Leaf)}, ok: true},
+		{name: "one of the pins", cert: leaf, pins: []string{Pin(other.Cert), Pin(ca.Cert)}, ok: true},
+		{name: "pinned CA by IP", cert: issue(t, ca, "127.0.0.1"), pins: []string{Pin(ca.Cert)}, host: "127.0.0.1", ok: true},
+		{name: "other pin", cert: leaf, pins: []string{Pin(other.Cert)}},
+		{name: "appended CA", cert: forged, pins: []string{Pin(ca.Cert)}},
+		{name: "pinned CA wrong host", cert: issue(t, ca, "wgnt.example.com"), pins: []string{Pin(ca.Cert)}},
+		{name: "pinned leaf wrong host", cert: leaf, pins: []string{Pin(leaf.Leaf)}, serverName: "wgnt.example.com"},
+		{name: "CA bundle", cert: leaf, caFile: CACertPath(caDir), ok: true},
+		{name: "CA bundle appended CA", cert: forged, caFile: CACertPath(caDir)},
+		{name: "CA bundle and pin", cert: leaf, caFile: CACertPath(caDir), pins: []string{Pin(leaf.Leaf)}, ok: true},
+		{name: "CA bundle and other pin", cert: leaf, caFile: CACertPath(caDir), pins: []string{Pin(other.Cert)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			port := serveTLS(t, tt.cert)
+			cfg, err := ClientConfig(tt.caFile, tt.pins)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.ServerName = tt.serverName
+			host := tt.host
+			if host == "" {
+				host = "localhost"
+			}
+
+			c, err := tls.Dial("tcp", net.JoinHostPort(host, port), cfg)
+			if err == nil {
+				c.Close()
+			}
+			if (err == nil) != tt.ok {
+				t.Errorf("got %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestClientConfigInvalidPin(t *testing.T) {
+	for _, pin := range []string{"sha1/AAAA", "sha256/not base64", "sha256/AAAA"} {
+		if _, err := ClientConfig("", []string{pin}); err == nil {
+			t.Errorf("%q accepted", pin)
+		}
+	}
+}
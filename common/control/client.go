@@ -0,0 +1,94 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client talks to the daemon over its control socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+func NewClient(socketPath string) *Client {
+	tr := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		},
+	}
+	return &Client{httpClient: &http.Client{Transport: tr}}
+}
+
+// Error is returned when the daemon rejects the request.
+type Error struct {
+	Code    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values) (*http.Response, error) {
+	// the host is ignored, requests always go to the socket
+	u := url.URL{Scheme: "http", Host: "wgnt", Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, &Error{
+			Code:    resp.StatusCode,
+			Message: fmt.Sprintf("%s: %s", path, strings.TrimSpace(string(msg))),
+		}
+	}
+	return resp, nil
+}
+
+func (c *Client) post(ctx context.Context, path string, query url.Values) error {
+	resp, err := c.do(ctx, http.MethodPost, path, query)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	status := &Status{}
+	if err := json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *Client) Reconnect(ctx context.Context, pubKey string) error {
+	return c.post(ctx, "/reconnect", url.Values{"peer": {pubKey}})
+}
+
+func (c *Client) Pause(ctx context.Context) error {
+	return c.post(ctx, "/pause", nil)
+}
+
+func (c *Client) Resume(ctx context.Context) error {
+	return c.post(ctx, "/resume", nil)
+}
@@ -0,0 +1,155 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/rendezvous"
+)
+
+const (
+	StateIdle       = "idle"       // no traversal attempted yet
+	StateConnecting = "connecting" // traversal in progress
+	StateConnected  = "connected"  // last traversal succeeded
+	StateFailed     = "failed"     // last traversal failed
+)
+
+// PeerStatus describes a Wireguard peer of the daemon.
+type PeerStatus struct {
+	PubKey        string    `json:"pubkey"`
+	State         string    `json:"state"`
+	Endpoint      string    `json:"endpoint,omitempty"`
+	LastHandshake time.Time `json:"last_handshake"`
+	LastAttempt   time.Time `json:"last_attempt"`
+
+	// outcome of the last finished traversal
+	Last *rendezvous.TraversalReport `json:"last,omitempty"`
+}
+
+// Status describes the running daemon.
+type Status struct {
+	Interface string       `json:"interface"`
+	PubKey    string       `json:"pubkey"`
+	Server    string       `json:"server"`
+	Connected bool         `json:"connected"` // to the server
+	Paused    bool         `json:"paused"`
+	Peers     []PeerStatus `json:"peers"`
+}
+
+var (
+	ErrUnknownPeer = errors.New("unknown peer")
+	ErrPaused      = errors.New("daemon is paused")
+	ErrBusy        = errors.New("too many pending requests")
+)
+
+// Daemon is controlled through the socket.
+type Daemon interface {
+	Status() Status
+	// Reconnect schedules a new traversal to the peer.
+	Reconnect(pubKey string) error
+	// Pause makes the daemon ignore notifications until Resume is called.
+	Pause()
+	Resume()
+}
+
+// DefaultSocketPath returns the control socket path of the daemon managing iface.
+// Daemons without root privileges (i.e. in userspace mode) use $XDG_RUNTIME_DIR.
+func DefaultSocketPath(iface string) string {
+	dir := "/run/wgnt"
+	if os.Geteuid() != 0 {
+		if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+			dir = filepath.Join(runtimeDir, "wgnt")
+		} else {
+			dir = filepath.Join(os.TempDir(), fmt.Sprintf("wgnt-%d", os.Getuid()))
+		}
+	}
+	return filepath.Join(dir, iface+".sock")
+}
+
+// Listen creates the control socket accessible only to the owner.
+// Missing directories are created private to the owner, an existing
+// directory must not let others replace the socket.
+// A stale socket left behind by a previous daemon is replaced.
+func Listen(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	if err := checkDir(dir); err != nil {
+		return nil, fmt.Errorf("insecure control socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return nil, errors.New("control socket in use: " + path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	return listenPrivate(path)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	st := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnknownPeer):
+		st = http.StatusNotFound
+	case errors.Is(err, ErrPaused):
+		st = http.StatusConflict
+	case errors.Is(err, ErrBusy):
+		st = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), st)
+}
+
+// Handler serves the control API of d.
+func Handler(d Daemon) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			st := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d.Status()); err != nil {
+			log.Printf("json encode error: %v", err)
+		}
+	})
+	mux.HandleFunc("/reconnect", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			st := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		if err := d.Reconnect(r.URL.Query().Get("peer")); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			st := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		d.Pause()
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			st := http.StatusMethodNotAllowed
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		d.Resume()
+	})
+	return mux
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package control
+
+import "net"
+
+func checkDir(dir string) error {
+	return nil
+}
+
+func listenPrivate(path string) (net.Listener, error) {
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,41 @@
+//go:build unix
+
+package control
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wgnt")
+	path := filepath.Join(dir, "wg0.sock")
+
+	l, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range map[string]os.FileMode{dir: 0o700, path: 0o600} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != want {
+			t.Errorf("%s: got mode %v, want %v", p, fi.Mode().Perm(), want)
+		}
+	}
+	if _, err := Listen(path); err == nil {
+		t.Error("socket in use replaced")
+	}
+	l.Close()
+
+	// other users could replace the socket
+	if err := os.Chmod(dir, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := Listen(path); err == nil {
+		l.Close()
+		t.Error("listening in a directory writable by others")
+	}
+}
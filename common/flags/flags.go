@@ -0,0 +1,32 @@
+// Package flags has command line flag types shared by the commands.
+package flags
+
+import (
+	"flag"
+	"strings"
+)
+
+type list struct {
+	list *[]string
+}
+
+// List is a comma-separated list given on the command line,
+// an empty string gives an empty list.
+func List(p *[]string) flag.Value {
+	return list{p}
+}
+
+func (f list) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+func (f list) Set(s string) error {
+	*f.list = nil
+	if s != "" {
+		*f.list = strings.Split(s, ",")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+// Package link creates Wireguard interfaces and configures their addresses and routes.
+package link
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// ErrUnsupported is returned on platforms without rtnetlink.
+var ErrUnsupported = errors.New("creating interfaces is not supported on this platform")
+
+// ErrExists is returned by Create when an interface of the name exists.
+var ErrExists = errors.New("interface already exists")
+
+func parseCIDR(cidr string) (net.IP, *net.IPNet, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+		ipNet.IP = ipNet.IP.To4()
+	}
+	return ip, ipNet, nil
+}
+
+// DefaultRoute reports whether cidr covers all addresses of its family.
+// Such routes would also capture the traffic of the tunnel itself.
+func DefaultRoute(cidr string) bool {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones == 0
+}
+
+func (l *Link) String() string {
+	return fmt.Sprintf("%s (index %d)", l.name, l.index)
+}
@@ -0,0 +1,260 @@
+package link
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Link is a network interface configured by this package.
+// Close undoes the configuration.
+type Link struct {
+	conn    *netlink.Conn
+	name    string
+	index   int
+	created bool
+
+	mu     sync.Mutex
+	addrs  []string
+	routes []string
+}
+
+func dial() (*netlink.Conn, error) {
+	return netlink.Dial(unix.NETLINK_ROUTE, nil)
+}
+
+// Create creates a Wireguard interface. It is deleted by Close.
+func Create(name string) (*Link, error) {
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+
+	ae := netlink.NewAttributeEncoder()
+	ae.String(unix.IFLA_IFNAME, name)
+	ae.Nested(unix.IFLA_LINKINFO, func(nae *netlink.AttributeEncoder) error {
+		nae.String(unix.IFLA_INFO_KIND, "wireguard")
+		return nil
+	})
+	attrs, err := ae.Encode()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	err = execute(conn, unix.RTM_NEWLINK, netlink.Create|netlink.Excl, append(ifInfoMsg(0, 0, 0), attrs...))
+	if err != nil {
+		conn.Close()
+		switch {
+		case errors.Is(err, unix.EEXIST):
+			return nil, fmt.Errorf("%s: %w", name, ErrExists)
+		case errors.Is(err, unix.EOPNOTSUPP):
+			return nil, fmt.Errorf("error creating %s: %w (is the wireguard kernel module available?)", name, err)
+		}
+		return nil, fmt.Errorf("error creating %s: %w", name, err)
+	}
+
+	ifc, err := net.InterfaceByName(name)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Link{conn: conn, name: name, index: ifc.Index, created: true}, nil
+}
+
+// Open manages an existing interface. Close only removes
+// the addresses and routes added through the Link.
+func Open(name string) (*Link, error) {
+	ifc, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	return &Link{conn: conn, name: name, index: ifc.Index}, nil
+}
+
+func execute(conn *netlink.Conn, typ netlink.HeaderType, flags netlink.HeaderFlags, data []byte) error {
+	_, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  typ,
+			Flags: netlink.Request | netlink.Acknowledge | flags,
+		},
+		Data: data,
+	})
+	return err
+}
+
+// struct ifinfomsg
+func ifInfoMsg(index int, flags, change uint32) []byte {
+	b := make([]byte, unix.SizeofIfInfomsg)
+	b[0] = unix.AF_UNSPEC
+	binary.NativeEndian.PutUint32(b[4:8], uint32(index))
+	binary.NativeEndian.PutUint32(b[8:12], flags)
+	binary.NativeEndian.PutUint32(b[12:16], change)
+	return b
+}
+
+func family(ip net.IP) byte {
+	if ip.To4() != nil {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+func (l *Link) Name() string {
+	return l.name
+}
+
+// Created reports whether the interface was created by Create.
+func (l *Link) Created() bool {
+	return l.created
+}
+
+func (l *Link) SetMTU(mtu int) error {
+	ae := netlink.NewAttributeEncoder()
+	ae.Uint32(unix.IFLA_MTU, uint32(mtu))
+	attrs, err := ae.Encode()
+	if err != nil {
+		return err
+	}
+	return execute(l.conn, unix.RTM_NEWLINK, 0, append(ifInfoMsg(l.index, 0, 0), attrs...))
+}
+
+func (l *Link) Up() error {
+	return execute(l.conn, unix.RTM_NEWLINK, 0, ifInfoMsg(l.index, unix.IFF_UP, unix.IFF_UP))
+}
+
+func (l *Link) addrMsg(cidr string) ([]byte, error) {
+	ip, ipNet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipNet.Mask.Size()
+
+	// struct ifaddrmsg
+	b := make([]byte, unix.SizeofIfAddrmsg)
+	b[0] = family(ip)
+	b[1] = byte(ones)
+	b[3] = unix.RT_SCOPE_UNIVERSE
+	binary.NativeEndian.PutUint32(b[4:8], uint32(l.index))
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.IFA_LOCAL, ip)
+	ae.Bytes(unix.IFA_ADDRESS, ip)
+	attrs, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, attrs...), nil
+}
+
+// AddAddress assigns an address with its prefix, e.g. 10.0.0.1/24.
+// An address which is already assigned is left alone.
+func (l *Link) AddAddress(cidr string) error {
+	msg, err := l.addrMsg(cidr)
+	if err != nil {
+		return err
+	}
+	err = execute(l.conn, unix.RTM_NEWADDR, netlink.Create|netlink.Excl, msg)
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error adding address %s to %s: %w", cidr, l.name, err)
+	}
+
+	l.mu.Lock()
+	l.addrs = append(l.addrs, cidr)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *Link) routeMsg(cidr string) ([]byte, error) {
+	_, ipNet, err := parseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipNet.Mask.Size()
+
+	// struct rtmsg
+	b := make([]byte, unix.SizeofRtMsg)
+	b[0] = family(ipNet.IP)
+	b[1] = byte(ones)
+	b[4] = unix.RT_TABLE_MAIN
+	b[5] = unix.RTPROT_BOOT
+	b[6] = unix.RT_SCOPE_LINK
+	b[7] = unix.RTN_UNICAST
+
+	ae := netlink.NewAttributeEncoder()
+	ae.Bytes(unix.RTA_DST, ipNet.IP)
+	ae.Uint32(unix.RTA_OIF, uint32(l.index))
+	attrs, err := ae.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return append(b, attrs...), nil
+}
+
+// AddRoute routes the network (e.g. allowed IPs of a peer) through the interface.
+// A route which already exists is left alone.
+func (l *Link) AddRoute(cidr string) error {
+	msg, err := l.routeMsg(cidr)
+	if err != nil {
+		return err
+	}
+	err = execute(l.conn, unix.RTM_NEWROUTE, netlink.Create|netlink.Excl, msg)
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error adding route %s via %s: %w", cidr, l.name, err)
+	}
+
+	l.mu.Lock()
+	l.routes = append(l.routes, cidr)
+	l.mu.Unlock()
+	return nil
+}
+
+// Close deletes the interface if it was created by Create,
+// otherwise it removes the routes and addresses added through the Link.
+func (l *Link) Close() error {
+	defer l.conn.Close()
+
+	if l.created {
+		return execute(l.conn, unix.RTM_DELLINK, 0, ifInfoMsg(l.index, 0, 0))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []error
+	for _, cidr := range l.routes {
+		msg, err := l.routeMsg(cidr)
+		if err == nil {
+			err = execute(l.conn, unix.RTM_DELROUTE, 0, msg)
+		}
+		if err != nil && !errors.Is(err, unix.ESRCH) {
+			errs = append(errs, fmt.Errorf("error removing route %s: %w", cidr, err))
+		}
+	}
+	for _, cidr := range l.addrs {
+		msg, err := l.addrMsg(cidr)
+		if err == nil {
+			err = execute(l.conn, unix.RTM_DELADDR, 0, msg)
+		}
+		if err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+			errs = append(errs, fmt.Errorf("error removing address %s: %w", cidr, err))
+		}
+	}
+	l.routes, l.addrs = nil, nil
+	return errors.Join(errs...)
+}
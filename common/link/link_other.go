@@ -0,0 +1,44 @@
+//go:build !linux
+
+package link
+
+type Link struct {
+	name  string
+	index int
+}
+
+func Create(name string) (*Link, error) {
+	return nil, ErrUnsupported
+}
+
+func Open(name string) (*Link, error) {
+	return nil, ErrUnsupported
+}
+
+func (l *Link) Name() string {
+	return l.name
+}
+
+func (l *Link) Created() bool {
+	return false
+}
+
+func (l *Link) SetMTU(mtu int) error {
+	return ErrUnsupported
+}
+
+func (l *Link) Up() error {
+	return ErrUnsupported
+}
+
+func (l *Link) AddAddress(cidr string) error {
+	return ErrUnsupported
+}
+
+func (l *Link) AddRoute(cidr string) error {
+	return ErrUnsupported
+}
+
+func (l *Link) Close() error {
+	return nil
+}
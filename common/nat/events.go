@@ -0,0 +1,104 @@
+package nat
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Event is reported to an Observer during hole punching.
+// It is one of ProbeSent, ResponseReceived, PortResolved, Acked, Timeout or ProbeError.
+type Event interface {
+	event()
+}
+
+// ProbeSent is reported after a batch of probes is sent from Local to Remote.
+type ProbeSent struct {
+	Local   string
+	Remote  string
+	Count   int
+	Payload string
+}
+
+// ResponseReceived is reported for every packet received from the peer.
+type ResponseReceived struct {
+	Local   string
+	Remote  string
+	Payload string
+}
+
+// PortResolved is reported once the first response arrives,
+// i.e. when the port mapping on both sides is known.
+type PortResolved struct {
+	Local  string
+	Remote string
+	PortInfo
+}
+
+// Acked is reported when the peer confirms it has resolved the mapping too.
+type Acked struct {
+	Local  string
+	Remote string
+}
+
+// Timeout is reported when hole punching gives up.
+type Timeout struct {
+	Elapsed time.Duration
+}
+
+// ProbeError is reported for errors which do not abort hole punching.
+type ProbeError struct {
+	Local string
+	Err   error
+}
+
+func (ProbeSent) event()        {}
+func (ResponseReceived) event() {}
+func (PortResolved) event()     {}
+func (Acked) event()            {}
+func (Timeout) event()          {}
+func (ProbeError) event()       {}
+
+// Observer receives hole punching events.
+// It may be called concurrently from multiple goroutines.
+type Observer interface {
+	OnEvent(Event)
+}
+
+type ObserverFunc func(Event)
+
+func (f ObserverFunc) OnEvent(e Event) {
+	f(e)
+}
+
+// SlogObserver logs events to logger. Probes are logged at debug level.
+func SlogObserver(logger *slog.Logger) Observer {
+	return ObserverFunc(func(e Event) {
+		switch e := e.(type) {
+		case ProbeSent:
+			logger.Debug("probe sent", "local", e.Local, "remote", e.Remote,
+				"count", e.Count, "payload", e.Payload)
+		case ResponseReceived:
+			logger.Info("response received", "local", e.Local, "remote", e.Remote,
+				"payload", e.Payload)
+		case PortResolved:
+			logger.Info("port resolved", "local", e.Local, "remote", e.Remote)
+		case Acked:
+			logger.Info("peer acknowledged", "local", e.Local, "remote", e.Remote)
+		case Timeout:
+			logger.Warn("hole punching timed out", "elapsed", e.Elapsed)
+		case ProbeError:
+			logger.Error("probe error", "local", e.Local, "error", e.Err)
+		}
+	})
+}
+
+// Observers returns an Observer passing events to all non-nil observers.
+func Observers(observers ...Observer) Observer {
+	return ObserverFunc(func(e Event) {
+		for _, o := range observers {
+			if o != nil {
+				o.OnEvent(e)
+			}
+		}
+	})
+}
@@ -0,0 +1,260 @@
+package nat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// The mapping timeout probe asks a server to send a packet back after a delay.
+// The packet only arrives if the NAT kept the mapping open for that long
+// without any outgoing traffic.
+//
+// Packet: magic (4 bytes), type (1), nonce (8), delay in milliseconds (4).
+
+// DefaultMappingProbePort is the UDP port of the probe service.
+const DefaultMappingProbePort = 3479
+
+// DefaultMappingDelays are the idle intervals tried by MeasureMappingTimeout.
+var DefaultMappingDelays = []time.Duration{
+	10 * time.Second,
+	15 * time.Second,
+	20 * time.Second,
+	30 * time.Second,
+	45 * time.Second,
+	60 * time.Second,
+	90 * time.Second,
+	120 * time.Second,
+}
+
+// ErrMappingTooShort means the NAT dropped the mapping before the shortest delay.
+var ErrMappingTooShort = errors.New("NAT mapping expired before the shortest delay")
+
+var probeMagic = []byte("WGMT")
+
+const (
+	probeRequest byte = iota + 1
+	probeAck
+	probeReply
+)
+
+const probeLen = 17
+
+type probePacket struct {
+	kind  byte
+	nonce uint64
+	delay time.Duration
+}
+
+func (p probePacket) marshal() []byte {
+	b := make([]byte, probeLen)
+	copy(b, probeMagic)
+	b[4] = p.kind
+	binary.BigEndian.PutUint64(b[5:13], p.nonce)
+	binary.BigEndian.PutUint32(b[13:17], uint32(p.delay.Milliseconds()))
+	return b
+}
+
+func parseProbe(b []byte) (probePacket, bool) {
+	if len(b) != probeLen || !bytes.Equal(b[:4], probeMagic) {
+		return probePacket{}, false
+	}
+	return probePacket{
+		kind:  b[4],
+		nonce: binary.BigEndian.Uint64(b[5:13]),
+		delay: time.Duration(binary.BigEndian.Uint32(b[13:17])) * time.Millisecond,
+	}, true
+}
+
+// delayed replies are repeated in case some get lost
+const (
+	replyCopies   = 3
+	replyInterval = 100 * time.Millisecond
+)
+
+// limits of pending replies protecting against spoofed requests
+const (
+	maxPendingPerIP = 32
+	maxPending      = 4096
+)
+
+// ServeMappingProbe answers mapping timeout probes on conn
+// until reading from it fails. Requested delays are capped at maxDelay.
+func ServeMappingProbe(conn net.PacketConn, maxDelay time.Duration) error {
+	type key struct {
+		addr  string
+		nonce uint64
+	}
+	var mu sync.Mutex
+	pending := map[key]bool{}
+	perIP := map[string]int{}
+
+	buf := make([]byte, 64)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		req, ok := parseProbe(buf[:n])
+		if !ok || req.kind != probeRequest {
+			continue
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+		ip := udpAddr.IP.String()
+		if req.delay > maxDelay {
+			req.delay = maxDelay
+		}
+
+		// nothing is sent beyond the limits, not even the ack,
+		// so that spoofed requests cannot turn the probe into a reflector
+		k := key{addr.String(), req.nonce}
+		mu.Lock()
+		retransmitted := pending[k]
+		if !retransmitted && (perIP[ip] >= maxPendingPerIP || len(pending) >= maxPending) {
+			mu.Unlock()
+			continue
+		}
+		if !retransmitted {
+			pending[k] = true
+			perIP[ip]++
+		}
+		mu.Unlock()
+
+		ack := probePacket{kind: probeAck, nonce: req.nonce, delay: req.delay}
+		conn.WriteTo(ack.marshal(), addr)
+		// requests are retransmitted until acked, the reply is already scheduled
+		if retransmitted {
+			continue
+		}
+
+		reply := probePacket{kind: probeReply, nonce: req.nonce, delay: req.delay}.marshal()
+		time.AfterFunc(req.delay, func() {
+			for i := 0; i < replyCopies; i++ {
+				conn.WriteTo(reply, addr)
+				time.Sleep(replyInterval)
+			}
+
+			mu.Lock()
+			delete(pending, k)
+			if perIP[ip]--; perIP[ip] == 0 {
+				delete(perIP, ip)
+			}
+			mu.Unlock()
+		})
+	}
+}
+
+const (
+	ackTimeout  = 3 * time.Second
+	ackAttempts = 3
+)
+
+// probeMapping opens a new mapping towards the server and reports
+// whether it survived the delay without outgoing traffic.
+func probeMapping(server *net.UDPAddr, delay time.Duration) (bool, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var nb [8]byte
+	if _, err := rand.Read(nb[:]); err != nil {
+		return false, err
+	}
+	nonce := binary.BigEndian.Uint64(nb[:])
+	req := probePacket{kind: probeRequest, nonce: nonce, delay: delay}.marshal()
+
+	buf := make([]byte, 64)
+	receive := func(kind byte, deadline time.Time) (bool, error) {
+		conn.SetReadDeadline(deadline)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					return false, nil
+				}
+				return false, err
+			}
+			p, ok := parseProbe(buf[:n])
+			if ok && p.kind == kind && p.nonce == nonce {
+				return true, nil
+			}
+		}
+	}
+
+	acked := false
+	for i := 0; i < ackAttempts && !acked; i++ {
+		if _, err := conn.WriteTo(req, server); err != nil {
+			return false, err
+		}
+		if acked, err = receive(probeAck, time.Now().Add(ackTimeout)); err != nil {
+			return false, err
+		}
+	}
+	if !acked {
+		return false, fmt.Errorf("no response from mapping probe server %s", server)
+	}
+
+	// the last outgoing packet was the request
+	return receive(probeReply, time.Now().Add(delay+replyCopies*replyInterval+ackTimeout))
+}
+
+// MeasureMappingTimeout measures how long the NAT keeps an idle UDP mapping,
+// with the help of the probe service at server (host:port).
+// All delays are probed in parallel, each on its own socket, so it takes
+// about as long as the longest one (DefaultMappingDelays if none are given).
+// The result is the longest delay the mapping survived such that
+// all shorter ones were survived too, i.e. a lower bound of the timeout.
+func MeasureMappingTimeout(server string, delays ...time.Duration) (time.Duration, error) {
+	if len(delays) == 0 {
+		delays = DefaultMappingDelays
+	}
+	delays = slices.Clone(delays)
+	slices.Sort(delays)
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return 0, err
+	}
+
+	type result struct {
+		alive bool
+		err   error
+	}
+	results := make([]result, len(delays))
+	var wg sync.WaitGroup
+	for i, d := range delays {
+		wg.Add(1)
+		go func(i int, d time.Duration) {
+			defer wg.Done()
+			alive, err := probeMapping(serverAddr, d)
+			results[i] = result{alive, err}
+		}(i, d)
+	}
+	wg.Wait()
+
+	var timeout time.Duration
+	for i, r := range results {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if !r.alive {
+			break
+		}
+		timeout = delays[i]
+	}
+	if timeout == 0 {
+		return 0, ErrMappingTooShort
+	}
+	return timeout, nil
+}
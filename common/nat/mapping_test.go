@@ -0,0 +1,53 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// TestServeMappingProbeLimits checks that requests beyond the limit of pending
+// replies get no answer at all, not even an ack.
+func TestServeMappingProbeLimits(t *testing.T) {
+	srv, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	go ServeMappingProbe(srv, time.Minute)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const requests = maxPendingPerIP + 8
+	for i := 0; i < requests; i++ {
+		req := probePacket{kind: probeRequest, nonce: uint64(i), delay: time.Minute}.marshal()
+		if _, err := conn.WriteTo(req, srv.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a retransmitted request is acked again
+	req := probePacket{kind: probeRequest, nonce: 0, delay: time.Minute}.marshal()
+	if _, err := conn.WriteTo(req, srv.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+
+	acks := 0
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			break
+		}
+		if p, ok := parseProbe(buf[:n]); ok && p.kind == probeAck {
+			acks++
+		}
+	}
+	if acks != maxPendingPerIP+1 {
+		t.Errorf("got %d acks, want %d", acks, maxPendingPerIP+1)
+	}
+}
@@ -3,10 +3,10 @@ package nat
 import (
 	"errors"
 	"fmt"
-	"log"
 	"math/rand"
 	"net"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -55,7 +55,7 @@ func (n *NAT) UnmarshalText(b []byte) error {
 }
 
 type ReusedConn struct {
-	*net.UDPConn
+	transport.UDPConn
 	remoteAddr net.Addr
 }
 
@@ -69,8 +69,8 @@ func (rc *ReusedConn) Close() error {
 }
 
 type CustomNet struct {
-	*stdnet.Net
-	conn *net.UDPConn
+	transport.Net
+	conn transport.UDPConn
 }
 
 func (cn *CustomNet) Dial(network string, address string) (net.Conn, error) {
@@ -86,7 +86,7 @@ func (cn *CustomNet) Dial(network string, address string) (net.Conn, error) {
 	}, nil
 }
 
-func GetPublicAddr(conn *net.UDPConn) (string, int, error) {
+func GetPublicAddr(conn transport.UDPConn) (string, int, error) {
 	return STUN_Google.getPublicAddr(conn)
 }
 
@@ -98,33 +98,55 @@ type STUNInfo struct {
 
 type Message struct {
 	Test string `json:"test"`
+	// public key of the peer asking for connection
+	From string `json:"from,omitempty"`
+	// trace context of the request which caused the message
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
-func GetPublicAddrWithNATKind(conn *net.UDPConn) (*STUNInfo, error) {
-	_, port1, err := STUN_Google.getPublicAddr(conn)
-	if err != nil {
-		return nil, err
+// DefaultSTUNServers are used by GetPublicAddrWithNATKind if no servers are given.
+var DefaultSTUNServers = []STUNSrv{STUN_Google, STUN_VoipGATE}
+
+// ParseSTUNServer validates the STUN server address,
+// either a URI (stun:host:port) or just host:port.
+func ParseSTUNServer(s string) (STUNSrv, error) {
+	if !strings.HasPrefix(s, "stun:") {
+		s = "stun:" + s
 	}
+	if _, err := stun.ParseURI(s); err != nil {
+		return "", fmt.Errorf("invalid STUN server %q: %w", s, err)
+	}
+	return STUNSrv(s), nil
+}
 
-	ip, port2, err := STUN_VoipGATE.getPublicAddr(conn)
-	if err != nil {
-		return nil, err
+// GetPublicAddrWithNATKind asks the STUN servers (at least two) for the public address of conn.
+// The NAT is hard if the servers see different ports.
+func GetPublicAddrWithNATKind(conn transport.UDPConn, servers ...STUNSrv) (*STUNInfo, error) {
+	if len(servers) == 0 {
+		servers = DefaultSTUNServers
+	}
+	if len(servers) < 2 {
+		return nil, errors.New("at least two STUN servers are needed to detect NAT kind")
 	}
 
-	natKind := NAT_EASY
+	info := &STUNInfo{NATKind: NAT_EASY}
+	for i, srv := range servers {
+		ip, port, err := srv.getPublicAddr(conn)
+		if err != nil {
+			return nil, err
+		}
 
-	if port1 != port2 {
-		natKind = NAT_HARD
+		if i == 0 {
+			info.PublicPort = port
+		} else if port != info.PublicPort {
+			info.NATKind = NAT_HARD
+		}
+		info.PublicIP = ip
 	}
-
-	return &STUNInfo{
-		PublicIP:   ip,
-		PublicPort: port1,
-		NATKind:    natKind,
-	}, nil
+	return info, nil
 }
 
-func (s STUNSrv) getPublicAddr(conn *net.UDPConn) (string, int, error) {
+func (s STUNSrv) getPublicAddr(conn transport.UDPConn) (string, int, error) {
 	u, err := stun.ParseURI(string(s))
 	if err != nil {
 		return "", 0, err
@@ -184,31 +206,109 @@ func (s STUNSrv) getPublicAddr(conn *net.UDPConn) (string, int, error) {
 	return IP, port, nil
 }
 
-var gotFirstResponse atomic.Bool
+// ErrTimeout is returned when hole punching does not succeed within the time set by WithTimeout.
+var ErrTimeout = errors.New("hole punching timed out")
+
+// Stats describes a hole punching attempt.
+type Stats struct {
+	ProbesSent int
+	// time from the first probe to the first response, 0 if there was no response
+	FirstResponse time.Duration
+}
+
+// session is the state shared by all sockets taking part in one hole punching attempt.
+type session struct {
+	observer Observer
+	start    time.Time
+	deadline time.Time
+
+	gotFirstResponse atomic.Bool
+	probes           atomic.Int64
+	first            atomic.Int64
+}
+
+func newSession(cc *clientCfg) *session {
+	s := &session{
+		observer: cc.observer,
+		start:    time.Now(),
+	}
+	if cc.timeout > 0 {
+		s.deadline = s.start.Add(cc.timeout)
+	}
+	return s
+}
+
+func (s *session) emit(e Event) {
+	if s.observer != nil {
+		s.observer.OnEvent(e)
+	}
+}
+
+func (s *session) probeSent(local, remote net.Addr, count int, payload string) {
+	s.probes.Add(int64(count))
+	s.emit(ProbeSent{Local: local.String(), Remote: remote.String(), Count: count, Payload: payload})
+}
+
+func (s *session) expired() bool {
+	if s.deadline.IsZero() || time.Now().Before(s.deadline) {
+		return false
+	}
+	s.emit(Timeout{Elapsed: time.Since(s.start)})
+	return true
+}
+
+func (s *session) save(stats *Stats) {
+	if stats == nil {
+		return
+	}
+	stats.ProbesSent = int(s.probes.Load())
+	stats.FirstResponse = time.Duration(s.first.Load())
+}
+
+// isClosed also recognizes closed emulated connections (pion vnet),
+// which do not return net.ErrClosed.
+func isClosed(err error) bool {
+	return errors.Is(err, net.ErrClosed) || strings.Contains(err.Error(), net.ErrClosed.Error())
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &ne) && ne.Timeout()
+}
 
 type PortInfo struct {
 	PeerPort  int
 	LocalPort int
 }
 
-func waitForResponse(conn *net.UDPConn, resolved chan PortInfo, acked chan bool) {
+func (s *session) waitForResponse(conn transport.UDPConn, resolved chan PortInfo, acked chan bool) {
 	go func() {
 		for {
 			buf := make([]byte, 1024)
 			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-			n, peerAddr, err := conn.ReadFromUDP(buf)
+			n, addr, err := conn.ReadFrom(buf)
 			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
+				if isClosed(err) {
 					break
 				}
-				if !errors.Is(err, os.ErrDeadlineExceeded) {
-					fmt.Fprintf(os.Stderr, "error: %s\n", err)
+				if !isTimeout(err) {
+					s.emit(ProbeError{Local: conn.LocalAddr().String(), Err: err})
 				}
 				continue
 			}
+			peerAddr, ok := addr.(*net.UDPAddr)
+			if !ok {
+				continue
+			}
 
-			log.Printf("%s sent a response: %s\n", peerAddr.String(), buf[0:n])
-			if gotFirstResponse.CompareAndSwap(false, true) {
+			payload := string(buf[0:n])
+			s.emit(ResponseReceived{
+				Local:   conn.LocalAddr().String(),
+				Remote:  peerAddr.String(),
+				Payload: payload,
+			})
+			if s.gotFirstResponse.CompareAndSwap(false, true) {
+				s.first.Store(int64(time.Since(s.start)))
 				localPort := conn.LocalAddr().(*net.UDPAddr).Port
 
 				resolved <- PortInfo{
@@ -216,8 +316,11 @@ func waitForResponse(conn *net.UDPConn, resolved chan PortInfo, acked chan bool)
 					LocalPort: localPort,
 				}
 			}
-			if string(buf[0:n]) == "RESOLVED" {
-				acked <- true
+			if payload == "RESOLVED" {
+				select {
+				case acked <- true:
+				default:
+				}
 			}
 			// break
 		}
@@ -225,20 +328,41 @@ func waitForResponse(conn *net.UDPConn, resolved chan PortInfo, acked chan bool)
 }
 
 type clientCfg struct {
-	conn        *net.UDPConn
+	conn        transport.UDPConn
+	net         transport.Net
 	pubIP       string
 	pubPort     int
 	interactive bool
+	stats       *Stats
+	observer    Observer
+	timeout     time.Duration
+	probes      int
+	sockets     int
 }
 
 type Option func(*clientCfg)
 
-func WithConn(conn *net.UDPConn) Option {
+func WithConn(conn transport.UDPConn) Option {
 	return func(cc *clientCfg) {
 		cc.conn = conn
 	}
 }
 
+// WithNet makes the hole punching functions open their sockets in the network,
+// e.g. an emulated one (the host network by default).
+func WithNet(n transport.Net) Option {
+	return func(cc *clientCfg) {
+		cc.net = n
+	}
+}
+
+func (cc *clientCfg) listenUDP(laddr *net.UDPAddr) (transport.UDPConn, error) {
+	if cc.net != nil {
+		return cc.net.ListenUDP("udp", laddr)
+	}
+	return net.ListenUDP("udp", laddr)
+}
+
 func WithPubAddr(pubIP string, pubPort int) Option {
 	return func(cc *clientCfg) {
 		cc.pubIP = pubIP
@@ -252,8 +376,45 @@ func Interactive(i bool) Option {
 	}
 }
 
+// WithStats makes the hole punching functions fill in stats of the attempt.
+func WithStats(stats *Stats) Option {
+	return func(cc *clientCfg) {
+		cc.stats = stats
+	}
+}
+
+// WithObserver sets the observer of hole punching events.
+// Without an observer, the functions do not log anything.
+func WithObserver(o Observer) Option {
+	return func(cc *clientCfg) {
+		cc.observer = o
+	}
+}
+
+// WithTimeout limits the time spent hole punching (unlimited by default).
+func WithTimeout(d time.Duration) Option {
+	return func(cc *clientCfg) {
+		cc.timeout = d
+	}
+}
+
+// WithProbes sets the number of probes sent to each port in one round
+// (10 by GuessRemotePort, 5 by GuessLocalPort).
+func WithProbes(n int) Option {
+	return func(cc *clientCfg) {
+		cc.probes = n
+	}
+}
+
+// WithSockets sets the number of local sockets opened by GuessLocalPort (384 by default).
+func WithSockets(n int) Option {
+	return func(cc *clientCfg) {
+		cc.sockets = n
+	}
+}
+
 func GuessRemotePort(remoteIP string, opts ...Option) (int, error) {
-	var cc clientCfg
+	cc := clientCfg{probes: 10}
 	for _, opt := range opts {
 		opt(&cc)
 	}
@@ -265,7 +426,7 @@ func GuessRemotePort(remoteIP string, opts ...Option) (int, error) {
 			return 0, err
 		}
 
-		conn, err = net.ListenUDP("udp", localAddr)
+		conn, err = cc.listenUDP(localAddr)
 		if err != nil {
 			return 0, err
 		}
@@ -291,7 +452,9 @@ func GuessRemotePort(remoteIP string, opts ...Option) (int, error) {
 
 	resolved := make(chan PortInfo, 1)
 	acked := make(chan bool, 1)
-	waitForResponse(conn, resolved, acked)
+	s := newSession(&cc)
+	defer s.save(cc.stats)
+	s.waitForResponse(conn, resolved, acked)
 
 	var portInfo PortInfo
 	sleepDuration := 5 * time.Millisecond
@@ -302,9 +465,11 @@ func GuessRemotePort(remoteIP string, opts ...Option) (int, error) {
 	wasAcked := false
 
 	for cnt > 0 {
-		if !gotFirstResponse.Load() {
+		if !s.gotFirstResponse.Load() {
+			if s.expired() {
+				return 0, ErrTimeout
+			}
 			remoteAddr = fmt.Sprintf("%s:%d", remoteIP, 1024+rand.Intn(65536-1024))
-			fmt.Printf("trying %s ...\n", remoteAddr)
 		} else if wasAcked {
 			cnt--
 		}
@@ -314,12 +479,13 @@ func GuessRemotePort(remoteIP string, opts ...Option) (int, error) {
 			return 0, err
 		}
 
-		for i := 0; i < 10; i++ {
+		for i := 0; i < cc.probes; i++ {
 			_, err = conn.WriteTo([]byte(message), dst)
 			if err != nil {
 				return 0, err
 			}
 		}
+		s.probeSent(conn.LocalAddr(), dst, cc.probes, message)
 
 		select {
 		case portInfo = <-resolved:
@@ -327,55 +493,64 @@ func GuessRemotePort(remoteIP string, opts ...Option) (int, error) {
 			sleepDuration = 50 * time.Millisecond
 			message = "RESOLVED"
 
-			fmt.Printf("Remote addr: %s\n", remoteAddr)
+			s.emit(PortResolved{
+				Local:    conn.LocalAddr().String(),
+				Remote:   remoteAddr,
+				PortInfo: portInfo,
+			})
 		default:
 		}
 
 		// make sure resolved was received
 		// before we try to receive acked
-		if message == "RESOLVED" {
+		if message == "RESOLVED" && !wasAcked {
 			select {
 			case <-acked:
 				wasAcked = true
+				s.emit(Acked{Local: conn.LocalAddr().String(), Remote: remoteAddr})
 			default:
+				if s.expired() {
+					return 0, ErrTimeout
+				}
 			}
 		}
 
 		time.Sleep(sleepDuration)
 	}
 
-	fmt.Printf("Remote addr: %s\n", remoteAddr)
 	return portInfo.PeerPort, nil
 }
 
-func GuessLocalPort(remoteAddr string) (int, error) {
+func GuessLocalPort(remoteAddr string, opts ...Option) (int, error) {
+	cc := clientCfg{probes: 5, sockets: 384}
+	for _, opt := range opts {
+		opt(&cc)
+	}
+
 	dst, err := net.ResolveUDPAddr("udp", remoteAddr)
 	if err != nil {
 		return 0, err
 	}
 
-	const portCount = 384
-	var conns [portCount]*net.UDPConn
+	portCount := cc.sockets
+	conns := make([]transport.UDPConn, portCount)
 
 	for i := 0; i < portCount; {
 		localAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf(":%d", 1024+rand.Intn(65536-1024)))
 		if err != nil {
 			return 0, err
 		}
-		conns[i], err = net.ListenUDP("udp", localAddr)
+		conns[i], err = cc.listenUDP(localAddr)
 		if err != nil {
 			continue
 		}
 		i++
 	}
 
-	// pubIP, _, err := GetPublicAddr(nil)
-	// if err != nil {
-	// 	return err
-	// }
-
 	allDone := make(chan bool, 1)
 	acked := make(chan bool, 1)
+	s := newSession(&cc)
+	defer s.save(cc.stats)
 
 	var portInfo PortInfo
 
@@ -384,22 +559,22 @@ func GuessLocalPort(remoteAddr string) (int, error) {
 
 		go func() {
 			conn := conns[idx]
-			fmt.Printf("trying %s ...\n", conn.LocalAddr().String())
 
 			resolved := make(chan PortInfo, 1)
-			waitForResponse(conn, resolved, acked)
+			s.waitForResponse(conn, resolved, acked)
 
 		loop:
 			for {
-				for i := 0; i < 5; i++ {
-					_, err = conn.WriteTo([]byte("UNKNOWN"), dst)
+				for i := 0; i < cc.probes; i++ {
+					_, err := conn.WriteTo([]byte("UNKNOWN"), dst)
 					if err != nil {
-						if !errors.Is(err, net.ErrClosed) {
-							fmt.Fprintf(os.Stderr, "error: %s\n", err)
+						if !isClosed(err) {
+							s.emit(ProbeError{Local: conn.LocalAddr().String(), Err: err})
 						}
 						return
 					}
 				}
+				s.probeSent(conn.LocalAddr(), dst, cc.probes, "UNKNOWN")
 
 				select {
 				case portInfo = <-resolved:
@@ -413,21 +588,48 @@ func GuessLocalPort(remoteAddr string) (int, error) {
 
 		}()
 	}
-	<-allDone
 
-	var conn *net.UDPConn
+	closeAll := func(except transport.UDPConn) {
+		for _, c := range conns {
+			if c != except {
+				c.Close()
+			}
+		}
+	}
+
+	var timeout <-chan time.Time
+	if !s.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(s.deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-allDone:
+	case <-timeout:
+		s.expired()
+		closeAll(nil)
+		return 0, ErrTimeout
+	}
+
+	var conn transport.UDPConn
 	for _, c := range conns {
 		if c.LocalAddr().(*net.UDPAddr).Port == portInfo.LocalPort {
 			conn = c
-			continue
+			break
 		}
-		c.Close()
 	}
+	closeAll(conn)
 	if conn == nil {
-		log.Fatal("Conn is nil")
+		return 0, errors.New("resolved connection not found")
 	}
+	defer conn.Close()
 
-	fmt.Printf("Local addr: :%d\n", portInfo.LocalPort)
+	s.emit(PortResolved{
+		Local:    conn.LocalAddr().String(),
+		Remote:   dst.String(),
+		PortInfo: portInfo,
+	})
 
 loop:
 	for {
@@ -437,21 +639,29 @@ loop:
 				return 0, err
 			}
 		}
+		s.probeSent(conn.LocalAddr(), dst, 5, "RESOLVED")
 
 		select {
 		case <-acked:
+			s.emit(Acked{Local: conn.LocalAddr().String(), Remote: dst.String()})
 			break loop
 		default:
+			if s.expired() {
+				return 0, ErrTimeout
+			}
 		}
 		time.Sleep(50 * time.Millisecond)
 	}
 
-	fmt.Printf("Local addr: :%d\n", portInfo.LocalPort)
-	conn.Close()
 	return portInfo.LocalPort, nil
 }
 
-func SimpleTest(remoteIP string) error {
+func SimpleTest(remoteIP string, opts ...Option) error {
+	var cc clientCfg
+	for _, opt := range opts {
+		opt(&cc)
+	}
+
 	localAddr, err := net.ResolveUDPAddr("udp", ":0")
 	if err != nil {
 		return err
@@ -475,7 +685,7 @@ func SimpleTest(remoteIP string) error {
 
 	done := make(chan PortInfo, 1)
 	acked := make(chan bool, 1)
-	waitForResponse(conn, done, acked)
+	newSession(&cc).waitForResponse(conn, done, acked)
 
 	remoteAddr := fmt.Sprintf("%s:%d", remoteIP, remotePort)
 	fmt.Printf("trying %s ...\n", remoteAddr)
@@ -0,0 +1,41 @@
+package nat
+
+import (
+	"net"
+
+	"github.com/pion/stun"
+)
+
+// ServeSTUN answers STUN binding requests on conn with the address
+// they came from until reading from it fails. It is enough for NAT
+// detection by GetPublicAddrWithNATKind when running two of them
+// (on different addresses) instead of relying on public servers.
+func ServeSTUN(conn net.PacketConn) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok || !stun.IsMessage(buf[:n]) {
+			continue
+		}
+
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := req.Decode(); err != nil || req.Type != stun.BindingRequest {
+			continue
+		}
+
+		res, err := stun.Build(
+			stun.NewTransactionIDSetter(req.TransactionID),
+			stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+			stun.Fingerprint,
+		)
+		if err != nil {
+			continue
+		}
+		conn.WriteTo(res.Raw, addr)
+	}
+}
@@ -0,0 +1,41 @@
+package netmon
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrUnsupported is returned by New on platforms without netlink.
+var ErrUnsupported = errors.New("network monitoring is not supported on this platform")
+
+// Change summarizes network changes within one debounce interval.
+// Counts are numbers of netlink messages (new or deleted objects).
+type Change struct {
+	Links  int
+	Addrs  int
+	Routes int
+}
+
+type config struct {
+	debounce time.Duration
+	ignore   []string
+}
+
+type Option func(*config)
+
+// WithDebounce sets how long to wait for more events
+// before reporting a change (2s by default).
+// Switching networks produces a burst of events.
+func WithDebounce(d time.Duration) Option {
+	return func(c *config) {
+		c.debounce = d
+	}
+}
+
+// IgnoreInterfaces ignores events of the given interfaces,
+// e.g. of the Wireguard interface which is being reconfigured.
+func IgnoreInterfaces(names ...string) Option {
+	return func(c *config) {
+		c.ignore = append(c.ignore, names...)
+	}
+}
@@ -0,0 +1,160 @@
+package netmon
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Monitor reports changes of links, addresses and routes.
+type Monitor struct {
+	conn    *netlink.Conn
+	cfg     config
+	ignored map[uint32]bool
+	changes chan Change
+	once    sync.Once
+}
+
+// New subscribes to rtnetlink multicast groups of links, addresses and routes.
+func New(opts ...Option) (*Monitor, error) {
+	cfg := config{debounce: 2 * time.Second}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	conn, err := netlink.Dial(unix.NETLINK_ROUTE, &netlink.Config{
+		Groups: unix.RTMGRP_LINK |
+			unix.RTMGRP_IPV4_IFADDR | unix.RTMGRP_IPV6_IFADDR |
+			unix.RTMGRP_IPV4_ROUTE | unix.RTMGRP_IPV6_ROUTE,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Monitor{
+		conn:    conn,
+		cfg:     cfg,
+		ignored: map[uint32]bool{},
+		changes: make(chan Change, 1),
+	}
+	for _, name := range cfg.ignore {
+		if iface, err := net.InterfaceByName(name); err == nil {
+			m.ignored[uint32(iface.Index)] = true
+		}
+	}
+
+	events := make(chan Change)
+	go m.receive(events)
+	go m.debounce(events)
+	return m, nil
+}
+
+// Changes delivers debounced changes. It is closed when the monitor is closed.
+func (m *Monitor) Changes() <-chan Change {
+	return m.changes
+}
+
+func (m *Monitor) Close() error {
+	var err error
+	m.once.Do(func() {
+		err = m.conn.Close()
+	})
+	return err
+}
+
+func (m *Monitor) receive(events chan<- Change) {
+	defer close(events)
+
+	for {
+		msgs, err := m.conn.Receive()
+		if err != nil {
+			if errors.Is(err, unix.ENOBUFS) {
+				// events came faster than we read them,
+				// report a change since some were lost
+				events <- Change{Links: 1}
+				continue
+			}
+			// closed
+			return
+		}
+
+		var c Change
+		for _, msg := range msgs {
+			if m.ignored[ifindex(msg)] {
+				continue
+			}
+			switch msg.Header.Type {
+			case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+				c.Links++
+			case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+				c.Addrs++
+			case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+				c.Routes++
+			}
+		}
+		if c != (Change{}) {
+			events <- c
+		}
+	}
+}
+
+func (m *Monitor) debounce(events <-chan Change) {
+	defer close(m.changes)
+
+	var pending Change
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	for {
+		select {
+		case c, ok := <-events:
+			if !ok {
+				timer.Stop()
+				return
+			}
+			pending.Links += c.Links
+			pending.Addrs += c.Addrs
+			pending.Routes += c.Routes
+			timer.Reset(m.cfg.debounce)
+
+		case <-timer.C:
+			m.changes <- pending
+			pending = Change{}
+		}
+	}
+}
+
+// ifindex returns the interface index the message is about, 0 if unknown.
+func ifindex(msg netlink.Message) uint32 {
+	switch msg.Header.Type {
+	case unix.RTM_NEWLINK, unix.RTM_DELLINK:
+		// struct ifinfomsg: family, pad, type, index, ...
+		if len(msg.Data) >= unix.SizeofIfInfomsg {
+			return binary.NativeEndian.Uint32(msg.Data[4:8])
+		}
+	case unix.RTM_NEWADDR, unix.RTM_DELADDR:
+		// struct ifaddrmsg: family, prefixlen, flags, scope, index
+		if len(msg.Data) >= unix.SizeofIfAddrmsg {
+			return binary.NativeEndian.Uint32(msg.Data[4:8])
+		}
+	case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
+		if len(msg.Data) < unix.SizeofRtMsg {
+			return 0
+		}
+		ad, err := netlink.NewAttributeDecoder(msg.Data[unix.SizeofRtMsg:])
+		if err != nil {
+			return 0
+		}
+		for ad.Next() {
+			if ad.Type() == unix.RTA_OIF {
+				return ad.Uint32()
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package netmon
+
+type Monitor struct{}
+
+func New(opts ...Option) (*Monitor, error) {
+	return nil, ErrUnsupported
+}
+
+func (m *Monitor) Changes() <-chan Change {
+	return nil
+}
+
+func (m *Monitor) Close() error {
+	return nil
+}
@@ -0,0 +1,127 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+)
+
+// PeerRecord describes a peer registered on the server.
+type PeerRecord struct {
+	Network      string       `json:"network"`
+	PubKey       string       `json:"pubkey"`
+	Info         nat.STUNInfo `json:"info"`
+	Tags         []string     `json:"tags,omitempty"`
+	RegisteredAt time.Time    `json:"registered_at"`
+	ExpiresAt    time.Time    `json:"expires_at"`
+}
+
+// ClientRecord describes a peer listening for notifications over WebSocket.
+type ClientRecord struct {
+	Network     string    `json:"network"`
+	PubKey      string    `json:"pubkey"`
+	Tags        []string  `json:"tags,omitempty"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+	QueueLen    int       `json:"queue_len"`
+}
+
+const (
+	OutcomeResolved = "resolved" // peer info was returned
+	OutcomeNotified = "notified" // peer was not registered, it was asked to register
+	OutcomePending  = "pending"  // peer was not registered and could not be notified
+	OutcomeDenied   = "denied"   // lookup was denied by policy
+
+	OutcomeSucceeded = "succeeded" // reported by the client after a successful traversal
+	OutcomeFailed    = "failed"    // reported by the client after a failed traversal
+)
+
+// TraversalRecord is an attempt of one peer to reach another, as seen by the server.
+type TraversalRecord struct {
+	Time    time.Time `json:"time"`
+	Network string    `json:"network"`
+	From    string    `json:"from,omitempty"`
+	To      string    `json:"to"`
+	Outcome string    `json:"outcome"`
+
+	// set for outcomes reported by clients
+	Report *TraversalReport `json:"report,omitempty"`
+}
+
+// AdminClient calls the administrative API of the server.
+type AdminClient struct {
+	c *Client
+}
+
+func NewAdminClient(serverURL, token string, opts ...Option) (*AdminClient, error) {
+	c, err := NewClient(serverURL, append(opts, withToken(token))...)
+	if err != nil {
+		return nil, err
+	}
+	return &AdminClient{c: c}, nil
+}
+
+func withToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+func (a *AdminClient) get(ctx context.Context, path string, result any) error {
+	resp, err := a.c.do(ctx, http.MethodGet, a.c.endpoint("admin/"+path, nil), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func (a *AdminClient) delete(ctx context.Context, path, network, pubKey string) error {
+	q := url.Values{"pubkey": {pubKey}, "network": {network}}
+	resp, err := a.c.do(ctx, http.MethodDelete, a.c.endpoint("admin/"+path, q), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+func (a *AdminClient) Peers(ctx context.Context) ([]PeerRecord, error) {
+	var peers []PeerRecord
+	err := a.get(ctx, "peers", &peers)
+	return peers, err
+}
+
+func (a *AdminClient) Clients(ctx context.Context) ([]ClientRecord, error) {
+	var clients []ClientRecord
+	err := a.get(ctx, "clients", &clients)
+	return clients, err
+}
+
+func (a *AdminClient) Traversals(ctx context.Context) ([]TraversalRecord, error) {
+	var traversals []TraversalRecord
+	err := a.get(ctx, "traversals", &traversals)
+	return traversals, err
+}
+
+// EvictPeer removes the peer's registration.
+func (a *AdminClient) EvictPeer(ctx context.Context, network, pubKey string) error {
+	return a.delete(ctx, "peers", network, pubKey)
+}
+
+// KickClient closes the peer's WebSocket connection.
+func (a *AdminClient) KickClient(ctx context.Context, network, pubKey string) error {
+	return a.delete(ctx, "clients", network, pubKey)
+}
@@ -0,0 +1,388 @@
+package rendezvous
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+type Message = nat.Message
+
+// StatusError is returned when the server responds with an unexpected HTTP status.
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected http status: %s", e.Status)
+}
+
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	dialer     *websocket.Dialer
+	tlsConfig  *tls.Config
+	backoff    Backoff
+	network    string
+	token      string
+	self       string
+	tags       []string
+	// proves the identity to the server, see ProofVerifier
+	privateKey *ecdh.PrivateKey
+	serverKey  atomic.Pointer[ecdh.PublicKey]
+}
+
+type Option func(*Client)
+
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *Client) {
+		c.tlsConfig = cfg
+	}
+}
+
+func WithBackoff(b Backoff) Option {
+	return func(c *Client) {
+		c.backoff = b
+	}
+}
+
+// WithNetwork selects the network (namespace of peers) on the server
+// and the token authorizing to join it.
+func WithNetwork(name, token string) Option {
+	return func(c *Client) {
+		c.network = name
+		c.token = token
+	}
+}
+
+// WithIdentity sets the public key of the peer using the client.
+// The server uses it to apply access control when looking up other peers.
+// Tags are advertised when the peer registers or subscribes.
+func WithIdentity(pubKey string, tags ...string) Option {
+	return func(c *Client) {
+		c.self = pubKey
+		c.tags = tags
+	}
+}
+
+// WithPrivateKey sets the Wireguard private key of the peer using the client.
+// Lookups are then signed, so that the server can verify the identity set
+// by WithIdentity, which servers with an access control policy require.
+func WithPrivateKey(key [32]byte) Option {
+	return func(c *Client) {
+		c.privateKey, _ = ecdh.X25519().NewPrivateKey(key[:])
+	}
+}
+
+const DefaultPort = 8080
+
+// ServerURL turns the server address given by the user into a base URL.
+// Full URLs are returned unchanged, a bare host (optionally with a port)
+// is expanded to http://host:8080/.
+func ServerURL(server string) string {
+	if strings.Contains(server, "://") {
+		return server
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), strconv.Itoa(DefaultPort))
+	}
+	return fmt.Sprintf("http://%s/", server)
+}
+
+// NewClient creates a client of the rendezvous server listening at serverURL,
+// e.g. "http://example.com:8080/".
+func NewClient(serverURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid server URL scheme %q", u.Scheme)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	dialer := *websocket.DefaultDialer
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		dialer:     &dialer,
+		backoff:    DefaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.tlsConfig != nil {
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = c.tlsConfig
+		hc := *c.httpClient
+		hc.Transport = tr
+		c.httpClient = &hc
+		c.dialer.TLSClientConfig = c.tlsConfig
+	}
+	return c, nil
+}
+
+func (c *Client) URL() string {
+	return c.baseURL.String()
+}
+
+// Hostname returns the server host without port.
+func (c *Client) Hostname() string {
+	return c.baseURL.Hostname()
+}
+
+func (c *Client) query(pubKey string) url.Values {
+	q := url.Values{"pubkey": {pubKey}}
+	if c.network != "" {
+		q.Set("network", c.network)
+	}
+	if c.self != "" {
+		if pubKey == c.self {
+			q["tag"] = c.tags
+		} else {
+			q.Set("from", c.self)
+		}
+	}
+	return q
+}
+
+// header returns headers with the token and the trace context of ctx.
+func (c *Client) header(ctx context.Context) http.Header {
+	h := http.Header{}
+	if c.token != "" {
+		h.Set("Authorization", "Bearer "+c.token)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(h))
+	return h
+}
+
+func (c *Client) endpoint(path string, query url.Values) string {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (c *Client) wsEndpoint(path string, query url.Values) string {
+	u := *c.baseURL
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+	u.Path += path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// idempotent requests can be safely sent again
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// addProof signs requests made on behalf of the identity (lookups with
+// the from parameter and requests about itself) once the server key is known.
+func (c *Client) addProof(req *http.Request) error {
+	serverKey := c.serverKey.Load()
+	if c.privateKey == nil || serverKey == nil || c.self == "" {
+		return nil
+	}
+	q := req.URL.Query()
+	if Identity(req) != c.self {
+		return nil
+	}
+	p, err := proof(c.privateKey, serverKey, req.Method, q.Get("network"), q.Get("from"), q.Get("pubkey"), time.Now())
+	if err != nil {
+		return err
+	}
+	req.Header.Set(ProofHeader, p)
+	return nil
+}
+
+// proofRequired reports whether the request was rejected for a missing or outdated
+// proof. The server key sent with the rejection is used from now on.
+func (c *Client) proofRequired(resp *http.Response) bool {
+	if c.privateKey == nil || resp.StatusCode != http.StatusUnauthorized {
+		return false
+	}
+	key, err := parseKey(resp.Header.Get(ProofKeyHeader))
+	if err != nil {
+		return false
+	}
+	if old := c.serverKey.Swap(key); old != nil && old.Equal(key) {
+		// the proof was rejected for another reason
+		return false
+	}
+	return true
+}
+
+func retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+// do sends the request. Idempotent requests are retried on transport errors
+// and 5xx responses, others are sent only once. A request rejected for
+// a missing identity proof is sent again with the proof.
+func (c *Client) do(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+
+	attempts := c.backoff.Attempts
+	if attempts < 1 || !idempotent(method) {
+		attempts = 1
+	}
+	proofRetried, retryNow := false, false
+
+	for i := 0; i < attempts; i++ {
+		if i > 0 && !retryNow {
+			if err := sleepCtx(ctx, c.backoff.Delay(i-1)); err != nil {
+				return nil, err
+			}
+		}
+		retryNow = false
+
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header = c.header(ctx)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if err := c.addProof(req); err != nil {
+			return nil, err
+		}
+
+		resp, err = c.httpClient.Do(req)
+		if err == nil && !proofRetried && c.proofRequired(resp) {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			// not counted as a failed attempt
+			proofRetried, retryNow = true, true
+			attempts++
+			continue
+		}
+		if !retryable(resp, err) {
+			return resp, err
+		}
+		if err == nil && i < attempts-1 {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// Publish registers STUN info of the peer identified by pubKey.
+func (c *Client) Publish(ctx context.Context, pubKey string, info *nat.STUNInfo) error {
+	reqPayload, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, c.endpoint("", c.query(pubKey)), reqPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
+
+// Lookup returns STUN info of the peer identified by pubKey
+// or nil if the peer is not registered (yet).
+func (c *Client) Lookup(ctx context.Context, pubKey string) (*nat.STUNInfo, error) {
+	return c.lookup(ctx, "", pubKey)
+}
+
+// HubLookup returns the endpoint of the peer identified by pubKey as seen
+// by wgnt-server in hub mode (a Wireguard peer of everyone) or nil if the peer
+// is not connected to the hub. The peer is asked to connect back.
+func (c *Client) HubLookup(ctx context.Context, pubKey string) (*nat.STUNInfo, error) {
+	return c.lookup(ctx, "hub", pubKey)
+}
+
+func (c *Client) lookup(ctx context.Context, path, pubKey string) (*nat.STUNInfo, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint(path, c.query(pubKey)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	default:
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	result := &nat.STUNInfo{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WaitForPeer polls the server until the peer registers or ctx is done.
+func (c *Client) WaitForPeer(ctx context.Context, pubKey string, interval time.Duration) (*nat.STUNInfo, error) {
+	return c.waitFor(ctx, c.Lookup, pubKey, interval)
+}
+
+// WaitForHubPeer polls the hub until the peer connects to it or ctx is done.
+func (c *Client) WaitForHubPeer(ctx context.Context, pubKey string, interval time.Duration) (*nat.STUNInfo, error) {
+	return c.waitFor(ctx, c.HubLookup, pubKey, interval)
+}
+
+func (c *Client) waitFor(ctx context.Context, lookup func(context.Context, string) (*nat.STUNInfo, error), pubKey string, interval time.Duration) (*nat.STUNInfo, error) {
+	for {
+		result, err := lookup(ctx, pubKey)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			return result, nil
+		}
+		if err := sleepCtx(ctx, interval); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package rendezvous
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+)
+
+var testBackoff = Backoff{
+	Attempts: 3,
+	Initial:  time.Millisecond,
+	Max:      2 * time.Millisecond,
+}
+
+// newTestServer responds with the given statuses in turn, repeating the last one.
+func newTestServer(t *testing.T, statuses ...int) (*Client, *atomic.Int32) {
+	t.Helper()
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(requests.Add(1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		w.WriteHeader(statuses[i])
+		if statuses[i] == http.StatusOK && r.Method == http.MethodGet {
+			w.Write([]byte(`{"public_ip":"1.2.3.4","public_port":51820}`))
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := NewClient(srv.URL, WithBackoff(testBackoff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, &requests
+}
+
+func TestLookupRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int32
+		found    bool
+		code     int
+	}{
+		{"ok", []int{200}, 1, true, 0},
+		{"not registered", []int{204}, 1, false, 0},
+		{"recovers from 5xx", []int{503, 502, 200}, 3, true, 0},
+		{"gives up after attempts", []int{500}, 3, false, 500},
+		{"4xx is not retried", []int{403}, 1, false, 403},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, requests := newTestServer(t, tt.statuses...)
+			info, err := c.Lookup(context.Background(), "key")
+
+			if n := requests.Load(); n != tt.requests {
+				t.Errorf("got %d requests, want %d", n, tt.requests)
+			}
+			if tt.code != 0 {
+				var se *StatusError
+				if !errors.As(err, &se) || se.Code != tt.code {
+					t.Fatalf("got error %v, want status %d", err, tt.code)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found := info != nil; found != tt.found {
+				t.Fatalf("got %v, want found=%v", info, tt.found)
+			}
+			if tt.found && (info.PublicIP != "1.2.3.4" || info.PublicPort != 51820) {
+				t.Errorf("got %+v", info)
+			}
+		})
+	}
+}
+
+func TestPublishNotRetried(t *testing.T) {
+	c, requests := newTestServer(t, 503, 200)
+	err := c.Publish(context.Background(), "key", &nat.STUNInfo{PublicIP: "1.2.3.4", PublicPort: 51820})
+
+	var se *StatusError
+	if !errors.As(err, &se) || se.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got error %v, want status 503", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	c, requests := newTestServer(t, 500)
+	c.backoff = Backoff{Attempts: 10, Initial: time.Hour, Max: time.Hour}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := c.Lookup(ctx, "key")
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want deadline exceeded", err)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("got %d requests, want 1", n)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond, 800 * time.Millisecond},
+		{10, 500 * time.Millisecond, time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := b.Delay(tt.retry); d < tt.min || d > tt.max {
+				t.Fatalf("Delay(%d) = %s, want between %s and %s", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestSimpleHubPeer(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		body        string
+		endpoint    string
+	}{
+		{"json", "application/json", 200, `{"public_key":"key","endpoint":"1.2.3.4:51820"}`, "1.2.3.4:51820"},
+		{"plain text", "text/plain", 200, "1.2.3.4:51820\n", "1.2.3.4:51820"},
+		{"plain text unknown", "text/plain", 200, "\n", ""},
+		{"not found", "application/json", 404, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c, err := NewClient(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			endpoint, err := c.SimpleHubEndpoint(context.Background(), "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if endpoint != tt.endpoint {
+				t.Errorf("got endpoint %q, want %q", endpoint, tt.endpoint)
+			}
+		})
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"example.com", "http://example.com:8080/"},
+		{"example.com:9000", "http://example.com:9000/"},
+		{"192.0.2.1", "http://192.0.2.1:8080/"},
+		{"2001:db8::1", "http://[2001:db8::1]:8080/"},
+		{"[2001:db8::1]", "http://[2001:db8::1]:8080/"},
+		{"[2001:db8::1]:9000", "http://[2001:db8::1]:9000/"},
+		{"https://example.com/wgnt/", "https://example.com/wgnt/"},
+	}
+
+	for _, tt := range tests {
+		if got := ServerURL(tt.server); got != tt.want {
+			t.Errorf("ServerURL(%q) = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
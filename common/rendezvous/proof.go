@@ -0,0 +1,130 @@
+package rendezvous
+
+import (
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A peer proves to the server that it owns the Wireguard key it acts as:
+// the from parameter of lookups or, in requests about itself (register,
+// subscribe, report), the pubkey parameter. Wireguard keys cannot sign, but both sides can derive
+// a shared secret from their X25519 keys and the peer authenticates
+// the request with it. The server sends its public key with every rejection
+// (ProofKeyHeader), so it does not need to be distributed.
+const (
+	ProofHeader    = "Wgnt-Proof"
+	ProofKeyHeader = "Wgnt-Proof-Key"
+)
+
+// how far the timestamp of a proof may be from the server's clock
+const proofMaxSkew = 5 * time.Minute
+
+var (
+	ErrProofMissing = errors.New("identity proof missing")
+	ErrProofInvalid = errors.New("identity proof invalid")
+)
+
+// proofMAC authenticates the identity of the requester (from) and
+// the subject of the request at the given time.
+func proofMAC(secret []byte, method, network, from, pubKey string, ts int64) []byte {
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "wgnt-proof-v1\n%s\n%s\n%s\n%s\n%d", method, network, from, pubKey, ts)
+	return mac.Sum(nil)
+}
+
+func parseKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// proof returns the value of ProofHeader for the request.
+func proof(key *ecdh.PrivateKey, serverKey *ecdh.PublicKey, method, network, from, pubKey string, now time.Time) (string, error) {
+	secret, err := key.ECDH(serverKey)
+	if err != nil {
+		return "", err
+	}
+	ts := now.Unix()
+	mac := proofMAC(secret, method, network, from, pubKey, ts)
+	return fmt.Sprintf("%d:%s", ts, base64.StdEncoding.EncodeToString(mac)), nil
+}
+
+// ProofVerifier checks identity proofs on the server.
+type ProofVerifier struct {
+	key *ecdh.PrivateKey
+}
+
+// NewProofVerifier generates the server key. It changes on every start,
+// clients learn the new one from the first rejected request.
+func NewProofVerifier() (*ProofVerifier, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &ProofVerifier{key: key}, nil
+}
+
+// PublicKey returns the server key in the format of Wireguard keys.
+func (v *ProofVerifier) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(v.key.PublicKey().Bytes())
+}
+
+// Identity returns the peer the request is made on behalf of.
+func Identity(r *http.Request) string {
+	q := r.URL.Query()
+	if from := q.Get("from"); from != "" {
+		return from
+	}
+	return q.Get("pubkey")
+}
+
+// Verify checks that the request was sent by the owner of the private key
+// of the peer given by Identity.
+func (v *ProofVerifier) Verify(r *http.Request) error {
+	q := r.URL.Query()
+	network, from, pubKey := q.Get("network"), q.Get("from"), q.Get("pubkey")
+
+	value := r.Header.Get(ProofHeader)
+	if value == "" {
+		return ErrProofMissing
+	}
+	tsStr, macStr, ok := strings.Cut(value, ":")
+	if !ok {
+		return ErrProofInvalid
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return ErrProofInvalid
+	}
+	if skew := time.Since(time.Unix(ts, 0)); skew > proofMaxSkew || skew < -proofMaxSkew {
+		return fmt.Errorf("%w: timestamp off by %s", ErrProofInvalid, skew.Round(time.Second))
+	}
+	mac, err := base64.StdEncoding.DecodeString(macStr)
+	if err != nil {
+		return ErrProofInvalid
+	}
+
+	peerKey, err := parseKey(Identity(r))
+	if err != nil {
+		return fmt.Errorf("%w: bad public key", ErrProofInvalid)
+	}
+	secret, err := v.key.ECDH(peerKey)
+	if err != nil {
+		return ErrProofInvalid
+	}
+	if !hmac.Equal(mac, proofMAC(secret, r.Method, network, from, pubKey, ts)) {
+		return ErrProofInvalid
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package rendezvous
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func generateKey(t *testing.T) ([32]byte, string) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return [32]byte(key.Bytes()), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes())
+}
+
+// newProofServer requires a valid proof for lookups with the from parameter,
+// like wgnt-server with an access control policy.
+func newProofServer(t *testing.T) (*httptest.Server, *atomic.Pointer[ProofVerifier], *atomic.Int32) {
+	t.Helper()
+	var verifier atomic.Pointer[ProofVerifier]
+	var requests atomic.Int32
+	rotateVerifier(t, &verifier)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		v := verifier.Load()
+		if err := v.Verify(r); err != nil {
+			w.Header().Set(ProofKeyHeader, v.PublicKey())
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &verifier, &requests
+}
+
+func rotateVerifier(t *testing.T, verifier *atomic.Pointer[ProofVerifier]) {
+	t.Helper()
+	v, err := NewProofVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier.Store(v)
+}
+
+func TestProof(t *testing.T) {
+	key, pubKey := generateKey(t)
+	otherKey, _ := generateKey(t)
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		requests int32
+		ok       bool
+	}{
+		{"valid", []Option{WithIdentity(pubKey), WithPrivateKey(key)}, 2, true},
+		{"no private key", []Option{WithIdentity(pubKey)}, 1, false},
+		{"key of another peer", []Option{WithIdentity(pubKey), WithPrivateKey(otherKey)}, 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, requests := newProofServer(t)
+			c, err := NewClient(srv.URL, append(tt.opts, WithBackoff(testBackoff))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = c.Lookup(context.Background(), "peer")
+			if n := requests.Load(); n != tt.requests {
+				t.Errorf("got %d requests, want %d", n, tt.requests)
+			}
+			if tt.ok {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var se *StatusError
+			if !errors.As(err, &se) || se.Code != http.StatusUnauthorized {
+				t.Fatalf("got error %v, want status 401", err)
+			}
+		})
+	}
+}
+
+func TestProofServerKeyChanged(t *testing.T) {
+	key, pubKey := generateKey(t)
+	srv, verifier, requests := newProofServer(t)
+	c, err := NewClient(srv.URL, WithIdentity(pubKey), WithPrivateKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Lookup(context.Background(), "peer"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the server key is learned once
+	if n := requests.Load(); n != 3 {
+		t.Errorf("got %d requests, want 3", n)
+	}
+
+	// e.g. the server restarted
+	rotateVerifier(t, verifier)
+	if _, err := c.Lookup(context.Background(), "peer"); err != nil {
+		t.Fatal(err)
+	}
+	if n := requests.Load(); n != 5 {
+		t.Errorf("got %d requests, want 5", n)
+	}
+}
+
+func TestProofVerify(t *testing.T) {
+	key, pubKey := generateKey(t)
+	v, err := NewProofVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := parseKey(v.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	privKey, err := ecdh.X25519().NewPrivateKey(key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// the proof is made for these, the request is sent with the defaults
+		method, pubKey string
+		at             time.Time
+		err            error
+	}{
+		{"valid", http.MethodGet, "peer", time.Now(), nil},
+		{"other peer", http.MethodGet, "other", time.Now(), ErrProofInvalid},
+		{"other method", http.MethodPost, "peer", time.Now(), ErrProofInvalid},
+		{"expired", http.MethodGet, "peer", time.Now().Add(-10 * time.Minute), ErrProofInvalid},
+		{"from the future", http.MethodGet, "peer", time.Now().Add(10 * time.Minute), ErrProofInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := proof(privKey, serverKey, tt.method, "net", pubKey, tt.pubKey, tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/?network=net&pubkey=peer&from="+url.QueryEscape(pubKey), nil)
+			r.Header.Set(ProofHeader, p)
+
+			if err := v.Verify(r); !errors.Is(err, tt.err) {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/?pubkey=peer&from="+url.QueryEscape(pubKey), nil)
+	if err := v.Verify(r); !errors.Is(err, ErrProofMissing) {
+		t.Errorf("got %v, want %v", err, ErrProofMissing)
+	}
+}
@@ -0,0 +1,54 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+)
+
+const (
+	StrategyDirect      = "direct"            // both peers behind easy NAT
+	StrategyGuessRemote = "guess_remote_port" // we are behind easy NAT, the peer behind hard NAT
+	StrategyGuessLocal  = "guess_local_port"  // we are behind hard NAT, the peer behind easy NAT
+	StrategyInfeasible  = "infeasible"        // both peers behind hard NAT
+	StrategyHub         = "hub"               // endpoint seen by a hub server, no hole punching
+)
+
+// Strategies lists all strategies a client may report.
+var Strategies = []string{StrategyDirect, StrategyGuessRemote, StrategyGuessLocal, StrategyInfeasible, StrategyHub}
+
+// TraversalReport describes one traversal attempt of a client.
+type TraversalReport struct {
+	Peer          string        `json:"peer"`
+	LocalNAT      nat.NAT       `json:"local_nat"`
+	RemoteNAT     nat.NAT       `json:"remote_nat"`
+	Strategy      string        `json:"strategy"`
+	ProbesSent    int           `json:"probes_sent"`
+	FirstResponse time.Duration `json:"first_response_ns"`
+	Duration      time.Duration `json:"duration_ns"`
+	Success       bool          `json:"success"`
+	Error         string        `json:"error,omitempty"`
+}
+
+// Report sends the outcome of a traversal attempt to the server
+// for fleet-wide statistics. It requires the identity set by WithIdentity.
+func (c *Client) Report(ctx context.Context, report *TraversalReport) error {
+	reqPayload, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, c.endpoint("report", c.query(c.self)), reqPayload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package rendezvous
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes how failed requests are retried.
+type Backoff struct {
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+}
+
+var DefaultBackoff = Backoff{
+	Attempts: 4,
+	Initial:  200 * time.Millisecond,
+	Max:      5 * time.Second,
+}
+
+// Delay returns the time to wait before the given retry (counted from 0),
+// exponentially growing up to Max with up to 50% of random jitter.
+func (b Backoff) Delay(retry int) time.Duration {
+	d := b.Initial
+	for i := 0; i < retry && d < b.Max; i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,96 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SimpleHubPeer is a Wireguard peer of a simple-server hub as the hub sees it.
+// Not to be confused with the hub mode of wgnt-server (see Client.HubLookup).
+type SimpleHubPeer struct {
+	PublicKey string `json:"public_key"`
+	// empty if the peer has not connected yet
+	Endpoint string `json:"endpoint,omitempty"`
+	// zero if there was no handshake
+	LastHandshake time.Time `json:"last_handshake"`
+	ReceiveBytes  int64     `json:"rx_bytes"`
+	TransmitBytes int64     `json:"tx_bytes"`
+	AllowedIPs    []string  `json:"allowed_ips"`
+}
+
+func isJSON(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// SimpleHubPeer asks a simple-server hub for the peer identified by pubKey.
+// It returns nil if the hub does not know the peer.
+func (c *Client) SimpleHubPeer(ctx context.Context, pubKey string) (*SimpleHubPeer, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint("", c.query(pubKey)), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	if !isJSON(resp) {
+		// older hubs respond with a bare ip:port line, empty for unknown peers
+		respBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		endpoint := strings.TrimSpace(string(respBytes))
+		if endpoint == "" {
+			return nil, nil
+		}
+		return &SimpleHubPeer{PublicKey: pubKey, Endpoint: endpoint}, nil
+	}
+
+	peer := &SimpleHubPeer{}
+	if err := json.NewDecoder(resp.Body).Decode(peer); err != nil {
+		return nil, err
+	}
+	return peer, nil
+}
+
+// SimpleHubPeers lists all peers of a simple-server hub.
+func (c *Client) SimpleHubPeers(ctx context.Context) ([]SimpleHubPeer, error) {
+	resp, err := c.do(ctx, http.MethodGet, c.endpoint("peers", nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	var peers []SimpleHubPeer
+	if err := json.NewDecoder(resp.Body).Decode(&peers); err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// SimpleHubEndpoint asks a simple-server hub (a Wireguard peer reflecting what it sees)
+// for the endpoint of the peer identified by pubKey.
+// It returns an empty string if the hub does not know the endpoint.
+func (c *Client) SimpleHubEndpoint(ctx context.Context, pubKey string) (string, error) {
+	peer, err := c.SimpleHubPeer(ctx, pubKey)
+	if err != nil || peer == nil {
+		return "", err
+	}
+	return peer.Endpoint, nil
+}
@@ -0,0 +1,282 @@
+package rendezvous
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultReconnectBackoff is used by subscriptions to reconnect to the server,
+// zero Attempts means retrying until the subscription is closed.
+var DefaultReconnectBackoff = Backoff{
+	Initial: 500 * time.Millisecond,
+	Max:     30 * time.Second,
+}
+
+const writeWait = 10 * time.Second
+
+// Subscription delivers notifications the server sends to a listening peer,
+// i.e. when another peer asks for its info.
+// A broken connection is re-established in the background of Next.
+type Subscription struct {
+	client *Client
+	pubKey string
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	backoff      Backoff
+	pingInterval time.Duration
+	readTimeout  time.Duration
+	onDisconnect func(error)
+	onReconnect  func(context.Context)
+
+	conn *websocket.Conn
+	mu   sync.Mutex
+}
+
+type SubscribeOption func(*Subscription)
+
+// WithReconnectBackoff sets how reconnecting is retried.
+func WithReconnectBackoff(b Backoff) SubscribeOption {
+	return func(s *Subscription) {
+		s.backoff = b
+	}
+}
+
+// WithKeepalive sets how often the client pings the server
+// and how long it waits for any message (including pings and pongs)
+// before it considers the connection dead.
+func WithKeepalive(pingInterval, readTimeout time.Duration) SubscribeOption {
+	return func(s *Subscription) {
+		s.pingInterval = pingInterval
+		s.readTimeout = readTimeout
+	}
+}
+
+// OnDisconnect is called with the error which broke the connection.
+func OnDisconnect(f func(err error)) SubscribeOption {
+	return func(s *Subscription) {
+		s.onDisconnect = f
+	}
+}
+
+// OnReconnect is called after the connection is re-established.
+// Notifications sent in the meantime are lost.
+func OnReconnect(f func(ctx context.Context)) SubscribeOption {
+	return func(s *Subscription) {
+		s.onReconnect = f
+	}
+}
+
+// dial opens the WebSocket connection. Like do, it proves the identity
+// and dials again if the handshake was rejected for a missing proof.
+func (c *Client) dial(ctx context.Context, pubKey string) (*websocket.Conn, error) {
+	target := c.wsEndpoint("ws", c.query(pubKey))
+	for proofRetried := false; ; proofRetried = true {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header = c.header(ctx)
+		if err := c.addProof(req); err != nil {
+			return nil, err
+		}
+
+		conn, resp, err := c.dialer.DialContext(ctx, target, req.Header)
+		if err == nil {
+			return conn, nil
+		}
+		if resp == nil {
+			return nil, err
+		}
+		if !proofRetried && c.proofRequired(resp) {
+			continue
+		}
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+}
+
+// Subscribe opens a WebSocket connection on behalf of the peer identified by pubKey.
+// The subscription lasts until ctx is done or Close is called.
+func (c *Client) Subscribe(ctx context.Context, pubKey string, opts ...SubscribeOption) (*Subscription, error) {
+	s := &Subscription{
+		client:       c,
+		pubKey:       pubKey,
+		backoff:      DefaultReconnectBackoff,
+		pingInterval: 15 * time.Second,
+		readTimeout:  45 * time.Second,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+
+	conn, err := c.dial(s.ctx, pubKey)
+	if err != nil {
+		s.cancel()
+		return nil, err
+	}
+	s.setConn(conn)
+	return s, nil
+}
+
+func (s *Subscription) setConn(conn *websocket.Conn) {
+	extendDeadline := func() {
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	}
+	extendDeadline()
+	conn.SetPingHandler(func(data string) error {
+		extendDeadline()
+		err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(writeWait))
+		if errors.Is(err, websocket.ErrCloseSent) {
+			return nil
+		}
+		return err
+	})
+	conn.SetPongHandler(func(string) error {
+		extendDeadline()
+		return nil
+	})
+
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+
+	go s.ping(conn)
+}
+
+// ping keeps sending pings until the connection is closed.
+// A connection which is silently dropped (e.g. by a NAT or a changed network)
+// is detected by missing pongs.
+func (s *Subscription) ping(conn *websocket.Conn) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Subscription) current() *websocket.Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn
+}
+
+func (s *Subscription) drop(conn *websocket.Conn, err error) {
+	s.mu.Lock()
+	current := s.conn == conn
+	if current {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+
+	conn.Close()
+	// the connection may have been dropped already by Reconnect
+	if current && s.onDisconnect != nil {
+		s.onDisconnect(err)
+	}
+}
+
+var errReconnectRequested = errors.New("reconnect requested")
+
+// Reconnect closes the current connection, Next re-establishes it.
+// It is used when the local network changes and the connection
+// is likely dead before the keepalive notices.
+func (s *Subscription) Reconnect() {
+	if conn := s.current(); conn != nil {
+		s.drop(conn, errReconnectRequested)
+	}
+}
+
+// reconnect dials the server until it succeeds. It gives up on errors
+// which are not going to change by retrying, e.g. when the token is rejected.
+func (s *Subscription) reconnect() error {
+	var err error
+	for attempt := 0; s.backoff.Attempts == 0 || attempt < s.backoff.Attempts; attempt++ {
+		if err := sleepCtx(s.ctx, s.backoff.Delay(attempt)); err != nil {
+			return err
+		}
+
+		// the network may still be broken, don't hang in the handshake
+		ctx, cancel := context.WithTimeout(s.ctx, s.readTimeout)
+		var conn *websocket.Conn
+		conn, err = s.client.dial(ctx, s.pubKey)
+		cancel()
+		if err == nil {
+			s.setConn(conn)
+			if s.onReconnect != nil {
+				s.onReconnect(s.ctx)
+			}
+			return nil
+		}
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.Code < 500 {
+			return err
+		}
+		if s.ctx.Err() != nil {
+			return s.ctx.Err()
+		}
+	}
+	return err
+}
+
+// Next blocks until the next notification arrives.
+// It returns an error only if the subscription is closed or reconnecting fails.
+func (s *Subscription) Next() (Message, error) {
+	for {
+		conn := s.current()
+		if conn == nil {
+			if err := s.reconnect(); err != nil {
+				return Message{}, err
+			}
+			continue
+		}
+
+		_, r, err := conn.NextReader()
+		if s.ctx.Err() != nil {
+			return Message{}, s.ctx.Err()
+		}
+		if err != nil {
+			s.drop(conn, err)
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+
+		msg := Message{}
+		if err := json.NewDecoder(r).Decode(&msg); err != nil {
+			// the connection is fine, skip the message
+			continue
+		}
+		return msg, nil
+	}
+}
+
+func (s *Subscription) Close() error {
+	s.cancel()
+
+	s.mu.Lock()
+	conn := s.conn
+	s.conn = nil
+	s.mu.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeWait))
+	return conn.Close()
+}
+
+// Connected reports whether the connection to the server is currently up.
+func (s *Subscription) Connected() bool {
+	return s.current() != nil
+}
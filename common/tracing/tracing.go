@@ -0,0 +1,107 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Setup installs the global tracer provider exporting spans of service
+// to an OTLP/HTTP collector at endpoint, e.g. "localhost:4318"
+// (plain HTTP) or "https://collector.example.com:4318".
+// If endpoint is empty, the standard OTEL_EXPORTER_OTLP_* variables are used
+// and tracing stays disabled when none of them is set.
+// The returned function flushes pending spans.
+func Setup(ctx context.Context, service, endpoint string) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var opts []otlptracehttp.Option
+	switch {
+	case strings.Contains(endpoint, "://"):
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	case endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	case os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "":
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service)))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Inject returns the trace context of ctx in a form
+// which can be sent along with a message.
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the trace context created by Inject.
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// End records err (if any) and ends the span.
+func End(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, context.Canceled) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Observer records hole punching events as events of span.
+// Individual probes are left out, there are hundreds of them.
+func Observer(span trace.Span) nat.Observer {
+	return nat.ObserverFunc(func(e nat.Event) {
+		switch e := e.(type) {
+		case nat.ResponseReceived:
+			span.AddEvent("response received", trace.WithAttributes(
+				attribute.String("local", e.Local), attribute.String("remote", e.Remote)))
+		case nat.PortResolved:
+			span.AddEvent("port resolved", trace.WithAttributes(
+				attribute.String("local", e.Local), attribute.String("remote", e.Remote)))
+		case nat.Acked:
+			span.AddEvent("peer acknowledged", trace.WithAttributes(
+				attribute.String("local", e.Local), attribute.String("remote", e.Remote)))
+		case nat.Timeout:
+			span.AddEvent("timeout", trace.WithAttributes(
+				attribute.String("elapsed", e.Elapsed.String())))
+		case nat.ProbeError:
+			span.RecordError(e.Err, trace.WithAttributes(attribute.String("local", e.Local)))
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+)
+
+// HTTPProxy is a forward HTTP proxy connecting to servers using dial.
+// It supports CONNECT (e.g. for HTTPS) and plain HTTP requests.
+func HTTPProxy(dial DialFunc) http.Handler {
+	proxy := &httputil.ReverseProxy{
+		// requests to a proxy have absolute URLs already
+		Rewrite: func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{
+			DialContext: dial,
+		},
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			if !r.URL.IsAbs() {
+				st := http.StatusBadRequest
+				http.Error(w, http.StatusText(st), st)
+				return
+			}
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		t, err := dial(r.Context(), "tcp", r.Host)
+		if err != nil {
+			log.Printf("http proxy: %v", err)
+			st := http.StatusBadGateway
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Close()
+			st := http.StatusInternalServerError
+			http.Error(w, http.StatusText(st), st)
+			return
+		}
+		c, rw, err := hj.Hijack()
+		if err != nil {
+			t.Close()
+			return
+		}
+		if _, err := rw.WriteString("HTTP/1.1 200 Connection established\r\n\r\n"); err == nil {
+			err = rw.Flush()
+		}
+		if err != nil {
+			c.Close()
+			t.Close()
+			return
+		}
+		// data the client sent after the request
+		if n := rw.Reader.Buffered(); n > 0 {
+			buffered, _ := rw.Reader.Peek(n)
+			t.Write(buffered)
+		}
+		pipe(c, t)
+	})
+}
+
+// ServeHTTPProxy serves HTTPProxy on l.
+func ServeHTTPProxy(l net.Listener, dial DialFunc) error {
+	return http.Serve(l, HTTPProxy(dial))
+}
@@ -0,0 +1,116 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestHTTPProxyConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialErr error
+		code    int
+	}{
+		{"connected", nil, http.StatusOK},
+		{"unreachable", errors.New("no route"), http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &echoDialer{err: tt.dialErr}
+			srv := httptest.NewServer(HTTPProxy(d.dial))
+			defer srv.Close()
+
+			c, err := net.Dial("tcp", srv.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			// data sent right after the request goes through the tunnel
+			_, err = io.WriteString(c, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nearly")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := bufio.NewReader(c)
+			resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.code {
+				t.Fatalf("got %s, want %d", resp.Status, tt.code)
+			}
+			if dialed := d.addresses(); len(dialed) != 1 || dialed[0] != "example.com:443" {
+				t.Errorf("dialed %q", dialed)
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			buf := make([]byte, len("early"))
+			if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "early" {
+				t.Fatalf("got %q, %v", buf, err)
+			}
+			if _, err := io.WriteString(c, "ping"); err != nil {
+				t.Fatal(err)
+			}
+			buf = make([]byte, 4)
+			if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "ping" {
+				t.Fatalf("got %q, %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestHTTPProxyRequest(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s", r.Host, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	var dialed []string
+	dial := func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return (&net.Dialer{}).DialContext(ctx, network, backend.Listener.Addr().String())
+	}
+	srv := httptest.NewServer(HTTPProxy(dial))
+	defer srv.Close()
+
+	proxyURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+	resp, err := client.Get("http://example.com/path?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "example.com /path?q=1" {
+		t.Errorf("got %s %q", resp.Status, body)
+	}
+	if len(dialed) != 1 || dialed[0] != "example.com:80" {
+		t.Errorf("dialed %q", dialed)
+	}
+
+	// a request to the proxy itself, not through it
+	resp, err = http.Get(srv.URL + "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("got %s, want %d", resp.Status, http.StatusBadRequest)
+	}
+}
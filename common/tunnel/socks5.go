@@ -0,0 +1,170 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol, RFC 1928. Only CONNECT without authentication is supported.
+const (
+	socksVersion = 5
+
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+
+	socksConnect = 1
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded           = 0
+	socksGeneralFailure      = 1
+	socksHostUnreachable     = 4
+	socksCommandNotSupported = 7
+	socksAddrNotSupported    = 8
+)
+
+const socksHandshakeTimeout = 30 * time.Second
+
+// ServeSOCKS5 serves a SOCKS5 proxy on l connecting clients using dial.
+// It returns when l is closed.
+func ServeSOCKS5(l net.Listener, dial DialFunc) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			if err := serveSOCKS5Conn(c, dial); err != nil {
+				log.Printf("socks5 %s: %v", c.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func serveSOCKS5Conn(c net.Conn, dial DialFunc) error {
+	c.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+	r := bufio.NewReader(c)
+
+	// greeting: version, methods
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		c.Close()
+		return err
+	}
+	if hdr[0] != socksVersion {
+		c.Close()
+		return fmt.Errorf("unsupported SOCKS version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		c.Close()
+		return err
+	}
+	method := byte(socksNoAcceptable)
+	for _, m := range methods {
+		if m == socksNoAuth {
+			method = socksNoAuth
+		}
+	}
+	if _, err := c.Write([]byte{socksVersion, method}); err != nil || method == socksNoAcceptable {
+		c.Close()
+		return err
+	}
+
+	// request: version, command, reserved, address
+	var req [4]byte
+	if _, err := io.ReadFull(r, req[:]); err != nil {
+		c.Close()
+		return err
+	}
+	host, err := readSOCKSAddr(r, req[3])
+	if err != nil {
+		socksReply(c, socksAddrNotSupported)
+		c.Close()
+		return err
+	}
+	if req[1] != socksConnect {
+		socksReply(c, socksCommandNotSupported)
+		c.Close()
+		return fmt.Errorf("unsupported command %d", req[1])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), socksHandshakeTimeout)
+	t, err := dial(ctx, "tcp", host)
+	cancel()
+	if err != nil {
+		socksReply(c, socksHostUnreachable)
+		c.Close()
+		return err
+	}
+	if err := socksReply(c, socksSucceeded); err != nil {
+		c.Close()
+		t.Close()
+		return err
+	}
+	c.SetDeadline(time.Time{})
+
+	// the client may have sent data right after the request
+	if n := r.Buffered(); n > 0 {
+		buffered, _ := r.Peek(n)
+		if _, err := t.Write(buffered); err != nil {
+			c.Close()
+			t.Close()
+			return err
+		}
+	}
+	pipe(c, t)
+	return nil
+}
+
+func readSOCKSAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, n[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("unsupported address type %d", atyp)
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socksReply sends a reply with an unspecified bound address.
+func socksReply(c net.Conn, status byte) error {
+	_, err := c.Write([]byte{socksVersion, status, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
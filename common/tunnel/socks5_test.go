@@ -0,0 +1,178 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoDialer records the dialed addresses and connects to an echo server.
+type echoDialer struct {
+	mu     sync.Mutex
+	dialed []string
+	err    error
+}
+
+func (d *echoDialer) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dialed = append(d.dialed, address)
+	if d.err != nil {
+		return nil, d.err
+	}
+	c, t := net.Pipe()
+	go func() {
+		io.Copy(t, t)
+		t.Close()
+	}()
+	return c, nil
+}
+
+func (d *echoDialer) addresses() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dialed
+}
+
+// checkEcho checks that data written to c comes back through the tunnel.
+func checkEcho(t *testing.T, c net.Conn) {
+	t.Helper()
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(c, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+}
+
+func TestSOCKS5(t *testing.T) {
+	greeting := []byte{5, 1, 0}
+	request := func(atyp byte, addr ...byte) []byte {
+		return append([]byte{5, 1, 0, atyp}, addr...)
+	}
+	reply := func(status byte) []byte {
+		return []byte{5, status, 0, 1, 0, 0, 0, 0, 0, 0}
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name    string
+		send    []byte
+		dialErr error
+		// the client sends nothing more
+		closeWrite bool
+		// the whole response, the connection is closed after it unless ok
+		want   []byte
+		dialed string
+		ok     bool
+	}{
+		{
+			name:   "IPv4",
+			send:   join(greeting, request(1, 10, 0, 0, 2, 0, 80)),
+			want:   join([]byte{5, 0}, reply(0)),
+			dialed: "10.0.0.2:80",
+			ok:     true,
+		},
+		{
+			name:   "IPv6",
+			send:   join(greeting, request(4, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 22)),
+			want:   join([]byte{5, 0}, reply(0)),
+			dialed: "[fd00::1]:22",
+			ok:     true,
+		},
+		{
+			name:   "domain",
+			send:   join(greeting, request(3, 11), []byte("example.com"), []byte{1, 0xbb}),
+			want:   join([]byte{5, 0}, reply(0)),
+			dialed: "example.com:443",
+			ok:     true,
+		},
+		{
+			name:   "data after request",
+			send:   join([]byte{5, 2, 2, 0}, request(1, 10, 0, 0, 2, 0, 80), []byte("ping")),
+			want:   join([]byte{5, 0}, reply(0), []byte("ping")),
+			dialed: "10.0.0.2:80",
+			ok:     true,
+		},
+		{
+			name: "SOCKS4",
+			send: []byte{4, 1, 0, 80, 10, 0, 0, 2, 0},
+		},
+		{
+			name: "no acceptable method",
+			send: []byte{5, 1, 2},
+			want: []byte{5, 0xff},
+		},
+		{
+			name:       "truncated methods",
+			send:       []byte{5, 3, 0},
+			closeWrite: true,
+		},
+		{
+			name: "unsupported command",
+			send: join(greeting, []byte{5, 2, 0, 1, 10, 0, 0, 2, 0, 80}),
+			want: join([]byte{5, 0}, reply(7)),
+		},
+		{
+			name: "unsupported address type",
+			send: join(greeting, request(2, 10, 0, 0, 2, 0, 80)),
+			want: join([]byte{5, 0}, reply(8)),
+		},
+		{
+			name:    "unreachable",
+			send:    join(greeting, request(1, 10, 0, 0, 2, 0, 80)),
+			dialErr: errors.New("no route"),
+			want:    join([]byte{5, 0}, reply(4)),
+			dialed:  "10.0.0.2:80",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			d := &echoDialer{err: tt.dialErr}
+			go ServeSOCKS5(l, d.dial)
+
+			c, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			c.SetDeadline(time.Now().Add(5 * time.Second))
+			if _, err := c.Write(tt.send); err != nil {
+				t.Fatal(err)
+			}
+			if tt.closeWrite {
+				c.(*net.TCPConn).CloseWrite()
+			}
+
+			got := make([]byte, len(tt.want))
+			if _, err := io.ReadFull(c, got); err != nil || !bytes.Equal(got, tt.want) {
+				t.Fatalf("got %v, %v, want %v", got, err, tt.want)
+			}
+
+			if tt.ok {
+				checkEcho(t, c)
+			} else if n, err := c.Read(make([]byte, 1)); n != 0 || err == nil {
+				t.Errorf("connection not closed: %d, %v", n, err)
+			}
+
+			dialed := d.addresses()
+			if tt.dialed == "" && len(dialed) > 0 || tt.dialed != "" && (len(dialed) != 1 || dialed[0] != tt.dialed) {
+				t.Errorf("dialed %q, want %q", dialed, tt.dialed)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+// Package tunnel exposes a tunnel which is not visible to the system
+// (e.g. of a userspace Wireguard device) through proxies and port forwards.
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// DialFunc connects to an address, e.g. DialContext of *netstack.Net.
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// pipe copies data both ways until both sides are done.
+func pipe(a, b net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		// let the other side finish sending
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+	wg.Wait()
+	a.Close()
+	b.Close()
+}
+
+// Forward accepts connections from l and connects each of them to target using dial.
+// For forwarding into the tunnel, l is a local listener and dial dials the tunnel,
+// in the other direction, l listens in the tunnel and dial dials locally.
+// It returns when l is closed.
+func Forward(l net.Listener, dial DialFunc, target string) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+
+		go func() {
+			t, err := dial(context.Background(), "tcp", target)
+			if err != nil {
+				log.Printf("forward %s -> %s: %v", l.Addr(), target, err)
+				c.Close()
+				return
+			}
+			pipe(c, t)
+		}()
+	}
+}
@@ -0,0 +1,311 @@
+// Package wgquick reads and updates wg-quick configuration files.
+// Changes are made in place, so comments, ordering and unknown keys
+// are preserved.
+package wgquick
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var ErrNoInterface = errors.New("missing [Interface] section")
+
+// DefaultPath is where wg-quick looks for the configuration of the interface.
+func DefaultPath(iface string) string {
+	return filepath.Join("/etc/wireguard", iface+".conf")
+}
+
+// line of the file; key lines are split into prefix, value and suffix
+// (an inline comment and the line ending), so that the value can be replaced.
+type line struct {
+	prefix string
+	value  string
+	suffix string
+	// lowercase, empty for other lines
+	key string
+}
+
+func (l *line) String() string {
+	return l.prefix + l.value + l.suffix
+}
+
+type section struct {
+	// lowercase, empty for the lines before the first section
+	name  string
+	lines []*line
+}
+
+func (s *section) get(key string) *line {
+	for _, l := range s.lines {
+		if l.key == key {
+			return l
+		}
+	}
+	return nil
+}
+
+func (s *section) all(key string) []string {
+	var values []string
+	for _, l := range s.lines {
+		if l.key == key {
+			values = append(values, l.value)
+		}
+	}
+	return values
+}
+
+// set replaces the value of the key or adds it after the last key of the section.
+func (s *section) set(key, name, value, eol string) {
+	if l := s.get(key); l != nil {
+		l.value = value
+		return
+	}
+	i := len(s.lines)
+	for i > 0 && s.lines[i-1].key == "" {
+		i--
+	}
+	if i == 0 {
+		// only the header, keep the comments below it
+		i = 1
+	}
+	l := &line{prefix: name + " = ", value: value, suffix: eol, key: key}
+	s.lines = append(s.lines[:i], append([]*line{l}, s.lines[i:]...)...)
+}
+
+// File is a parsed wg-quick configuration.
+type File struct {
+	sections []*section
+	eol      string
+	final    bool
+}
+
+// Parse reads the configuration. Values end at the first #, like in wg-quick.
+func Parse(data []byte) (*File, error) {
+	f := &File{sections: []*section{{}}}
+	text := string(data)
+	if strings.HasSuffix(text, "\n") {
+		text, f.final = text[:len(text)-1], true
+	}
+	if strings.HasSuffix(strings.SplitN(text, "\n", 2)[0], "\r") {
+		f.eol = "\r"
+	}
+
+	for i, raw := range strings.Split(text, "\n") {
+		content, _, _ := strings.Cut(raw, "#")
+		trimmed := strings.TrimSpace(content)
+		cur := f.sections[len(f.sections)-1]
+
+		switch {
+		case trimmed == "":
+			cur.lines = append(cur.lines, &line{prefix: raw})
+		case strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]"):
+			name := strings.ToLower(strings.TrimSpace(trimmed[1 : len(trimmed)-1]))
+			if name != "interface" && name != "peer" {
+				return nil, fmt.Errorf("line %d: unknown section %s", i+1, trimmed)
+			}
+			f.sections = append(f.sections, &section{name: name, lines: []*line{{prefix: raw}}})
+		default:
+			eq := strings.Index(content, "=")
+			if eq < 0 {
+				return nil, fmt.Errorf("line %d: expected key = value", i+1)
+			}
+			if cur.name == "" {
+				return nil, fmt.Errorf("line %d: key outside of a section", i+1)
+			}
+			key := strings.ToLower(strings.TrimSpace(content[:eq]))
+			rest := content[eq+1:]
+			value := strings.TrimSpace(rest)
+			start := eq + 1 + strings.Index(rest, value)
+			cur.lines = append(cur.lines, &line{
+				prefix: raw[:start],
+				value:  value,
+				suffix: raw[start+len(value):],
+				key:    key,
+			})
+		}
+	}
+	return f, nil
+}
+
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return f, nil
+}
+
+func (f *File) Bytes() []byte {
+	var b bytes.Buffer
+	first := true
+	for _, s := range f.sections {
+		for _, l := range s.lines {
+			if !first {
+				b.WriteString("\n")
+			}
+			first = false
+			b.WriteString(l.String())
+		}
+	}
+	if f.final {
+		b.WriteString("\n")
+	}
+	return b.Bytes()
+}
+
+// Save writes the file atomically (through a temporary file in the same directory)
+// and keeps the permissions of the original.
+func (f *File) Save(path string) (err error) {
+	mode := os.FileMode(0600)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if _, err := tmp.Write(f.Bytes()); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Update loads the file, applies the changes and saves it if anything changed.
+func Update(path string, change func(f *File) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f, err := Parse(data)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	if err := change(f); err != nil {
+		return err
+	}
+	if bytes.Equal(f.Bytes(), data) {
+		return nil
+	}
+	return f.Save(path)
+}
+
+func (f *File) iface() *section {
+	for _, s := range f.sections {
+		if s.name == "interface" {
+			return s
+		}
+	}
+	return nil
+}
+
+func (f *File) peer(pubKey string) *section {
+	for _, s := range f.sections {
+		if l := s.get("publickey"); s.name == "peer" && l != nil && l.value == pubKey {
+			return s
+		}
+	}
+	return nil
+}
+
+// ListenPort returns the listen port of the interface, 0 if it is not set.
+func (f *File) ListenPort() (int, error) {
+	s := f.iface()
+	if s == nil {
+		return 0, ErrNoInterface
+	}
+	l := s.get("listenport")
+	if l == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(l.value)
+}
+
+func (f *File) SetListenPort(port int) error {
+	s := f.iface()
+	if s == nil {
+		return ErrNoInterface
+	}
+	s.set("listenport", "ListenPort", strconv.Itoa(port), f.eol)
+	return nil
+}
+
+// SetEndpoint changes the endpoint (host:port) of the peer.
+func (f *File) SetEndpoint(pubKey, endpoint string) error {
+	s := f.peer(pubKey)
+	if s == nil {
+		return fmt.Errorf("%s: %w", pubKey, wireguard.ErrPeerNotFound)
+	}
+	s.set("endpoint", "Endpoint", endpoint, f.eol)
+	return nil
+}
+
+// Peers returns the peers of the file.
+func (f *File) Peers() ([]wireguard.PeerSpec, error) {
+	var peers []wireguard.PeerSpec
+	for _, s := range f.sections {
+		if s.name != "peer" {
+			continue
+		}
+		l := s.get("publickey")
+		if l == nil {
+			return nil, errors.New("peer without PublicKey")
+		}
+		if _, err := wgtypes.ParseKey(l.value); err != nil {
+			return nil, fmt.Errorf("invalid PublicKey %q: %w", l.value, err)
+		}
+		p := wireguard.PeerSpec{PublicKey: l.value}
+
+		if l := s.get("presharedkey"); l != nil {
+			p.PresharedKey = l.value
+		}
+		if l := s.get("endpoint"); l != nil {
+			p.Endpoint = l.value
+		}
+		for _, v := range s.all("allowedips") {
+			for _, cidr := range strings.Split(v, ",") {
+				if cidr = strings.TrimSpace(cidr); cidr != "" {
+					p.AllowedIPs = append(p.AllowedIPs, cidr)
+				}
+			}
+		}
+		if l := s.get("persistentkeepalive"); l != nil && l.value != "off" {
+			sec, err := strconv.Atoi(l.value)
+			if err != nil {
+				return nil, fmt.Errorf("peer %s: invalid PersistentKeepalive: %w", p.PublicKey, err)
+			}
+			p.Keepalive = time.Duration(sec) * time.Second
+		}
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
@@ -0,0 +1,281 @@
+package wgquick
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/wireguard"
+)
+
+const (
+	keyA = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	keyB = "TrMvSoP4jYQlY6RIzBgbssQqY3vxI2Pi+y71lOWWXX0="
+)
+
+// conf has comments, blank lines, inline comments, mixed case keys
+// and a peer (B) without an endpoint.
+const conf = `# managed by hand
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.0.0.1/24   # the tunnel address
+listenport=51820
+
+# the server
+[Peer]
+PublicKey = ` + keyA + `
+Endpoint = 192.0.2.1:51820 # static
+AllowedIPs = 10.0.0.0/24
+AllowedIPs = 192.168.1.0/24, 192.168.2.0/24
+PersistentKeepalive = 25
+
+[Peer] # laptop
+PublicKey = ` + keyB + `
+AllowedIPs = 10.0.0.3/32
+PersistentKeepalive = off
+# roams a lot
+
+`
+
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"comments", conf},
+		{"CRLF", crlf(conf)},
+		{"no final newline", strings.TrimRight(conf, "\n")},
+		{"empty", ""},
+		{"only comments", "# nothing yet\n\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := string(f.Bytes()); got != tt.data {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown section", "[Interface]\n[Peers]\n"},
+		{"key outside of a section", "ListenPort = 51820\n[Interface]\n"},
+		{"no equals sign", "[Interface]\nListenPort 51820\n"},
+		{"commented out equals sign", "[Interface]\nListenPort # = 51820\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse([]byte(tt.data)); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestSetEndpoint(t *testing.T) {
+	tests := []struct {
+		name     string
+		pubKey   string
+		endpoint string
+		// replaces in conf
+		old, new string
+	}{
+		{
+			name:     "replaced",
+			pubKey:   keyA,
+			endpoint: "198.51.100.7:40000",
+			old:      "Endpoint = 192.0.2.1:51820 # static\n",
+			new:      "Endpoint = 198.51.100.7:40000 # static\n",
+		},
+		{
+			// after the last key, not after the comment of the end of the file
+			name:     "inserted",
+			pubKey:   keyB,
+			endpoint: "[2001:db8::1]:51820",
+			old:      "PersistentKeepalive = off\n",
+			new:      "PersistentKeepalive = off\nEndpoint = [2001:db8::1]:51820\n",
+		},
+	}
+
+	for _, tt := range tests {
+		for _, eol := range []string{"LF", "CRLF"} {
+			t.Run(tt.name+" "+eol, func(t *testing.T) {
+				data, want := conf, strings.Replace(conf, tt.old, tt.new, 1)
+				if eol == "CRLF" {
+					data, want = crlf(data), crlf(want)
+				}
+				f, err := Parse([]byte(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := f.SetEndpoint(tt.pubKey, tt.endpoint); err != nil {
+					t.Fatal(err)
+				}
+				if got := string(f.Bytes()); got != want {
+					t.Errorf("got\n%s\nwant\n%s", got, want)
+				}
+			})
+		}
+	}
+
+	f, err := Parse([]byte(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetEndpoint("CksF0ZNh5iYxdMQ4wNoeTtoEXM0RSoNPnLOKZGVmf2A=", "192.0.2.9:51820"); !errors.Is(err, wireguard.ErrPeerNotFound) {
+		t.Errorf("got %v, want %v", err, wireguard.ErrPeerNotFound)
+	}
+}
+
+func TestSetListenPort(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "replaced",
+			data: conf,
+			want: strings.Replace(conf, "listenport=51820\n", "listenport=40001\n", 1),
+		},
+		{
+			name: "inserted",
+			data: "[Interface]\nPrivateKey = x\n\n[Peer]\nPublicKey = y\n",
+			want: "[Interface]\nPrivateKey = x\nListenPort = 40001\n\n[Peer]\nPublicKey = y\n",
+		},
+		{
+			name: "empty section",
+			data: "[Interface]\n# keys below\n",
+			want: "[Interface]\nListenPort = 40001\n# keys below\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.SetListenPort(40001); err != nil {
+				t.Fatal(err)
+			}
+			if got := string(f.Bytes()); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+			if port, err := f.ListenPort(); err != nil || port != 40001 {
+				t.Errorf("got %d, %v", port, err)
+			}
+		})
+	}
+
+	f, err := Parse([]byte("[Peer]\nPublicKey = y\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.SetListenPort(40001); !errors.Is(err, ErrNoInterface) {
+		t.Errorf("got %v, want %v", err, ErrNoInterface)
+	}
+}
+
+func TestPeers(t *testing.T) {
+	f, err := Parse([]byte(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers, err := f.Peers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []wireguard.PeerSpec{
+		{
+			PublicKey:  keyA,
+			Endpoint:   "192.0.2.1:51820",
+			AllowedIPs: []string{"10.0.0.0/24", "192.168.1.0/24", "192.168.2.0/24"},
+			Keepalive:  25 * time.Second,
+		},
+		{
+			PublicKey:  keyB,
+			AllowedIPs: []string{"10.0.0.3/32"},
+		},
+	}
+	if !slices.EqualFunc(peers, want, func(a, b wireguard.PeerSpec) bool {
+		return a.PublicKey == b.PublicKey && a.Endpoint == b.Endpoint && a.Keepalive == b.Keepalive &&
+			slices.Equal(a.AllowedIPs, b.AllowedIPs)
+	}) {
+		t.Errorf("got %+v, want %+v", peers, want)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wg0.conf")
+	if err := os.WriteFile(path, []byte(conf), 0640); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same values, nothing to write
+	err = Update(path, func(f *File) error {
+		if err := f.SetListenPort(51820); err != nil {
+			return err
+		}
+		return f.SetEndpoint(keyA, "192.0.2.1:51820")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(before, after) {
+		t.Error("unchanged file written")
+	}
+
+	err = Update(path, func(f *File) error {
+		return f.SetEndpoint(keyB, "198.51.100.7:40000")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(conf, "PersistentKeepalive = off\n", "PersistentKeepalive = off\nEndpoint = 198.51.100.7:40000\n", 1)
+	if string(data) != want {
+		t.Errorf("got\n%s\nwant\n%s", data, want)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0640 {
+		t.Errorf("got %v, %v, want mode 0640", fi.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary files left: %v", entries)
+	}
+
+	errChange := errors.New("change failed")
+	if err := Update(path, func(f *File) error { return errChange }); !errors.Is(err, errChange) {
+		t.Errorf("got %v, want %v", err, errChange)
+	}
+}
@@ -0,0 +1,113 @@
+package wireguard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Backend configures Wireguard devices. WgClient works with any of them:
+// the kernel one (wgctrl), UAPI sockets of userspace implementations,
+// the in-process Userspace device or a Fake in tests.
+type Backend interface {
+	Device(name string) (*wgtypes.Device, error)
+	ConfigureDevice(name string, cfg wgtypes.Config) error
+	Close() error
+}
+
+// NewKernelBackend controls devices through wgctrl, i.e. kernel interfaces
+// and userspace ones with a socket in the default directory (/var/run/wireguard).
+func NewKernelBackend() (Backend, error) {
+	return wgctrl.New()
+}
+
+// DefaultUAPIDir is where userspace implementations (e.g. wireguard-go, boringtun)
+// create their sockets by default.
+const DefaultUAPIDir = "/var/run/wireguard"
+
+const uapiTimeout = 5 * time.Second
+
+// UAPIBackend talks to userspace implementations through the UAPI sockets
+// in a directory, which may differ from the default one (e.g. when running without root).
+type UAPIBackend struct {
+	dir string
+}
+
+func NewUAPIBackend(dir string) *UAPIBackend {
+	if dir == "" {
+		dir = DefaultUAPIDir
+	}
+	return &UAPIBackend{dir: dir}
+}
+
+func (b *UAPIBackend) dial(name string) (net.Conn, error) {
+	conn, err := net.DialTimeout("unix", filepath.Join(b.dir, name+".sock"), uapiTimeout)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", name, os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(uapiTimeout))
+	return conn, nil
+}
+
+// request sends an operation and returns the response without the errno line.
+func (b *UAPIBackend) request(name, op string) (string, error) {
+	conn, err := b.dial(name)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := io.WriteString(conn, op+"\n"); err != nil {
+		return "", err
+	}
+
+	// the response ends with an empty line
+	var resp strings.Builder
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("error reading UAPI response of %s: %w", name, err)
+		}
+		if line == "\n" {
+			break
+		}
+		if errno, ok := strings.CutPrefix(line, "errno="); ok {
+			if errno = strings.TrimSpace(errno); errno != "0" {
+				return "", fmt.Errorf("UAPI error %s", errno)
+			}
+			continue
+		}
+		resp.WriteString(line)
+	}
+	return resp.String(), nil
+}
+
+func (b *UAPIBackend) Device(name string) (*wgtypes.Device, error) {
+	resp, err := b.request(name, "get=1\n")
+	if err != nil {
+		return nil, err
+	}
+	return parseUAPIGet(name, resp)
+}
+
+func (b *UAPIBackend) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	_, err := b.request(name, "set=1\n"+uapiSet(cfg))
+	return err
+}
+
+func (b *UAPIBackend) Close() error {
+	return nil
+}
@@ -0,0 +1,215 @@
+package wireguard
+
+import (
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Fake is an in-memory Backend for tests. It records the configuration
+// requests and applies them to its devices like a real implementation would.
+// Handshakes happen only when simulated, either explicitly by Handshake
+// or automatically for endpoints marked as reachable.
+type Fake struct {
+	mu        sync.Mutex
+	devices   map[string]*wgtypes.Device
+	configs   []FakeConfig
+	reachable map[string]bool
+	// returned by the next ConfigureDevice, e.g. to test rollbacks
+	failNext error
+}
+
+// FakeConfig is a configuration request received by Fake.
+type FakeConfig struct {
+	Name   string
+	Config wgtypes.Config
+}
+
+// NewFake returns a backend with empty devices of the names.
+func NewFake(names ...string) *Fake {
+	f := &Fake{
+		devices:   map[string]*wgtypes.Device{},
+		reachable: map[string]bool{},
+	}
+	for _, name := range names {
+		f.devices[name] = &wgtypes.Device{Name: name, Type: wgtypes.Userspace}
+	}
+	return f
+}
+
+// NewFakeClient returns a client of the fake device, which is created if needed.
+func NewFakeClient(f *Fake, name string) *WgClient {
+	f.mu.Lock()
+	if f.devices[name] == nil {
+		f.devices[name] = &wgtypes.Device{Name: name, Type: wgtypes.Userspace}
+	}
+	f.mu.Unlock()
+	return NewWgClientWithBackend(f, name)
+}
+
+func cloneDevice(dev *wgtypes.Device) *wgtypes.Device {
+	d := *dev
+	d.Peers = slices.Clone(dev.Peers)
+	for i := range d.Peers {
+		d.Peers[i].AllowedIPs = slices.Clone(d.Peers[i].AllowedIPs)
+	}
+	return &d
+}
+
+func (f *Fake) Device(name string) (*wgtypes.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dev, ok := f.devices[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return cloneDevice(dev), nil
+}
+
+func (f *Fake) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dev, ok := f.devices[name]
+	if !ok {
+		return os.ErrNotExist
+	}
+	f.configs = append(f.configs, FakeConfig{Name: name, Config: cfg})
+	if err := f.failNext; err != nil {
+		f.failNext = nil
+		return err
+	}
+
+	applyConfig(dev, cfg)
+	for _, pc := range cfg.Peers {
+		if pc.Endpoint != nil && !pc.Remove && f.reachable[pc.Endpoint.String()] {
+			handshake(dev, pc.PublicKey)
+		}
+	}
+	return nil
+}
+
+func (f *Fake) Close() error {
+	return nil
+}
+
+// Configs returns the configuration requests received so far.
+func (f *Fake) Configs() []FakeConfig {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.configs)
+}
+
+// Reset forgets the recorded configuration requests.
+func (f *Fake) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.configs = nil
+}
+
+// FailNext makes the next ConfigureDevice fail with err without applying it.
+func (f *Fake) FailNext(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failNext = err
+}
+
+// SetReachable marks endpoints (ip:port) with which peers complete
+// a handshake as soon as they are configured.
+func (f *Fake) SetReachable(endpoints ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range endpoints {
+		f.reachable[e] = true
+	}
+}
+
+// Handshake simulates a handshake with the peer of the device.
+// It reports whether the peer exists.
+func (f *Fake) Handshake(name string, peer wgtypes.Key) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	dev, ok := f.devices[name]
+	if !ok {
+		return false
+	}
+	return handshake(dev, peer)
+}
+
+func handshake(dev *wgtypes.Device, peer wgtypes.Key) bool {
+	for i := range dev.Peers {
+		if p := &dev.Peers[i]; p.PublicKey == peer {
+			p.LastHandshakeTime = time.Now()
+			// handshake response and a keepalive
+			p.ReceiveBytes += 92 + 32
+			p.TransmitBytes += 148 + 32
+			return true
+		}
+	}
+	return false
+}
+
+// applyConfig changes the device the way Wireguard implementations do.
+func applyConfig(dev *wgtypes.Device, cfg wgtypes.Config) {
+	if cfg.PrivateKey != nil {
+		dev.PrivateKey = *cfg.PrivateKey
+		dev.PublicKey = cfg.PrivateKey.PublicKey()
+	}
+	if cfg.ListenPort != nil {
+		dev.ListenPort = *cfg.ListenPort
+	}
+	if cfg.FirewallMark != nil {
+		dev.FirewallMark = *cfg.FirewallMark
+	}
+	if cfg.ReplacePeers {
+		dev.Peers = nil
+	}
+
+	for _, pc := range cfg.Peers {
+		i := slices.IndexFunc(dev.Peers, func(p wgtypes.Peer) bool {
+			return p.PublicKey == pc.PublicKey
+		})
+		switch {
+		case pc.Remove:
+			if i >= 0 {
+				dev.Peers = slices.Delete(dev.Peers, i, i+1)
+			}
+			continue
+		case i < 0 && pc.UpdateOnly:
+			continue
+		case i < 0:
+			dev.Peers = append(dev.Peers, wgtypes.Peer{PublicKey: pc.PublicKey, ProtocolVersion: 1})
+			i = len(dev.Peers) - 1
+		}
+
+		p := &dev.Peers[i]
+		if pc.PresharedKey != nil {
+			p.PresharedKey = *pc.PresharedKey
+		}
+		if pc.Endpoint != nil {
+			e := *pc.Endpoint
+			p.Endpoint = &e
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			p.PersistentKeepaliveInterval = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			p.AllowedIPs = nil
+		}
+		for _, ipNet := range pc.AllowedIPs {
+			// an allowed IP belongs to a single peer
+			for j := range dev.Peers {
+				dev.Peers[j].AllowedIPs = slices.DeleteFunc(dev.Peers[j].AllowedIPs, func(n net.IPNet) bool {
+					return n.String() == ipNet.String()
+				})
+			}
+			p.AllowedIPs = append(p.AllowedIPs, ipNet)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// LoadOrGenerateKey reads a base64 private key (as written by wg genkey) from path.
+// If the file does not exist, a new key is generated and saved there.
+func LoadOrGenerateKey(path string) (wgtypes.Key, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := wgtypes.ParseKey(strings.TrimSpace(string(data)))
+		if err != nil {
+			return wgtypes.Key{}, fmt.Errorf("invalid private key in %s: %w", path, err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return wgtypes.Key{}, err
+	}
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return wgtypes.Key{}, err
+	}
+	// O_EXCL: don't overwrite a key created in the meantime
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	if _, err := fmt.Fprintln(f, key.String()); err != nil {
+		f.Close()
+		return wgtypes.Key{}, err
+	}
+	return key, f.Close()
+}
+
+func (wg *WgClient) SetPrivateKey(key wgtypes.Key) error {
+	return wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
+		PrivateKey: &key,
+	})
+}
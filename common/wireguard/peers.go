@@ -0,0 +1,322 @@
+package wireguard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+var (
+	ErrPeerNotFound = errors.New("peer not found")
+	ErrPeerExists   = errors.New("peer already exists")
+)
+
+// PeerSpec is the desired configuration of a peer.
+type PeerSpec struct {
+	PublicKey string
+	// empty means no preshared key
+	PresharedKey string
+	// host:port, empty keeps the current endpoint (e.g. one set by a traversal)
+	Endpoint   string
+	AllowedIPs []string
+	Keepalive  time.Duration
+}
+
+// peerConfig is a parsed PeerSpec.
+type peerConfig struct {
+	publicKey    wgtypes.Key
+	presharedKey wgtypes.Key
+	endpoint     *net.UDPAddr
+	allowedIPs   []net.IPNet
+	keepalive    time.Duration
+}
+
+func (s PeerSpec) parse() (*peerConfig, error) {
+	pc := &peerConfig{keepalive: s.Keepalive}
+
+	var err error
+	pc.publicKey, err = wgtypes.ParseKey(s.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %q: %w", s.PublicKey, err)
+	}
+	if s.PresharedKey != "" {
+		pc.presharedKey, err = wgtypes.ParseKey(s.PresharedKey)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: invalid preshared key: %w", s.PublicKey, err)
+		}
+	}
+	if s.Endpoint != "" {
+		pc.endpoint, err = net.ResolveUDPAddr("udp", s.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: invalid endpoint: %w", s.PublicKey, err)
+		}
+	}
+	for _, cidr := range s.AllowedIPs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("peer %s: invalid allowed IP: %w", s.PublicKey, err)
+		}
+		pc.allowedIPs = append(pc.allowedIPs, *ipNet)
+	}
+	return pc, nil
+}
+
+// config replaces the whole configuration of the peer,
+// except for an endpoint which is not specified.
+func (pc *peerConfig) config() wgtypes.PeerConfig {
+	presharedKey := pc.presharedKey
+	keepalive := pc.keepalive
+	return wgtypes.PeerConfig{
+		PublicKey:                   pc.publicKey,
+		PresharedKey:                &presharedKey,
+		Endpoint:                    pc.endpoint,
+		PersistentKeepaliveInterval: &keepalive,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  pc.allowedIPs,
+	}
+}
+
+func ipNetStrings(nets []net.IPNet) []string {
+	s := make([]string, 0, len(nets))
+	for _, n := range nets {
+		s = append(s, n.String())
+	}
+	slices.Sort(s)
+	return s
+}
+
+// matches reports whether the peer is configured as specified.
+func (pc *peerConfig) matches(p *wgtypes.Peer) bool {
+	if pc.presharedKey != p.PresharedKey || pc.keepalive != p.PersistentKeepaliveInterval {
+		return false
+	}
+	if pc.endpoint != nil && (p.Endpoint == nil || pc.endpoint.String() != p.Endpoint.String()) {
+		return false
+	}
+	return slices.Equal(ipNetStrings(pc.allowedIPs), ipNetStrings(p.AllowedIPs))
+}
+
+func (wg *WgClient) AddPeer(spec PeerSpec) error {
+	pc, err := spec.parse()
+	if err != nil {
+		return err
+	}
+	if _, err := wg.FindPeerByPublicKey(spec.PublicKey); err == nil {
+		return ErrPeerExists
+	} else if !errors.Is(err, ErrPeerNotFound) {
+		return err
+	}
+
+	return wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{pc.config()},
+	})
+}
+
+// UpdatePeer replaces the configuration of an existing peer.
+func (wg *WgClient) UpdatePeer(spec PeerSpec) error {
+	pc, err := spec.parse()
+	if err != nil {
+		return err
+	}
+	if _, err := wg.FindPeerByPublicKey(spec.PublicKey); err != nil {
+		return err
+	}
+
+	cfg := pc.config()
+	cfg.UpdateOnly = true
+	return wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{cfg},
+	})
+}
+
+func (wg *WgClient) RemovePeer(peerPubKey string) error {
+	pubKey, err := wgtypes.ParseKey(peerPubKey)
+	if err != nil {
+		return err
+	}
+	if _, err := wg.FindPeerByPublicKey(peerPubKey); err != nil {
+		return err
+	}
+
+	return wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey: pubKey,
+			Remove:    true,
+		}},
+	})
+}
+
+func (wg *WgClient) FindPeerByPublicKey(peerPubKey string) (wgtypes.Peer, error) {
+	peers, err := wg.GetPeers()
+	if err != nil {
+		return wgtypes.Peer{}, err
+	}
+
+	for _, p := range peers {
+		if p.PublicKey.String() == peerPubKey {
+			return p, nil
+		}
+	}
+	return wgtypes.Peer{}, ErrPeerNotFound
+}
+
+// FindPeerByAllowedIP returns the peer which packets to ip are routed to,
+// i.e. the one with the most specific allowed IP range containing it.
+func (wg *WgClient) FindPeerByAllowedIP(ip string) (wgtypes.Peer, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return wgtypes.Peer{}, fmt.Errorf("invalid IP address %q", ip)
+	}
+	peers, err := wg.GetPeers()
+	if err != nil {
+		return wgtypes.Peer{}, err
+	}
+
+	best, bestOnes := -1, -1
+	for i, p := range peers {
+		for _, n := range p.AllowedIPs {
+			if ones, _ := n.Mask.Size(); n.Contains(addr) && ones > bestOnes {
+				best, bestOnes = i, ones
+			}
+		}
+	}
+	if best < 0 {
+		return wgtypes.Peer{}, ErrPeerNotFound
+	}
+	return peers[best], nil
+}
+
+// FindPeerByEndpoint returns the peer with the endpoint (ip:port).
+func (wg *WgClient) FindPeerByEndpoint(endpoint string) (wgtypes.Peer, error) {
+	addr, err := net.ResolveUDPAddr("udp", endpoint)
+	if err != nil {
+		return wgtypes.Peer{}, err
+	}
+	peers, err := wg.GetPeers()
+	if err != nil {
+		return wgtypes.Peer{}, err
+	}
+
+	for _, p := range peers {
+		if p.Endpoint != nil && p.Endpoint.IP.Equal(addr.IP) && p.Endpoint.Port == addr.Port {
+			return p, nil
+		}
+	}
+	return wgtypes.Peer{}, ErrPeerNotFound
+}
+
+// PeerDiff lists the changes which turn the device peers into the desired ones.
+type PeerDiff struct {
+	Add    []PeerSpec
+	Update []PeerSpec
+	Remove []string
+}
+
+func (d *PeerDiff) Empty() bool {
+	return len(d.Add) == 0 && len(d.Update) == 0 && len(d.Remove) == 0
+}
+
+// DiffPeers compares the current peers of a device with the desired ones.
+func DiffPeers(current []wgtypes.Peer, desired []PeerSpec) (*PeerDiff, error) {
+	diff := &PeerDiff{}
+	wanted := map[wgtypes.Key]bool{}
+
+	for _, spec := range desired {
+		pc, err := spec.parse()
+		if err != nil {
+			return nil, err
+		}
+		if wanted[pc.publicKey] {
+			return nil, fmt.Errorf("duplicate peer %s", spec.PublicKey)
+		}
+		wanted[pc.publicKey] = true
+
+		i := slices.IndexFunc(current, func(p wgtypes.Peer) bool {
+			return p.PublicKey == pc.publicKey
+		})
+		switch {
+		case i < 0:
+			diff.Add = append(diff.Add, spec)
+		case !pc.matches(&current[i]):
+			diff.Update = append(diff.Update, spec)
+		}
+	}
+
+	for _, p := range current {
+		if !wanted[p.PublicKey] {
+			diff.Remove = append(diff.Remove, p.PublicKey.String())
+		}
+	}
+	return diff, nil
+}
+
+// Diff compares the peers of the device with the desired ones.
+func (wg *WgClient) Diff(desired []PeerSpec) (*PeerDiff, error) {
+	peers, err := wg.GetPeers()
+	if err != nil {
+		return nil, err
+	}
+	return DiffPeers(peers, desired)
+}
+
+// Reconcile makes the peers of the device match the desired ones
+// in a single configuration request and returns what was changed.
+// Unspecified endpoints of existing peers are kept.
+func (wg *WgClient) Reconcile(desired []PeerSpec) (*PeerDiff, error) {
+	return wg.apply(desired, true)
+}
+
+// Merge is like Reconcile but keeps the peers which are not desired,
+// for devices shared with other tools.
+func (wg *WgClient) Merge(desired []PeerSpec) (*PeerDiff, error) {
+	return wg.apply(desired, false)
+}
+
+func (wg *WgClient) apply(desired []PeerSpec, remove bool) (*PeerDiff, error) {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+
+	diff, err := wg.Diff(desired)
+	if err != nil {
+		return nil, err
+	}
+	if !remove {
+		diff.Remove = nil
+	}
+	if diff.Empty() {
+		return diff, nil
+	}
+
+	cfg, err := diff.Config()
+	if err != nil {
+		return nil, err
+	}
+	if err := wg.client.ConfigureDevice(wg.iface, cfg); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// Config turns the diff into a device configuration request.
+func (d *PeerDiff) Config() (wgtypes.Config, error) {
+	var cfg wgtypes.Config
+	for _, spec := range append(slices.Clone(d.Add), d.Update...) {
+		pc, err := spec.parse()
+		if err != nil {
+			return wgtypes.Config{}, err
+		}
+		cfg.Peers = append(cfg.Peers, pc.config())
+	}
+	for _, key := range d.Remove {
+		pubKey, err := wgtypes.ParseKey(key)
+		if err != nil {
+			return wgtypes.Config{}, err
+		}
+		cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{PublicKey: pubKey, Remove: true})
+	}
+	return cfg, nil
+}
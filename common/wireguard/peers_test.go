@@ -0,0 +1,265 @@
+package wireguard
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func mustCIDR(t *testing.T, cidr string) net.IPNet {
+	t.Helper()
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *n
+}
+
+func TestDiffPeers(t *testing.T) {
+	a, b, c := newPeerKey(t), newPeerKey(t), newPeerKey(t)
+	current := []wgtypes.Peer{
+		{
+			PublicKey:                   a,
+			Endpoint:                    &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 51820},
+			PersistentKeepaliveInterval: DefaultKeepalive,
+			AllowedIPs:                  []net.IPNet{mustCIDR(t, "10.0.0.2/32"), mustCIDR(t, "10.1.0.0/16")},
+		},
+		{
+			PublicKey:  b,
+			AllowedIPs: []net.IPNet{mustCIDR(t, "10.0.0.3/32")},
+		},
+	}
+	specA := PeerSpec{PublicKey: a.String(), AllowedIPs: []string{"10.1.0.0/16", "10.0.0.2/32"}, Keepalive: DefaultKeepalive}
+	specB := PeerSpec{PublicKey: b.String(), AllowedIPs: []string{"10.0.0.3/32"}}
+	specC := PeerSpec{PublicKey: c.String(), AllowedIPs: []string{"10.0.0.4/32"}}
+
+	withEndpoint := func(s PeerSpec, e string) PeerSpec {
+		s.Endpoint = e
+		return s
+	}
+	withKeepalive := func(s PeerSpec, k time.Duration) PeerSpec {
+		s.Keepalive = k
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		desired []PeerSpec
+		want    PeerDiff
+	}{
+		{"unchanged", []PeerSpec{specB, specA}, PeerDiff{}},
+		// an unspecified endpoint keeps the one set by a traversal
+		{"endpoint kept", []PeerSpec{specA, specB}, PeerDiff{}},
+		{"same endpoint", []PeerSpec{withEndpoint(specA, "192.0.2.1:51820"), specB}, PeerDiff{}},
+		{"endpoint changed", []PeerSpec{withEndpoint(specA, "192.0.2.9:51820"), specB}, PeerDiff{Update: []PeerSpec{withEndpoint(specA, "192.0.2.9:51820")}}},
+		{"endpoint added", []PeerSpec{specA, withEndpoint(specB, "192.0.2.3:51820")}, PeerDiff{Update: []PeerSpec{withEndpoint(specB, "192.0.2.3:51820")}}},
+		{"keepalive changed", []PeerSpec{withKeepalive(specA, MinKeepalive), specB}, PeerDiff{Update: []PeerSpec{withKeepalive(specA, MinKeepalive)}}},
+		{"add", []PeerSpec{specA, specB, specC}, PeerDiff{Add: []PeerSpec{specC}}},
+		{"remove", []PeerSpec{specB}, PeerDiff{Remove: []string{a.String()}}},
+		{"all", []PeerSpec{withKeepalive(specB, MinKeepalive), specC}, PeerDiff{
+			Add:    []PeerSpec{specC},
+			Update: []PeerSpec{withKeepalive(specB, MinKeepalive)},
+			Remove: []string{a.String()},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff, err := DiffPeers(current, tt.desired)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalSpecs(diff.Add, tt.want.Add) || !equalSpecs(diff.Update, tt.want.Update) || !slices.Equal(diff.Remove, tt.want.Remove) {
+				t.Errorf("got %+v, want %+v", *diff, tt.want)
+			}
+			if diff.Empty() != tt.want.Empty() {
+				t.Errorf("Empty() = %v", diff.Empty())
+			}
+		})
+	}
+}
+
+func equalSpecs(a, b []PeerSpec) bool {
+	return slices.EqualFunc(a, b, func(x, y PeerSpec) bool {
+		return x.PublicKey == y.PublicKey && x.PresharedKey == y.PresharedKey && x.Endpoint == y.Endpoint &&
+			x.Keepalive == y.Keepalive && slices.Equal(x.AllowedIPs, y.AllowedIPs)
+	})
+}
+
+func TestDiffPeersInvalid(t *testing.T) {
+	a := newPeerKey(t)
+	tests := []struct {
+		name    string
+		desired []PeerSpec
+	}{
+		{"duplicate key", []PeerSpec{{PublicKey: a.String()}, {PublicKey: a.String(), Keepalive: MinKeepalive}}},
+		{"invalid key", []PeerSpec{{PublicKey: "peer"}}},
+		{"invalid allowed IP", []PeerSpec{{PublicKey: a.String(), AllowedIPs: []string{"10.0.0.1"}}}},
+		{"invalid endpoint", []PeerSpec{{PublicKey: a.String(), Endpoint: "192.0.2.1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff, err := DiffPeers(nil, tt.desired); err == nil {
+				t.Errorf("got %+v, want an error", *diff)
+			}
+		})
+	}
+}
+
+func TestPeerDiffConfig(t *testing.T) {
+	a, b, c := newPeerKey(t), newPeerKey(t), newPeerKey(t)
+	diff := PeerDiff{
+		Add:    []PeerSpec{{PublicKey: a.String(), Endpoint: "192.0.2.1:51820", AllowedIPs: []string{"10.0.0.2/32"}}},
+		Update: []PeerSpec{{PublicKey: b.String(), AllowedIPs: []string{"10.0.0.3/32"}}},
+		Remove: []string{c.String()},
+	}
+	cfg, err := diff.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cfg.Peers) != 3 {
+		t.Fatalf("got %d peers, want 3", len(cfg.Peers))
+	}
+	added, updated, removed := cfg.Peers[0], cfg.Peers[1], cfg.Peers[2]
+	if added.PublicKey != a || added.Endpoint == nil || added.Endpoint.String() != "192.0.2.1:51820" || !added.ReplaceAllowedIPs {
+		t.Errorf("added %+v", added)
+	}
+	if updated.PublicKey != b || updated.Endpoint != nil || !updated.ReplaceAllowedIPs || updated.Remove {
+		t.Errorf("updated %+v", updated)
+	}
+	if removed.PublicKey != c || !removed.Remove {
+		t.Errorf("removed %+v", removed)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	f, wg, a := newTraversalDevice(t)
+	b, c := newPeerKey(t), newPeerKey(t)
+	if err := wg.AddPeer(PeerSpec{PublicKey: b.String(), AllowedIPs: []string{"10.0.0.3/32"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.Reset()
+
+	desired := []PeerSpec{
+		{PublicKey: a.String(), AllowedIPs: []string{"10.0.0.2/32", "10.2.0.0/16"}, Keepalive: DefaultKeepalive},
+		{PublicKey: c.String(), AllowedIPs: []string{"10.0.0.4/32"}},
+	}
+	diff, err := wg.Reconcile(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Add) != 1 || len(diff.Update) != 1 || len(diff.Remove) != 1 {
+		t.Fatalf("got %+v", *diff)
+	}
+	if n := len(f.Configs()); n != 1 {
+		t.Errorf("got %d configuration requests, want 1", n)
+	}
+
+	if _, err := wg.FindPeerByPublicKey(b.String()); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("removed peer: %v", err)
+	}
+	if _, err := wg.FindPeerByPublicKey(c.String()); err != nil {
+		t.Errorf("added peer: %v", err)
+	}
+	// the endpoint set by a traversal survives the update
+	checkDevice(t, wg, a, 51820, "192.0.2.1:51820", DefaultKeepalive)
+
+	f.Reset()
+	diff, err = wg.Reconcile(desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() || len(f.Configs()) != 0 {
+		t.Errorf("reconciled again: %+v, %d configuration requests", *diff, len(f.Configs()))
+	}
+}
+
+func TestMerge(t *testing.T) {
+	f, wg, a := newTraversalDevice(t)
+	b, c := newPeerKey(t), newPeerKey(t)
+	if err := wg.AddPeer(PeerSpec{PublicKey: b.String(), AllowedIPs: []string{"10.0.0.3/32"}}); err != nil {
+		t.Fatal(err)
+	}
+	f.Reset()
+
+	diff, err := wg.Merge([]PeerSpec{
+		{PublicKey: a.String(), AllowedIPs: []string{"10.0.0.2/32", "10.2.0.0/16"}, Keepalive: DefaultKeepalive},
+		{PublicKey: c.String(), AllowedIPs: []string{"10.0.0.4/32"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Add) != 1 || len(diff.Update) != 1 || len(diff.Remove) != 0 {
+		t.Fatalf("got %+v", *diff)
+	}
+	if n := len(f.Configs()); n != 1 {
+		t.Errorf("got %d configuration requests, want 1", n)
+	}
+	for _, key := range []wgtypes.Key{b, c} {
+		if _, err := wg.FindPeerByPublicKey(key.String()); err != nil {
+			t.Errorf("peer %s: %v", key, err)
+		}
+	}
+
+	// nothing but peers to keep
+	f.Reset()
+	diff, err = wg.Merge(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Empty() || len(f.Configs()) != 0 {
+		t.Errorf("got %+v, %d configuration requests", *diff, len(f.Configs()))
+	}
+}
+
+func TestFindPeerByAllowedIP(t *testing.T) {
+	f := NewFake("wg0")
+	wg := NewFakeClient(f, "wg0")
+	gateway, site, host := newPeerKey(t), newPeerKey(t), newPeerKey(t)
+	for _, spec := range []PeerSpec{
+		{PublicKey: gateway.String(), AllowedIPs: []string{"0.0.0.0/0", "::/0"}},
+		{PublicKey: site.String(), AllowedIPs: []string{"10.1.0.0/16"}},
+		{PublicKey: host.String(), AllowedIPs: []string{"10.1.2.3/32", "fd00::3/128"}},
+	} {
+		if err := wg.AddPeer(spec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		ip   string
+		want wgtypes.Key
+	}{
+		{"10.1.2.3", host},
+		{"10.1.2.4", site},
+		{"10.2.0.1", gateway},
+		{"fd00::3", host},
+		{"fd00::4", gateway},
+	}
+	for _, tt := range tests {
+		p, err := wg.FindPeerByAllowedIP(tt.ip)
+		if err != nil {
+			t.Errorf("%s: %v", tt.ip, err)
+			continue
+		}
+		if p.PublicKey != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.ip, p.PublicKey, tt.want)
+		}
+	}
+
+	if err := wg.RemovePeer(gateway.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wg.FindPeerByAllowedIP("10.2.0.1"); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("got %v, want %v", err, ErrPeerNotFound)
+	}
+	if _, err := wg.FindPeerByAllowedIP("10.2.0"); err == nil {
+		t.Error("invalid IP accepted")
+	}
+}
@@ -0,0 +1,163 @@
+package wireguard
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// The cross-platform userspace API of Wireguard, see https://www.wireguard.com/xplatform/
+
+func hexKey(k wgtypes.Key) string {
+	return hex.EncodeToString(k[:])
+}
+
+func parseHexKey(s string) (wgtypes.Key, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+	return wgtypes.NewKey(b)
+}
+
+// uapiSet encodes the configuration as a set operation (without the set=1 line).
+func uapiSet(cfg wgtypes.Config) string {
+	var b strings.Builder
+	if cfg.PrivateKey != nil {
+		fmt.Fprintf(&b, "private_key=%s\n", hexKey(*cfg.PrivateKey))
+	}
+	if cfg.ListenPort != nil {
+		fmt.Fprintf(&b, "listen_port=%d\n", *cfg.ListenPort)
+	}
+	if cfg.FirewallMark != nil {
+		fmt.Fprintf(&b, "fwmark=%d\n", *cfg.FirewallMark)
+	}
+	if cfg.ReplacePeers {
+		b.WriteString("replace_peers=true\n")
+	}
+
+	for _, p := range cfg.Peers {
+		fmt.Fprintf(&b, "public_key=%s\n", hexKey(p.PublicKey))
+		if p.Remove {
+			b.WriteString("remove=true\n")
+			continue
+		}
+		if p.UpdateOnly {
+			b.WriteString("update_only=true\n")
+		}
+		if p.PresharedKey != nil {
+			fmt.Fprintf(&b, "preshared_key=%s\n", hexKey(*p.PresharedKey))
+		}
+		if p.Endpoint != nil {
+			fmt.Fprintf(&b, "endpoint=%s\n", p.Endpoint.String())
+		}
+		if p.PersistentKeepaliveInterval != nil {
+			fmt.Fprintf(&b, "persistent_keepalive_interval=%d\n", int(p.PersistentKeepaliveInterval.Seconds()))
+		}
+		if p.ReplaceAllowedIPs {
+			b.WriteString("replace_allowed_ips=true\n")
+		}
+		for _, ipNet := range p.AllowedIPs {
+			fmt.Fprintf(&b, "allowed_ip=%s\n", ipNet.String())
+		}
+	}
+	return b.String()
+}
+
+// parseUAPIGet decodes the response of a get operation.
+func parseUAPIGet(name, s string) (*wgtypes.Device, error) {
+	dev := &wgtypes.Device{Name: name, Type: wgtypes.Userspace}
+	var peer *wgtypes.Peer
+	var handshakeSec, handshakeNsec int64
+
+	// the handshake time is split into two keys
+	finishPeer := func() {
+		if peer == nil {
+			return
+		}
+		if handshakeSec != 0 || handshakeNsec != 0 {
+			peer.LastHandshakeTime = time.Unix(handshakeSec, handshakeNsec)
+		}
+		dev.Peers = append(dev.Peers, *peer)
+		peer, handshakeSec, handshakeNsec = nil, 0, 0
+	}
+
+	sc := bufio.NewScanner(strings.NewReader(s))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid UAPI line %q", line)
+		}
+
+		var err error
+		switch key {
+		case "errno":
+			if value != "0" {
+				return nil, fmt.Errorf("UAPI error %s", value)
+			}
+		case "private_key":
+			dev.PrivateKey, err = parseHexKey(value)
+			dev.PublicKey = dev.PrivateKey.PublicKey()
+		case "listen_port":
+			dev.ListenPort, err = strconv.Atoi(value)
+		case "fwmark":
+			dev.FirewallMark, err = strconv.Atoi(value)
+		case "public_key":
+			finishPeer()
+			peer = &wgtypes.Peer{}
+			peer.PublicKey, err = parseHexKey(value)
+		default:
+			if peer == nil {
+				// unknown device keys are ignored
+				continue
+			}
+			err = parsePeerKey(peer, key, value, &handshakeSec, &handshakeNsec)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid UAPI value of %s: %w", key, err)
+		}
+	}
+	finishPeer()
+	return dev, sc.Err()
+}
+
+func parsePeerKey(peer *wgtypes.Peer, key, value string, handshakeSec, handshakeNsec *int64) error {
+	var err error
+	switch key {
+	case "preshared_key":
+		peer.PresharedKey, err = parseHexKey(value)
+	case "endpoint":
+		peer.Endpoint, err = net.ResolveUDPAddr("udp", value)
+	case "persistent_keepalive_interval":
+		var sec int
+		sec, err = strconv.Atoi(value)
+		peer.PersistentKeepaliveInterval = time.Duration(sec) * time.Second
+	case "last_handshake_time_sec":
+		*handshakeSec, err = strconv.ParseInt(value, 10, 64)
+	case "last_handshake_time_nsec":
+		*handshakeNsec, err = strconv.ParseInt(value, 10, 64)
+	case "tx_bytes":
+		peer.TransmitBytes, err = strconv.ParseInt(value, 10, 64)
+	case "rx_bytes":
+		peer.ReceiveBytes, err = strconv.ParseInt(value, 10, 64)
+	case "protocol_version":
+		peer.ProtocolVersion, err = strconv.Atoi(value)
+	case "allowed_ip":
+		var ipNet *net.IPNet
+		_, ipNet, err = net.ParseCIDR(value)
+		if err == nil {
+			peer.AllowedIPs = append(peer.AllowedIPs, *ipNet)
+		}
+	}
+	return err
+}
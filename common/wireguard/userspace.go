@@ -0,0 +1,80 @@
+package wireguard
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/netstack"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Userspace is a Wireguard device running in-process (wireguard-go)
+// on top of a userspace network stack (gVisor netstack), so it needs no privileges.
+// The tunnel is not visible to the system, it is only reachable through Net.
+type Userspace struct {
+	name string
+	dev  *device.Device
+	tnet *netstack.Net
+}
+
+// NewUserspace starts a device with the tunnel addresses and DNS servers used inside the tunnel.
+func NewUserspace(name string, addresses, dns []netip.Addr, mtu int, logger *device.Logger) (*Userspace, error) {
+	if mtu == 0 {
+		mtu = device.DefaultMTU
+	}
+	if logger == nil {
+		logger = device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", name))
+	}
+
+	tunDev, tnet, err := netstack.CreateNetTUN(addresses, dns, mtu)
+	if err != nil {
+		return nil, err
+	}
+	dev := device.NewDevice(tunDev, conn.NewDefaultBind(), logger)
+	if err := dev.Up(); err != nil {
+		dev.Close()
+		return nil, err
+	}
+
+	return &Userspace{
+		name: name,
+		dev:  dev,
+		tnet: tnet,
+	}, nil
+}
+
+// NewUserspaceClient returns a client of the userspace device.
+func NewUserspaceClient(u *Userspace) *WgClient {
+	return NewWgClientWithBackend(u, u.name)
+}
+
+func (u *Userspace) Device(name string) (*wgtypes.Device, error) {
+	if name != u.name {
+		return nil, os.ErrNotExist
+	}
+	s, err := u.dev.IpcGet()
+	if err != nil {
+		return nil, err
+	}
+	return parseUAPIGet(name, s)
+}
+
+func (u *Userspace) ConfigureDevice(name string, cfg wgtypes.Config) error {
+	if name != u.name {
+		return os.ErrNotExist
+	}
+	return u.dev.IpcSet(uapiSet(cfg))
+}
+
+// Net is the network of the tunnel, e.g. for dialing peers.
+func (u *Userspace) Net() *netstack.Net {
+	return u.tnet
+}
+
+func (u *Userspace) Close() error {
+	u.dev.Close()
+	return nil
+}
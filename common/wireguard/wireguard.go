@@ -1,29 +1,42 @@
 package wireguard
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 )
 
 type WgClient struct {
-	client *wgctrl.Client
+	client Backend
 	iface  string
+	// serializes changes and their rollbacks
+	mu sync.Mutex
 }
 
+// NewWgClient returns a client of a kernel interface (see NewKernelBackend).
 func NewWgClient(iface string) (*WgClient, error) {
-	client, err := wgctrl.New()
+	client, err := NewKernelBackend()
 	if err != nil {
 		return nil, err
 	}
+	return NewWgClientWithBackend(client, iface), nil
+}
+
+func NewWgClientWithBackend(b Backend, iface string) *WgClient {
 	return &WgClient{
-		client: client,
+		client: b,
 		iface:  iface,
-	}, nil
+	}
+}
+
+// Close releases the backend.
+func (c *WgClient) Close() error {
+	return c.client.Close()
 }
 
 func (c *WgClient) GetInterfacePublicKey() (string, error) {
@@ -35,6 +48,17 @@ func (c *WgClient) GetInterfacePublicKey() (string, error) {
 	return dev.PublicKey.String(), nil
 }
 
+// GetInterfacePrivateKey returns the private key of the interface,
+// which proves the identity of the peer to the rendezvous server.
+func (c *WgClient) GetInterfacePrivateKey() (wgtypes.Key, error) {
+	dev, err := c.client.Device(c.iface)
+	if err != nil {
+		return wgtypes.Key{}, err
+	}
+
+	return dev.PrivateKey, nil
+}
+
 func (c *WgClient) GetPeers() ([]wgtypes.Peer, error) {
 	dev, err := c.client.Device(c.iface)
 	if err != nil {
@@ -44,20 +68,6 @@ func (c *WgClient) GetPeers() ([]wgtypes.Peer, error) {
 	return dev.Peers, nil
 }
 
-// func (wg *WgClient) FindPeerByPublicKey(pubKey string) (wgtypes.Peer, error) {
-// 	dev, err := wg.client.Device(wg.iface)
-// 	if err != nil {
-// 		return wgtypes.Peer{}, err
-// 	}
-
-// 	for _, p := range dev.Peers {
-// 		if p.PublicKey.String() == pubKey {
-// 			return p, nil
-// 		}
-// 	}
-// 	return wgtypes.Peer{}, errors.New("peer not found")
-// }
-
 // returns peer's public key
 func (wg *WgClient) FindPeerByRemoteIP(remoteIP string) (string, error) {
 	dev, err := wg.client.Device(wg.iface)
@@ -66,11 +76,11 @@ func (wg *WgClient) FindPeerByRemoteIP(remoteIP string) (string, error) {
 	}
 
 	for _, p := range dev.Peers {
-		if p.Endpoint.IP.String() == remoteIP {
+		if p.Endpoint != nil && p.Endpoint.IP.String() == remoteIP {
 			return p.PublicKey.String(), nil
 		}
 	}
-	return "", errors.New("peer not found")
+	return "", ErrPeerNotFound
 }
 
 func (wg *WgClient) SetListenPort(listenPort int) error {
@@ -79,17 +89,25 @@ func (wg *WgClient) SetListenPort(listenPort int) error {
 	})
 }
 
+// DefaultKeepalive is the persistent keepalive interval set by SetPeerRemotePort.
+const DefaultKeepalive = 25 * time.Second
+
 func (wg *WgClient) SetPeerRemotePort(peerPubKey string, remoteIP string, remotePort int) error {
+	return wg.SetPeerEndpoint(peerPubKey, remoteIP, remotePort, DefaultKeepalive)
+}
+
+// SetPeerEndpoint sets the peer endpoint and persistent keepalive interval
+// which keeps the NAT mapping open.
+func (wg *WgClient) SetPeerEndpoint(peerPubKey string, remoteIP string, remotePort int, keepalive time.Duration) error {
 	pubKey, err := wgtypes.ParseKey(peerPubKey)
 	if err != nil {
 		return err
 	}
 
-	endpointUDPAddr, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", remoteIP, remotePort))
+	endpointUDPAddr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(remoteIP, fmt.Sprint(remotePort)))
 	if err != nil {
 		return err
 	}
-	keepalive := 25 * time.Second
 
 	return wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
 		Peers: []wgtypes.PeerConfig{{
@@ -99,3 +117,199 @@ func (wg *WgClient) SetPeerRemotePort(peerPubKey string, remoteIP string, remote
 		}},
 	})
 }
+
+// SetPeerKeepalive changes the persistent keepalive interval of the peer
+// and keeps its endpoint.
+func (wg *WgClient) SetPeerKeepalive(peerPubKey string, keepalive time.Duration) error {
+	pubKey, err := wgtypes.ParseKey(peerPubKey)
+	if err != nil {
+		return err
+	}
+
+	return wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:                   pubKey,
+			UpdateOnly:                  true,
+			PersistentKeepaliveInterval: &keepalive,
+		}},
+	})
+}
+
+// MinKeepalive is the shortest keepalive interval used for a measured mapping timeout.
+const MinKeepalive = 5 * time.Second
+
+const maxKeepalive = 65535 * time.Second
+
+// KeepaliveForMappingTimeout returns a keepalive interval just under
+// the NAT mapping timeout, leaving a margin for delays and lost packets.
+// Wireguard keepalives have a granularity of seconds.
+func KeepaliveForMappingTimeout(timeout time.Duration) time.Duration {
+	margin := max(timeout/5, 2*time.Second)
+	keepalive := (timeout - margin).Truncate(time.Second)
+	return min(max(keepalive, MinKeepalive), maxKeepalive)
+}
+
+// PeerState is the part of the peer configuration changed by traversals.
+type PeerState struct {
+	PublicKey wgtypes.Key
+	Endpoint  *net.UDPAddr
+	Keepalive time.Duration
+	// used to detect a working tunnel, not restored
+	LastHandshake time.Time
+	ReceiveBytes  int64
+}
+
+// Snapshot is the state of the device restored by Restore.
+type Snapshot struct {
+	ListenPort int
+	Peers      []PeerState
+}
+
+func (wg *WgClient) Snapshot() (*Snapshot, error) {
+	dev, err := wg.client.Device(wg.iface)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{ListenPort: dev.ListenPort}
+	for _, p := range dev.Peers {
+		s.Peers = append(s.Peers, PeerState{
+			PublicKey:     p.PublicKey,
+			Endpoint:      p.Endpoint,
+			Keepalive:     p.PersistentKeepaliveInterval,
+			LastHandshake: p.LastHandshakeTime,
+			ReceiveBytes:  p.ReceiveBytes,
+		})
+	}
+	return s, nil
+}
+
+func (s *Snapshot) peer(pubKey wgtypes.Key) (PeerState, bool) {
+	for _, p := range s.Peers {
+		if p.PublicKey == pubKey {
+			return p, true
+		}
+	}
+	return PeerState{}, false
+}
+
+// Restore sets the listen port, peer endpoints and keepalives from the snapshot.
+// Peers removed since the snapshot are not added back and an endpoint
+// which was not set is kept (Wireguard cannot unset it).
+func (wg *WgClient) Restore(s *Snapshot) error {
+	wg.mu.Lock()
+	defer wg.mu.Unlock()
+	return wg.restore(s)
+}
+
+func (wg *WgClient) restore(s *Snapshot) error {
+	cfg := wgtypes.Config{ListenPort: &s.ListenPort}
+	for _, p := range s.Peers {
+		keepalive := p.Keepalive
+		cfg.Peers = append(cfg.Peers, wgtypes.PeerConfig{
+			PublicKey:                   p.PublicKey,
+			UpdateOnly:                  true,
+			Endpoint:                    p.Endpoint,
+			PersistentKeepaliveInterval: &keepalive,
+		})
+	}
+	return wg.client.ConfigureDevice(wg.iface, cfg)
+}
+
+// ErrNoHandshake is returned by ApplyEndpoint when the tunnel does not come up.
+var ErrNoHandshake = errors.New("no handshake with the peer")
+
+// EndpointConfig is the result of a traversal to a peer.
+type EndpointConfig struct {
+	Peer       string
+	IP         string
+	Port       int
+	ListenPort int
+	Keepalive  time.Duration
+}
+
+// ApplyEndpoint sets the peer endpoint and the listen port in a single
+// configuration request. If verify is positive, it waits that long
+// for a handshake or any traffic from the peer.
+// The previous listen port and endpoint are restored when anything fails.
+func (wg *WgClient) ApplyEndpoint(ctx context.Context, c EndpointConfig, verify time.Duration) error {
+	pubKey, err := wgtypes.ParseKey(c.Peer)
+	if err != nil {
+		return err
+	}
+	endpoint, err := net.ResolveUDPAddr("udp", net.JoinHostPort(c.IP, fmt.Sprint(c.Port)))
+	if err != nil {
+		return err
+	}
+
+	wg.mu.Lock()
+	before, err := wg.Snapshot()
+	if err != nil {
+		wg.mu.Unlock()
+		return err
+	}
+	err = wg.client.ConfigureDevice(wg.iface, wgtypes.Config{
+		ListenPort: &c.ListenPort,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:                   pubKey,
+			UpdateOnly:                  true,
+			Endpoint:                    endpoint,
+			PersistentKeepaliveInterval: &c.Keepalive,
+		}},
+	})
+	if err != nil {
+		// a partially applied request is possible with userspace implementations
+		if rerr := wg.restore(before); rerr != nil {
+			err = fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+		}
+		wg.mu.Unlock()
+		return err
+	}
+	wg.mu.Unlock()
+
+	if verify <= 0 {
+		return nil
+	}
+	prev, _ := before.peer(pubKey)
+	err = wg.waitForPeer(ctx, pubKey, prev, verify)
+	if err != nil {
+		if rerr := wg.Restore(before); rerr != nil {
+			err = fmt.Errorf("%w (rollback failed: %v)", err, rerr)
+		}
+	}
+	return err
+}
+
+// waitForPeer polls the device until the peer handshakes or sends anything
+// since the state prev.
+func (wg *WgClient) waitForPeer(ctx context.Context, pubKey wgtypes.Key, prev PeerState, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		dev, err := wg.client.Device(wg.iface)
+		if err != nil {
+			return err
+		}
+		for _, p := range dev.Peers {
+			if p.PublicKey != pubKey {
+				continue
+			}
+			if p.LastHandshakeTime.After(prev.LastHandshake) || p.ReceiveBytes > prev.ReceiveBytes {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return ErrNoHandshake
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package wireguard
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func newPeerKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.PublicKey()
+}
+
+// newTraversalDevice returns a fake device with a peer reachable at a previous
+// endpoint, as found before a traversal.
+func newTraversalDevice(t *testing.T) (*Fake, *WgClient, wgtypes.Key) {
+	t.Helper()
+	f := NewFake("wg0")
+	wg := NewFakeClient(f, "wg0")
+	peer := newPeerKey(t)
+
+	if err := wg.SetListenPort(51820); err != nil {
+		t.Fatal(err)
+	}
+	if err := wg.AddPeer(PeerSpec{PublicKey: peer.String(), AllowedIPs: []string{"10.0.0.2/32"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wg.SetPeerRemotePort(peer.String(), "192.0.2.1", 51820); err != nil {
+		t.Fatal(err)
+	}
+	f.Reset()
+	return f, wg, peer
+}
+
+func checkDevice(t *testing.T, wg *WgClient, peer wgtypes.Key, listenPort int, endpoint string, keepalive time.Duration) {
+	t.Helper()
+	s, err := wg.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ListenPort != listenPort {
+		t.Errorf("listen port %d, want %d", s.ListenPort, listenPort)
+	}
+	p, ok := s.peer(peer)
+	if !ok {
+		t.Fatal("peer not found")
+	}
+	if p.Endpoint == nil || p.Endpoint.String() != endpoint {
+		t.Errorf("endpoint %v, want %s", p.Endpoint, endpoint)
+	}
+	if p.Keepalive != keepalive {
+		t.Errorf("keepalive %v, want %v", p.Keepalive, keepalive)
+	}
+}
+
+func TestApplyEndpoint(t *testing.T) {
+	errConfigure := errors.New("configure failed")
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name      string
+		ctx       context.Context
+		failNext  error
+		reachable bool
+		verify    time.Duration
+		err       error
+	}{
+		{name: "applied", ctx: context.Background()},
+		{name: "verified", ctx: context.Background(), reachable: true, verify: time.Second},
+		{name: "configure failure", ctx: context.Background(), failNext: errConfigure, err: errConfigure},
+		{name: "no handshake", ctx: context.Background(), verify: 300 * time.Millisecond, err: ErrNoHandshake},
+		{name: "canceled", ctx: canceled, verify: time.Second, err: context.Canceled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, wg, peer := newTraversalDevice(t)
+			if tt.reachable {
+				f.SetReachable("198.51.100.7:40000")
+			}
+			if tt.failNext != nil {
+				f.FailNext(tt.failNext)
+			}
+
+			err := wg.ApplyEndpoint(tt.ctx, EndpointConfig{
+				Peer:       peer.String(),
+				IP:         "198.51.100.7",
+				Port:       40000,
+				ListenPort: 40001,
+				Keepalive:  10 * time.Second,
+			}, tt.verify)
+
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got %v, want %v", err, tt.err)
+			}
+			if tt.err == nil {
+				checkDevice(t, wg, peer, 40001, "198.51.100.7:40000", 10*time.Second)
+				return
+			}
+			checkDevice(t, wg, peer, 51820, "192.0.2.1:51820", DefaultKeepalive)
+			// the change and its rollback
+			if n := len(f.Configs()); n != 2 {
+				t.Errorf("got %d configuration requests, want 2", n)
+			}
+		})
+	}
+}
+
+// TestRestore checks the restoring of the state saved at startup,
+// as done on exit after any number of traversals.
+func TestRestore(t *testing.T) {
+	f, wg, peer := newTraversalDevice(t)
+	s, err := wg.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.SetReachable("198.51.100.7:40000")
+	for _, port := range []int{40001, 40002} {
+		err := wg.ApplyEndpoint(context.Background(), EndpointConfig{
+			Peer:       peer.String(),
+			IP:         "198.51.100.7",
+			Port:       40000,
+			ListenPort: port,
+			Keepalive:  MinKeepalive,
+		}, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wg.SetPeerKeepalive(peer.String(), 15*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wg.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	checkDevice(t, wg, peer, 51820, "192.0.2.1:51820", DefaultKeepalive)
+}
@@ -0,0 +1,278 @@
+//go:build e2e && linux
+
+// Package e2e runs wgnt-server and wgnt-client with kernel Wireguard
+// in network namespaces behind nftables NATs, all on one machine.
+//
+//	sudo go test -tags e2e ./e2e/
+//
+// The tests are skipped unless run as root with ip, nft and wg installed
+// and Wireguard supported by the kernel.
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nohajc/wg-nat-traversal/common/nat"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	iface = "wgnt0"
+	// tunnel addresses of the sides
+	tunnelA = "10.77.0.1"
+	tunnelB = "10.77.0.2"
+
+	handshakeTimeout = 60 * time.Second
+)
+
+// directory of the built binaries, empty if the environment is not suitable
+var binDir string
+
+// why the tests are skipped
+var skipReason string
+
+func TestMain(m *testing.M) {
+	os.Exit(run(m))
+}
+
+func run(m *testing.M) int {
+	if skipReason = checkEnv(); skipReason != "" {
+		return m.Run()
+	}
+
+	dir, err := os.MkdirTemp("", "wgnt-e2e")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("go", "build", "-o", dir,
+		"github.com/nohajc/wg-nat-traversal/cmd/wgnt-server",
+		"github.com/nohajc/wg-nat-traversal/cmd/wgnt-client",
+	)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error building binaries: %v\n", err)
+		return 1
+	}
+	binDir = dir
+	return m.Run()
+}
+
+// checkEnv returns why the tests cannot run, empty if they can.
+func checkEnv() string {
+	if os.Geteuid() != 0 {
+		return "not running as root"
+	}
+	for _, tool := range []string{"ip", "nft", "wg"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return tool + " not found"
+		}
+	}
+
+	// the kernel module is checked in a throwaway namespace
+	ns := fmt.Sprintf("wgnt-e2e%d-check", os.Getpid())
+	if err := ip("netns", "add", ns); err != nil {
+		return err.Error()
+	}
+	defer ip("netns", "del", ns)
+	if err := ip("-n", ns, "link", "add", iface, "type", "wireguard"); err != nil {
+		return "kernel Wireguard not available: " + err.Error()
+	}
+	return ""
+}
+
+func requireEnv(t *testing.T) {
+	t.Helper()
+	if skipReason != "" {
+		t.Skip(skipReason)
+	}
+}
+
+// startServices runs wgnt-server and two STUN servers in the wan namespace.
+func startServices(tp *topology) {
+	t := tp.t
+	t.Helper()
+
+	for _, addr := range stunServers() {
+		var conn net.PacketConn
+		err := tp.inNetns("wan", func() (err error) {
+			conn, err = net.ListenPacket("udp", addr)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			conn.Close()
+		})
+		go nat.ServeSTUN(conn)
+	}
+
+	listen := net.JoinHostPort(serverIP, "8080")
+	tp.start("wan", "wgnt-server", filepath.Join(binDir, "wgnt-server"), "-l", listen)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		err := tp.inNetns("wan", func() error {
+			conn, err := net.DialTimeout("tcp", listen, time.Second)
+			if err == nil {
+				conn.Close()
+			}
+			return err
+		})
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wgnt-server not listening: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func stunServers() []string {
+	return []string{
+		net.JoinHostPort(serverIP, strconv.Itoa(stunPort)),
+		net.JoinHostPort(stunIP2, strconv.Itoa(stunPort)),
+	}
+}
+
+// clientConfig creates the interface with a tunnel address and the other side as the peer.
+func clientConfig(daemon bool, keyFile, address string, peer wgtypes.Key, peerAddress string) string {
+	return fmt.Sprintf(`server: http://%s:8080/
+interface: %s
+daemon: %t
+control: "off"
+stun_servers: [%s]
+traversal:
+  keepalive: 1s
+  timeout: 30s
+setup:
+  create: true
+  private_key: %s
+  addresses: [%s/24]
+  peers:
+    - public_key: %s
+      allowed_ips: [%s/32]
+      keepalive: 1s
+`, serverIP, iface, daemon, strings.Join(stunServers(), ", "), keyFile, address, peer, peerAddress)
+}
+
+func writeKey(t *testing.T, dir, name string) (string, wgtypes.Key) {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name+".key")
+	if err := os.WriteFile(path, []byte(key.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path, key
+}
+
+func writeConfig(t *testing.T, dir, name, config string) string {
+	t.Helper()
+	path := filepath.Join(dir, name+".yaml")
+	if err := os.WriteFile(path, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// latestHandshake returns the time of the last handshake with the peer
+// according to wg, zero if there was none.
+func (tp *topology) latestHandshake(role string, peer wgtypes.Key) (time.Time, error) {
+	out, err := tp.run(role, "", "wg", "show", iface, "latest-handshakes")
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != peer.String() {
+			continue
+		}
+		sec, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if sec == 0 {
+			return time.Time{}, nil
+		}
+		return time.Unix(sec, 0), nil
+	}
+	return time.Time{}, errors.New("peer not found")
+}
+
+func (tp *topology) waitForHandshake(role string, peer wgtypes.Key) {
+	t := tp.t
+	t.Helper()
+	deadline := time.Now().Add(handshakeTimeout)
+	for {
+		hs, err := tp.latestHandshake(role, peer)
+		if err == nil && !hs.IsZero() {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no handshake in %s after %s (%v)", role, handshakeTimeout, err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// TestHandshake connects a client (A) to a daemon (B) through NATs
+// with different port allocation and checks that Wireguard on both sides
+// completes a handshake over the punched holes.
+func TestHandshake(t *testing.T) {
+	requireEnv(t)
+
+	tests := []struct {
+		name       string
+		natA, natB string
+	}{
+		// ports are kept, so both NATs are easy
+		{"masquerade", "masquerade", "masquerade"},
+		{"persistent", "masquerade persistent", "masquerade persistent"},
+		// a random port per destination makes the NAT hard
+		{"random", "masquerade", "masquerade random"},
+		{"fully-random", "masquerade fully-random", "masquerade"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tp := newTopology(t, tt.natA, tt.natB)
+			startServices(tp)
+
+			dir := t.TempDir()
+			keyA, privA := writeKey(t, dir, "a")
+			keyB, privB := writeKey(t, dir, "b")
+			pubA, pubB := privA.PublicKey(), privB.PublicKey()
+			client := filepath.Join(binDir, "wgnt-client")
+
+			cfgB := writeConfig(t, dir, "b", clientConfig(true, keyB, tunnelB, pubA, tunnelA))
+			tp.start("host-b", "wgnt-client B", client, "-c", cfgB)
+
+			cfgA := writeConfig(t, dir, "a", clientConfig(false, keyA, tunnelA, pubB, tunnelB))
+			a := tp.start("host-a", "wgnt-client A", client, "-c", cfgA)
+			// outside of daemon mode, the client exits after a successful traversal
+			if err := a.wait(handshakeTimeout); err != nil {
+				t.Fatalf("wgnt-client A: %v", err)
+			}
+
+			tp.waitForHandshake("host-a", pubB)
+			tp.waitForHandshake("host-b", pubA)
+		})
+	}
+}
@@ -0,0 +1,237 @@
+//go:build e2e && linux
+
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Topology of a test (namespaces and their addresses):
+//
+//	host-a 192.168.1.2 - 192.168.1.1 nat-a 100.64.1.2 - 100.64.1.1 wan
+//	host-b 192.168.1.2 - 192.168.1.1 nat-b 100.64.2.2 - 100.64.2.1 wan
+//
+// The wan namespace routes between the NATs and runs wgnt-server
+// and the STUN servers on its loopback addresses.
+const (
+	serverIP  = "203.0.113.1"
+	stunIP2   = "203.0.113.2"
+	stunPort  = 3478
+	hostIP    = "192.168.1.2"
+	gatewayIP = "192.168.1.1"
+)
+
+// Like a home router, the NAT drops unsolicited packets for itself. Accepting them
+// would create conntrack entries clashing with the mappings of punched holes.
+const nftRuleset = `table ip nat {
+	chain postrouting {
+		type nat hook postrouting priority srcnat; policy accept;
+		oifname "wan" %s
+	}
+	chain input {
+		type filter hook input priority filter; policy accept;
+		iifname "wan" drop
+	}
+}
+`
+
+var topologies atomic.Int32
+
+type topology struct {
+	t      *testing.T
+	prefix string
+}
+
+func (tp *topology) ns(role string) string {
+	return tp.prefix + role
+}
+
+// command runs in the namespace of the role.
+func (tp *topology) command(ctx context.Context, role string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, "ip", append([]string{"netns", "exec", tp.ns(role)}, args...)...)
+}
+
+func (tp *topology) run(role, stdin string, args ...string) (string, error) {
+	cmd := tp.command(context.Background(), role, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s in %s: %w: %s", strings.Join(args, " "), role, err, bytes.TrimSpace(out))
+	}
+	return string(out), nil
+}
+
+func ip(args ...string) error {
+	out, err := exec.Command("ip", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ip %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+// newTopology creates the namespaces with the masquerade statements of the NATs,
+// e.g. "masquerade fully-random". Everything is deleted when the test ends.
+func newTopology(t *testing.T, natA, natB string) *topology {
+	t.Helper()
+	tp := &topology{
+		t:      t,
+		prefix: fmt.Sprintf("wgnt-e2e%d-%d-", os.Getpid(), topologies.Add(1)),
+	}
+
+	for _, role := range []string{"wan", "nat-a", "host-a", "nat-b", "host-b"} {
+		ns := tp.ns(role)
+		if err := ip("netns", "add", ns); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			ip("netns", "del", ns)
+		})
+	}
+
+	wan := tp.ns("wan")
+	cmds := [][]string{
+		{"-n", wan, "link", "set", "dev", "lo", "up"},
+		{"-n", wan, "addr", "add", serverIP + "/32", "dev", "lo"},
+		{"-n", wan, "addr", "add", stunIP2 + "/32", "dev", "lo"},
+	}
+	for i, l := range []string{"a", "b"} {
+		natNS, host := tp.ns("nat-"+l), tp.ns("host-"+l)
+		wanIP := fmt.Sprintf("100.64.%d.1", i+1)
+		cmds = append(cmds,
+			[]string{"link", "add", "wan", "netns", natNS, "type", "veth", "peer", "name", "to-" + l, "netns", wan},
+			[]string{"link", "add", "lan", "netns", natNS, "type", "veth", "peer", "name", "eth0", "netns", host},
+			[]string{"-n", wan, "addr", "add", wanIP + "/24", "dev", "to-" + l},
+			[]string{"-n", wan, "link", "set", "dev", "to-" + l, "up"},
+			[]string{"-n", natNS, "addr", "add", fmt.Sprintf("100.64.%d.2/24", i+1), "dev", "wan"},
+			[]string{"-n", natNS, "addr", "add", gatewayIP + "/24", "dev", "lan"},
+			[]string{"-n", natNS, "link", "set", "dev", "wan", "up"},
+			[]string{"-n", natNS, "link", "set", "dev", "lan", "up"},
+			[]string{"-n", natNS, "route", "add", "default", "via", wanIP},
+			[]string{"-n", host, "addr", "add", hostIP + "/24", "dev", "eth0"},
+			[]string{"-n", host, "link", "set", "dev", "eth0", "up"},
+			[]string{"-n", host, "link", "set", "dev", "lo", "up"},
+			[]string{"-n", host, "route", "add", "default", "via", gatewayIP},
+		)
+	}
+	for _, args := range cmds {
+		if err := ip(args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, role := range []string{"wan", "nat-a", "nat-b"} {
+		if _, err := tp.run(role, "", "sysctl", "-qw", "net.ipv4.ip_forward=1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for role, stmt := range map[string]string{"nat-a": natA, "nat-b": natB} {
+		if _, err := tp.run(role, fmt.Sprintf(nftRuleset, stmt), "nft", "-f", "-"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tp
+}
+
+// inNetns runs fn on an OS thread switched to the namespace of the role.
+// Sockets opened by fn stay in the namespace.
+func (tp *topology) inNetns(role string, fn func() error) error {
+	errc := make(chan error, 1)
+	go func() {
+		// the thread is never unlocked, so it exits with the goroutine
+		// instead of going back to the scheduler in the wrong namespace
+		runtime.LockOSThread()
+
+		f, err := os.Open(filepath.Join("/var/run/netns", tp.ns(role)))
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer f.Close()
+		if err := unix.Setns(int(f.Fd()), unix.CLONE_NEWNET); err != nil {
+			errc <- fmt.Errorf("setns %s: %w", tp.ns(role), err)
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+// process is a command running in a namespace until the test ends.
+// Its output is logged if the test fails.
+type process struct {
+	name string
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+
+	mu  sync.Mutex
+	out bytes.Buffer
+}
+
+func (p *process) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.Write(b)
+}
+
+func (p *process) output() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.String()
+}
+
+// wait waits for the process to exit.
+func (p *process) wait(timeout time.Duration) error {
+	select {
+	case <-p.done:
+		return p.err
+	case <-time.After(timeout):
+		return fmt.Errorf("%s still running after %s", p.name, timeout)
+	}
+}
+
+func (tp *topology) start(role, name string, args ...string) *process {
+	t := tp.t
+	t.Helper()
+	p := &process{
+		name: name,
+		cmd:  tp.command(context.Background(), role, args...),
+		done: make(chan struct{}),
+	}
+	p.cmd.Stdout = p
+	p.cmd.Stderr = p
+	if err := p.cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		p.err = p.cmd.Wait()
+		close(p.done)
+	}()
+
+	t.Cleanup(func() {
+		p.cmd.Process.Signal(unix.SIGTERM)
+		select {
+		case <-p.done:
+		case <-time.After(5 * time.Second):
+			p.cmd.Process.Kill()
+			<-p.done
+		}
+		if t.Failed() {
+			t.Logf("output of %s:\n%s", name, p.output())
+		}
+	})
+	return p
+}